@@ -0,0 +1,112 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// LogCollectorSpec 按标签选择同namespace中的pod采集日志文件，与pod上的logfile.huisebug.org注解一起生效
+// 注入sidecar时读取，修改后对新创建的pod生效
+type LogCollectorSpec struct {
+	// 选择pod的标签，为空时选择namespace中的所有pod
+	Selector metav1.LabelSelector `json:"selector"`
+	// 采集规则，每条规则生成一个filebeat input，与pod注解中同名的路由以注解为准
+	//+kubebuilder:validation:MinItems=1
+	//+listType=map
+	//+listMapKey=name
+	Routes []LogCollectorRoute `json:"routes"`
+	// 写入日志的pod标签，不设置时写入所有标签，pod上的logfile.huisebug.org/kubernetes.labels注解优先
+	MetadataLabels []string `json:"metadataLabels,omitempty"`
+	// 写入日志的pod注解，pod上的logfile.huisebug.org/kubernetes.annotations注解优先
+	MetadataAnnotations []string `json:"metadataAnnotations,omitempty"`
+}
+
+// LogCollectorRoute 一组日志文件的采集、解析和写入配置，与注解 logfile.huisebug.org/<name>.* 相同
+type LogCollectorRoute struct {
+	// 路由名称，写入日志的fields.logfile_route
+	//+kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+	// 容器中日志文件的绝对路径，支持通配符
+	//+kubebuilder:validation:MinItems=1
+	Paths []string `json:"paths"`
+	// 写入的索引，实际索引名称为 logfile-operator-<index>，不设置时写入默认索引
+	//+kubebuilder:validation:Pattern=`^[a-z0-9][a-z0-9_-]*$`
+	Index string `json:"index,omitempty"`
+	// 写入的kafka topic，实际topic为 logfile-operator-<topic>，不设置时写入kafka_log
+	//+kubebuilder:validation:Pattern=`^[a-z0-9][a-z0-9_-]*$`
+	Topic string `json:"topic,omitempty"`
+	// 多行日志
+	Multiline *Multiline `json:"multiline,omitempty"`
+	// 按json解析每一行，字段放在事件的顶层
+	JSONKeysUnderRoot bool `json:"jsonKeysUnderRoot,omitempty"`
+	// 只采集匹配正则的行
+	IncludeLines []string `json:"includeLines,omitempty"`
+	// 不采集匹配正则的行
+	ExcludeLines []string `json:"excludeLines,omitempty"`
+	// filebeat的processors，每一项只能包含一个FilebeatProcessors中的processor
+	//+kubebuilder:pruning:PreserveUnknownFields
+	Processors []runtime.RawExtension `json:"processors,omitempty"`
+}
+
+// Multiline 多行日志的合并方式
+type Multiline struct {
+	// 起始行的正则
+	Pattern string `json:"pattern"`
+	// 为true时不匹配正则的行合并到起始行，默认true
+	Negate *bool `json:"negate,omitempty"`
+	//+kubebuilder:validation:Enum=after;before
+	Match string `json:"match,omitempty"`
+}
+
+// FilebeatProcessors 允许在日志路由中使用的filebeat processor
+var FilebeatProcessors = []string{
+	"add_fields", "add_labels", "add_tags", "convert", "copy_fields", "decode_base64_field",
+	"decode_json_fields", "dissect", "drop_event", "drop_fields", "include_fields", "rename",
+	"replace", "timestamp", "truncate_fields", "urldecode",
+}
+
+// LogCollectorStatus defines the observed state of LogCollector
+type LogCollectorStatus struct {
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// LogCollector is the Schema for the logcollectors API
+type LogCollector struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LogCollectorSpec   `json:"spec,omitempty"`
+	Status LogCollectorStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LogCollectorList contains a list of LogCollector
+type LogCollectorList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LogCollector `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LogCollector{}, &LogCollectorList{})
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var logcollectorlog = logf.Log.WithName("logcollector-resource")
+
+func (r *LogCollector) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-api-huisebug-org-v1-logcollector,mutating=false,failurePolicy=fail,sideEffects=None,groups=api.huisebug.org,resources=logcollectors,verbs=create;update,versions=v1,name=vlogcollector.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &LogCollector{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *LogCollector) ValidateCreate() error {
+	logcollectorlog.Info("validate create", "name", r.Name)
+	return r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *LogCollector) ValidateUpdate(old runtime.Object) error {
+	logcollectorlog.Info("validate update", "name", r.Name)
+	return r.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *LogCollector) ValidateDelete() error {
+	logcollectorlog.Info("validate delete", "name", r.Name)
+	return nil
+}
+
+// validate 校验标签选择器和各路由的正则、processors，与pod注解的校验规则一致
+func (r *LogCollector) validate() error {
+	var allErrs field.ErrorList
+	specpath := field.NewPath("Spec")
+	if _, err := metav1.LabelSelectorAsSelector(&r.Spec.Selector); err != nil {
+		allErrs = append(allErrs, field.Invalid(specpath.Child("Selector"), r.Spec.Selector, err.Error()))
+	}
+	for i, route := range r.Spec.Routes {
+		routepath := specpath.Child("Routes").Index(i)
+		for j, logpath := range route.Paths {
+			allErrs = append(allErrs, ValidateLogPath(routepath.Child("Paths").Index(j), logpath)...)
+		}
+		if route.Multiline != nil {
+			allErrs = append(allErrs, validateRegExp(routepath.Child("Multiline").Child("Pattern"), route.Multiline.Pattern)...)
+		}
+		for j, line := range route.IncludeLines {
+			allErrs = append(allErrs, validateRegExp(routepath.Child("IncludeLines").Index(j), line)...)
+		}
+		for j, line := range route.ExcludeLines {
+			allErrs = append(allErrs, validateRegExp(routepath.Child("ExcludeLines").Index(j), line)...)
+		}
+		for j, processor := range route.Processors {
+			allErrs = append(allErrs, ValidateFilebeatProcessor(routepath.Child("Processors").Index(j), processor.Raw)...)
+		}
+	}
+	for i, key := range r.Spec.MetadataLabels {
+		for _, msg := range validation.IsQualifiedName(key) {
+			allErrs = append(allErrs, field.Invalid(specpath.Child("MetadataLabels").Index(i), key, msg))
+		}
+	}
+	for i, key := range r.Spec.MetadataAnnotations {
+		for _, msg := range validation.IsQualifiedName(key) {
+			allErrs = append(allErrs, field.Invalid(specpath.Child("MetadataAnnotations").Index(i), key, msg))
+		}
+	}
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: "apiv1.huisebug.org", Kind: "LogCollector"},
+		r.Name,
+		allErrs)
+}
+
+// LogPathRegExp 日志文件路径只能使用字母、数字和 / . _ - * ? [ ] @ + = : ~ %，通配符按filebeat的glob匹配
+// 不允许引号、空白、换行等字符，避免路径破坏生成的filebeat配置
+var LogPathRegExp = regexp.MustCompile(`^/[a-zA-Z0-9/._*?\[\]@+=:~%-]*$`)
+
+// ValidateLogPath 校验日志文件路径
+func ValidateLogPath(fldPath *field.Path, logpath string) field.ErrorList {
+	var allErrs field.ErrorList
+	switch {
+	case !strings.HasPrefix(logpath, "/"):
+		allErrs = append(allErrs, field.Invalid(fldPath, logpath, "日志文件路径需要为绝对路径"))
+	case !LogPathRegExp.MatchString(logpath):
+		allErrs = append(allErrs, field.Invalid(fldPath, logpath, "日志文件路径只能使用字母、数字和 / . _ - * ? [ ] @ + = : ~ %"))
+	}
+	return allErrs
+}
+
+// validateRegExp 校验filebeat使用的正则
+func validateRegExp(fldPath *field.Path, value string) field.ErrorList {
+	var allErrs field.ErrorList
+	if _, err := regexp.Compile(value); err != nil || value == "" {
+		allErrs = append(allErrs, field.Invalid(fldPath, value, "不是有效的正则表达式"))
+	}
+	return append(allErrs, ValidateNoFilebeatVariables(fldPath, value)...)
+}
+
+// ValidateNoFilebeatVariables 校验值中没有filebeat展开的${}变量
+// sidecar的环境变量中有elasticsearch的写入密码，例如add_fields中的${LOGFILE_ES_PASSWORD}会把密码写入每条日志
+func ValidateNoFilebeatVariables(fldPath *field.Path, value interface{}) field.ErrorList {
+	var allErrs field.ErrorList
+	switch v := value.(type) {
+	case string:
+		if strings.Contains(v, "${") {
+			allErrs = append(allErrs, field.Invalid(fldPath, v, "不允许使用${}变量"))
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			allErrs = append(allErrs, ValidateNoFilebeatVariables(fldPath, key)...)
+			allErrs = append(allErrs, ValidateNoFilebeatVariables(fldPath.Key(key), item)...)
+		}
+	case map[interface{}]interface{}:
+		for key, item := range v {
+			allErrs = append(allErrs, ValidateNoFilebeatVariables(fldPath, key)...)
+			allErrs = append(allErrs, ValidateNoFilebeatVariables(fldPath.Key(fmt.Sprint(key)), item)...)
+		}
+	case []interface{}:
+		for i, item := range v {
+			allErrs = append(allErrs, ValidateNoFilebeatVariables(fldPath.Index(i), item)...)
+		}
+	}
+	return allErrs
+}
+
+// ValidateFilebeatProcessor 校验processor，只能包含一个FilebeatProcessors中的processor
+func ValidateFilebeatProcessor(fldPath *field.Path, raw []byte) field.ErrorList {
+	var allErrs field.ErrorList
+	processor := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &processor); err != nil {
+		return append(allErrs, field.Invalid(fldPath, string(raw), err.Error()))
+	}
+	if len(processor) != 1 {
+		return append(allErrs, field.Invalid(fldPath, string(raw), "每一项只能包含一个processor"))
+	}
+	for name := range processor {
+		supported := false
+		for _, p := range FilebeatProcessors {
+			if name == p {
+				supported = true
+			}
+		}
+		if !supported {
+			allErrs = append(allErrs, field.NotSupported(fldPath, name, FilebeatProcessors))
+		}
+	}
+	return append(allErrs, ValidateNoFilebeatVariables(fldPath, processor)...)
+}
@@ -0,0 +1,111 @@
+package v1
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func testLogCollector() *LogCollector {
+	return &LogCollector{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "app"},
+		Spec: LogCollectorSpec{
+			Selector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "nginx"}},
+			Routes: []LogCollectorRoute{{
+				Name:         "access",
+				Paths:        []string{"/var/log/nginx/access*.log"},
+				Index:        "nginx",
+				IncludeLines: []string{"^GET"},
+				Processors:   []runtime.RawExtension{{Raw: []byte(`{"drop_fields":{"fields":["agent"]}}`)}},
+			}},
+			MetadataLabels: []string{"app", "app.kubernetes.io/version"},
+		},
+	}
+}
+
+func TestLogCollectorValidate(t *testing.T) {
+	if err := testLogCollector().ValidateCreate(); err != nil {
+		t.Fatalf("ValidateCreate() = %v, want nil", err)
+	}
+
+	// 每项只改一个配置，错误信息中应包含对应的字段
+	invalid := []struct {
+		field  string
+		mutate func(*LogCollector)
+	}{
+		{"Spec.Routes[0].Paths[0]", func(c *LogCollector) { c.Spec.Routes[0].Paths = []string{"logs/app.log"} }},
+		{"Spec.Routes[0].Paths[0]", func(c *LogCollector) { c.Spec.Routes[0].Paths = []string{`/var/log/"app".log`} }},
+		{"Spec.Routes[0].Multiline.Pattern", func(c *LogCollector) { c.Spec.Routes[0].Multiline = &Multiline{Pattern: "(["} }},
+		{"Spec.Routes[0].ExcludeLines[0]", func(c *LogCollector) { c.Spec.Routes[0].ExcludeLines = []string{""} }},
+		// 不支持的processor，以及一项中有多个processor
+		{"Spec.Routes[0].Processors[0]", func(c *LogCollector) {
+			c.Spec.Routes[0].Processors = []runtime.RawExtension{{Raw: []byte(`{"script":{"source":"x"}}`)}}
+		}},
+		{"Spec.Routes[0].Processors[0]", func(c *LogCollector) {
+			c.Spec.Routes[0].Processors = []runtime.RawExtension{{Raw: []byte(`{"drop_fields":{"fields":["a"]},"rename":{"fields":[]}}`)}}
+		}},
+		{"Spec.Selector", func(c *LogCollector) {
+			c.Spec.Selector = metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Like"}}}
+		}},
+		{"Spec.MetadataLabels[0]", func(c *LogCollector) { c.Spec.MetadataLabels = []string{"app version"} }},
+	}
+	for i, tt := range invalid {
+		collector := testLogCollector()
+		tt.mutate(collector)
+		err := collector.ValidateCreate()
+		if err == nil || !strings.Contains(err.Error(), tt.field) {
+			t.Errorf("case %d: ValidateCreate() = %v, want error on %s", i, err, tt.field)
+		}
+	}
+}
+
+func TestValidateLogPath(t *testing.T) {
+	tests := []struct {
+		logpath string
+		valid   bool
+	}{
+		{logpath: "/var/log/nginx/*.log", valid: true},
+		{logpath: "/data/logs/app-[0-9]?.log", valid: true},
+		{logpath: "/var/log/app@2x/~user/a+b=c:d%e.log", valid: true},
+		{logpath: "var/log/app.log"},
+		{logpath: "/var/log/app log"},
+		{logpath: "/var/log/app.log\nprocessors: []"},
+		{logpath: `/var/log/"app".log`},
+		{logpath: "/var/log/'app'.log"},
+		{logpath: "/var/log/$(id).log"},
+		{logpath: "/var/log/app.log;rm"},
+		{logpath: "/var/log/{a,b}.log"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.logpath, func(t *testing.T) {
+			errs := ValidateLogPath(field.NewPath("path"), tt.logpath)
+			if valid := len(errs) == 0; valid != tt.valid {
+				t.Errorf("ValidateLogPath(%q) = %v, want valid %v", tt.logpath, errs, tt.valid)
+			}
+		})
+	}
+}
+
+func TestLogCollectorRejectsFilebeatVariables(t *testing.T) {
+	collector := testLogCollector()
+	collector.Spec.Routes[0].ExcludeLines = []string{"^${LOGFILE_ES_PASSWORD}"}
+	collector.Spec.Routes[0].Processors = []runtime.RawExtension{
+		{Raw: []byte(`{"add_fields":{"target":"","fields":{"leak":"${LOGFILE_ES_PASSWORD}"}}}`)},
+		{Raw: []byte(`{"drop_fields":{"fields":["agent"],"ignore_missing":true}}`)},
+	}
+	err := collector.ValidateCreate()
+	if err == nil {
+		t.Fatal("ValidateCreate() = nil, want error for ${} variables")
+	}
+	for _, fieldpath := range []string{"Spec.Routes[0].ExcludeLines[0]", "Spec.Routes[0].Processors[0][add_fields][fields][leak]"} {
+		if !strings.Contains(err.Error(), fieldpath) {
+			t.Errorf("ValidateCreate() = %v, want error on %s", err, fieldpath)
+		}
+	}
+	if strings.Contains(err.Error(), "Processors[1]") {
+		t.Errorf("ValidateCreate() = %v, processor without variables is rejected", err)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -25,27 +26,464 @@ import (
 
 // LogFileSpec defines the desired state of LogFile
 type LogFileSpec struct {
-	// 方案序号
-	ProgrammeNum int `json:"programmenum,"`
+	// 组件部署的namespace，不设置时部署在LogFile所在的namespace，每个namespace只能部署一套组件
+	TargetNamespace string `json:"targetNamespace,omitempty"`
+	// 允许注入sidecar写入该组件的namespace标签选择器，匹配的namespace才会复制filebeat密码、拉取镜像的secret和ca证书
+	// 不设置时只允许组件所在的namespace，部署在logfile-operator-system的组件兼容旧版本允许所有namespace
+	AllowedNamespaces *metav1.LabelSelector `json:"allowedNamespaces,omitempty"`
 
-	// 密码认证
-	ELASTIC_PASSWORD string `json:"elastic_password,"`
-	KIBANA_PASSWORD  string `json:"kibana_password,"`
+	// 方案序号，兼容原有的6种部署方案，由defaulting webhook展开为elasticsearch、kafka、logstash的配置
+	// 不设置时按elasticsearch、kafka、logstash的配置部署
+	ProgrammeNum int `json:"programmenum,omitempty"`
+
+	// elasticsearch的部署模式
+	Elasticsearch *ElasticsearchSpec `json:"elasticsearch,omitempty"`
+	// kafka的部署模式，filebeat先将日志写入kafka，再由logstash消费写入elasticsearch
+	Kafka *KafkaSpec `json:"kafka,omitempty"`
+	// 是否部署logstash，filebeat将日志发送给logstash处理后再写入elasticsearch
+	Logstash *LogstashSpec `json:"logstash,omitempty"`
+
+	// 密码认证，已废弃，明文密码会保存在LogFile中，请使用ElasticPasswordSecretRef和KibanaPasswordSecretRef
+	ELASTIC_PASSWORD string `json:"elastic_password,omitempty"`
+	KIBANA_PASSWORD  string `json:"kibana_password,omitempty"`
+	// elastic用户密码所在的secret，需要与LogFile在同一namespace，未设置时自动生成随机密码
+	ElasticPasswordSecretRef *corev1.SecretKeySelector `json:"elasticPasswordSecretRef,omitempty"`
+	// kibana_system用户密码所在的secret，需要与LogFile在同一namespace，未设置时自动生成随机密码
+	KibanaPasswordSecretRef *corev1.SecretKeySelector `json:"kibanaPasswordSecretRef,omitempty"`
 	// 服务持久化使用的storageclass
 	StorageClassName string `json:"storageClassName,"`
 	// 申请空间大小
 	ResourceStorage *ResourceStorage `json:"resourcestorage,omitempty"`
 	// 暴露主机端口服务
 	NodePortS *NodePortS `json:"nodePorts,omitempty"`
+	// 各组件副本数
+	Replicas *Replicas `json:"replicas,omitempty"`
+	// elasticsearch、kibana、logstash、filebeat的版本，支持8.x，默认8.5.0
+	// 修改后先滚动升级elasticsearch，全部完成后再升级其他组件
+	//+kubebuilder:validation:Pattern=`^[0-9]+\.[0-9]+\.[0-9]+$`
+	Version string `json:"version,omitempty"`
+	// 各组件使用的镜像，不设置时使用operator的默认镜像
+	Images *Images `json:"images,omitempty"`
+	// 各组件pod的资源和调度配置，合并到operator生成的pod模板上
+	PodOverrides *PodOverrides `json:"podOverrides,omitempty"`
+	// 删除LogFile时是否保留elasticsearch、kafka、zookeeper的pvc，保留时重新创建同名组件会继续使用原有数据
+	//+kubebuilder:validation:Enum=Delete;Retain
+	RetainPolicy string `json:"retainPolicy,omitempty"`
+	// 日志保留策略，设置后operator创建ILM策略和索引模板，日志写入按大小或时间滚动的data stream
+	// 不设置时按天写入索引，不会自动删除
+	Retention *RetentionSpec `json:"retention,omitempty"`
+	// 注入到业务pod的filebeat sidecar的配置
+	Sidecar *SidecarSpec `json:"sidecar,omitempty"`
+}
+
+// SidecarSpec filebeat sidecar和初始化容器的安全配置，namespace上的logfile.huisebug.org/sidecar-security-profile注解优先
+type SidecarSpec struct {
+	// restricted: 非root用户、只读根文件系统、不使用任何capabilities，满足PodSecurity restricted
+	// baseline: root用户、只读根文件系统，只保留DAC_OVERRIDE用于读取其他用户的日志文件，满足PodSecurity baseline
+	// privileged: 特权容器，兼容旧版本
+	// 默认restricted
+	//+kubebuilder:validation:Enum=restricted;baseline;privileged
+	SecurityProfile string `json:"securityProfile,omitempty"`
+	// restricted时运行filebeat的用户，业务容器写入的日志文件只有属主可读时设置为业务容器的用户，默认1000
+	//+kubebuilder:validation:Minimum=1
+	RunAsUser *int64 `json:"runAsUser,omitempty"`
+}
+
+// sidecar的安全配置
+const (
+	SecurityProfileRestricted = "restricted"
+	SecurityProfileBaseline   = "baseline"
+	SecurityProfilePrivileged = "privileged"
+)
+
+// DefaultSidecarRunAsUser filebeat镜像中filebeat用户的uid
+const DefaultSidecarRunAsUser int64 = 1000
+
+// SidecarSecurityProfile 返回sidecar的安全配置和restricted时运行的用户
+func (s *LogFileSpec) SidecarSecurityProfile() (string, int64) {
+	profile, runAsUser := SecurityProfileRestricted, DefaultSidecarRunAsUser
+	if s.Sidecar != nil {
+		if s.Sidecar.SecurityProfile != "" {
+			profile = s.Sidecar.SecurityProfile
+		}
+		if s.Sidecar.RunAsUser != nil {
+			runAsUser = *s.Sidecar.RunAsUser
+		}
+	}
+	return profile, runAsUser
+}
+
+// RetentionSpec 日志的滚动、分层和删除策略
+type RetentionSpec struct {
+	// 当前写入的索引主分片达到该大小后滚动，默认50gb
+	//+kubebuilder:validation:Pattern=`^[0-9]+(b|kb|mb|gb|tb|pb)$`
+	RolloverSize string `json:"rolloverSize,omitempty"`
+	// 当前写入的索引创建超过该时间后滚动，默认1d
+	//+kubebuilder:validation:Pattern=`^[0-9]+(d|h|m|s)$`
+	RolloverAge string `json:"rolloverAge,omitempty"`
+	// 索引滚动后超过该天数移动到data_warm节点，为0时不使用warm阶段
+	//+kubebuilder:validation:Minimum=0
+	WarmAfterDays int32 `json:"warmAfterDays,omitempty"`
+	// 索引滚动后超过该天数移动到data_cold节点，为0时不使用cold阶段
+	//+kubebuilder:validation:Minimum=0
+	ColdAfterDays int32 `json:"coldAfterDays,omitempty"`
+	// 索引滚动后超过该天数删除
+	//+kubebuilder:validation:Minimum=1
+	DeleteAfterDays int32 `json:"deleteAfterDays"`
+}
+
+// 默认的索引滚动条件
+const (
+	DefaultRolloverSize = "50gb"
+	DefaultRolloverAge  = "1d"
+)
+
+// 组件名称，与主容器名称一致
+const (
+	ComponentElasticsearch = "elasticsearch"
+	ComponentKibana        = "kibana"
+	ComponentLogstash      = "logstash"
+	ComponentKafka         = "kafka"
+	ComponentZookeeper     = "zookeeper"
+	ComponentFilebeat      = "filebeat"
+)
+
+// Images 各组件的完整镜像地址，设置后不再使用operator的镜像仓库配置
+type Images struct {
+	Elasticsearch string `json:"elasticsearch,omitempty"`
+	Kibana        string `json:"kibana,omitempty"`
+	Logstash      string `json:"logstash,omitempty"`
+	Kafka         string `json:"kafka,omitempty"`
+	Zookeeper     string `json:"zookeeper,omitempty"`
+	// 注入到业务pod的filebeat sidecar
+	Filebeat string `json:"filebeat,omitempty"`
+	// 拉取镜像的secret，组件namespace中必须存在，注入sidecar时会复制到业务pod所在的namespace
+	PullSecrets []corev1.LocalObjectReference `json:"pullSecrets,omitempty"`
+}
+
+// Image 返回LogFile中指定的组件镜像，未设置时返回空
+func (s *LogFileSpec) Image(component string) string {
+	if s.Images == nil {
+		return ""
+	}
+	switch component {
+	case ComponentElasticsearch:
+		return s.Images.Elasticsearch
+	case ComponentKibana:
+		return s.Images.Kibana
+	case ComponentLogstash:
+		return s.Images.Logstash
+	case ComponentKafka:
+		return s.Images.Kafka
+	case ComponentZookeeper:
+		return s.Images.Zookeeper
+	case ComponentFilebeat:
+		return s.Images.Filebeat
+	}
+	return ""
+}
+
+type PodOverrides struct {
+	Elasticsearch *PodOverride `json:"elasticsearch,omitempty"`
+	Kibana        *PodOverride `json:"kibana,omitempty"`
+	Logstash      *PodOverride `json:"logstash,omitempty"`
+	Kafka         *PodOverride `json:"kafka,omitempty"`
+	// 单节点kafka的zookeeper与kafka在同一个pod中，只使用其中的resources和env
+	Zookeeper *PodOverride `json:"zookeeper,omitempty"`
+}
+
+// PodOverride 覆盖组件pod模板中的配置，未设置的字段保持operator生成的值
+type PodOverride struct {
+	// 主容器的资源，设置后整体替换默认值
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// 主容器的环境变量，按名称覆盖，例如通过ES_JAVA_OPTS调整堆内存
+	Env []corev1.EnvVar `json:"env,omitempty"`
+	// 与默认的nodeSelector合并
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// 追加到默认的tolerations之后
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// nodeAffinity、podAffinity、podAntiAffinity分别设置后替换默认值，集群模式默认使用podAntiAffinity将副本分散到不同节点
+	// 5个组件都展开affinity的schema会使CRD超过kubectl apply的大小限制，由apiserver在创建pod时校验
+	//+kubebuilder:validation:Schemaless
+	//+kubebuilder:validation:Type=object
+	//+kubebuilder:pruning:PreserveUnknownFields
+	Affinity          *corev1.Affinity `json:"affinity,omitempty"`
+	PriorityClassName string           `json:"priorityClassName,omitempty"`
+	// pod模板的标签，不能覆盖用作selector的标签
+	Labels map[string]string `json:"labels,omitempty"`
+	// pod模板的注解
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// PodOverride 返回组件的pod配置，未设置时返回nil
+func (s *LogFileSpec) PodOverride(component string) *PodOverride {
+	if s.PodOverrides == nil {
+		return nil
+	}
+	switch component {
+	case ComponentElasticsearch:
+		return s.PodOverrides.Elasticsearch
+	case ComponentKibana:
+		return s.PodOverrides.Kibana
+	case ComponentLogstash:
+		return s.PodOverrides.Logstash
+	case ComponentKafka:
+		return s.PodOverrides.Kafka
+	case ComponentZookeeper:
+		return s.PodOverrides.Zookeeper
+	}
+	return nil
+}
+
+// pvc的保留策略
+const (
+	RetainPolicyDelete = "Delete"
+	RetainPolicyRetain = "Retain"
+)
+
+// 支持的elastic stack版本
+const (
+	DefaultVersion = "8.5.0"
+	// 生成的elasticsearch、kibana、filebeat配置使用8.x的安全和数据流配置，不支持7.x
+	MinimumVersion = "8.0.0"
+	MaximumMajor   = 8
+)
+
+// 组件的部署模式
+const (
+	ModeNone    = "none"
+	ModeSingle  = "single"
+	ModeCluster = "cluster"
+)
+
+type ElasticsearchSpec struct {
+	//+kubebuilder:validation:Enum=single;cluster
+	Mode string `json:"mode,omitempty"`
+	// 集群模式https证书的来源
+	TLS *TLSSpec `json:"tls,omitempty"`
+	// 集群模式的节点组，每组使用单独的statefulset、存储和角色
+	// 不设置时为spec.replicas.elasticsearch个master,data,ingest节点
+	//+listType=map
+	//+listMapKey=name
+	NodeSets []NodeSet `json:"nodeSets,omitempty"`
+}
+
+// NodeSet elasticsearch集群中角色和存储相同的一组节点
+type NodeSet struct {
+	// 节点组名称，statefulset名称为 elasticsearch-master-<name>
+	//+kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	//+kubebuilder:validation:MaxLength=20
+	Name string `json:"name"`
+	// 节点数，数据节点缩容前会先将分片迁移到其他节点
+	//+kubebuilder:validation:Minimum=0
+	Replicas int32 `json:"replicas"`
+	// 节点角色，例如master、data、data_hot、data_warm、ingest，默认master,data,ingest
+	Roles []string `json:"roles,omitempty"`
+	// 每个节点的存储大小，默认使用resourceStorage.elasticsearch
+	Storage string `json:"storage,omitempty"`
+	// 默认使用storageClassName
+	StorageClassName string `json:"storageClassName,omitempty"`
+	// 该节点组pod的配置，在podOverrides.elasticsearch之后合并
+	PodOverride *PodOverride `json:"podOverride,omitempty"`
+}
+
+// 未设置节点组时每个节点的角色
+var DefaultNodeRoles = []string{"master", "data", "ingest"}
+
+// elasticsearch支持的节点角色
+var NodeRoles = []string{"master", "data", "data_content", "data_hot", "data_warm", "data_cold", "data_frozen", "ingest", "ml", "remote_cluster_client", "transform", "voting_only"}
+
+// NodeRoles 返回节点组的角色，未设置时为默认角色
+func (n *NodeSet) NodeRoles() []string {
+	if len(n.Roles) == 0 {
+		return DefaultNodeRoles
+	}
+	return n.Roles
 }
 
+// MasterEligible 判断节点组是否可以被选为master
+func (n *NodeSet) MasterEligible() bool {
+	for _, role := range n.NodeRoles() {
+		if role == "master" {
+			return true
+		}
+	}
+	return false
+}
+
+// ElasticsearchNodeSets 返回集群模式的节点组，未设置时返回兼容旧版本的一组master,data,ingest节点，名称为空
+func (s *LogFileSpec) ElasticsearchNodeSets() []NodeSet {
+	if s.Elasticsearch != nil && len(s.Elasticsearch.NodeSets) > 0 {
+		return s.Elasticsearch.NodeSets
+	}
+	var replicas int32 = 3
+	if s.Replicas != nil && s.Replicas.Elasticsearch > 0 {
+		replicas = s.Replicas.Elasticsearch
+	}
+	return []NodeSet{{Replicas: replicas}}
+}
+
+// TLSSpec elasticsearch集群模式使用的证书
+type TLSSpec struct {
+	// 设置后由cert-manager签发证书，不设置时由operator生成ca和节点证书，并在过期前自动轮换
+	CertManager *CertManagerSpec `json:"certManager,omitempty"`
+}
+
+type CertManagerSpec struct {
+	// 签发证书的Issuer或ClusterIssuer，签发的secret中需要包含ca.crt
+	IssuerRef IssuerReference `json:"issuerRef"`
+}
+
+type IssuerReference struct {
+	Name string `json:"name"`
+	//+kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	//+kubebuilder:default=Issuer
+	Kind  string `json:"kind,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+type KafkaSpec struct {
+	//+kubebuilder:validation:Enum=none;single;cluster
+	Mode string `json:"mode,omitempty"`
+}
+
+type LogstashSpec struct {
+	Enabled *bool `json:"enabled,omitempty"`
+}
+
+//+kubebuilder:object:generate=false
+
+// Programme 方案序号对应的组件组合
+type Programme struct {
+	ElasticsearchMode string
+	KafkaMode         string
+	Logstash          bool
+}
+
+// Programmes 原有的6种部署方案
+var Programmes = map[int]Programme{
+	1: {ElasticsearchMode: ModeSingle, KafkaMode: ModeNone, Logstash: false},
+	2: {ElasticsearchMode: ModeCluster, KafkaMode: ModeNone, Logstash: false},
+	3: {ElasticsearchMode: ModeSingle, KafkaMode: ModeNone, Logstash: true},
+	4: {ElasticsearchMode: ModeCluster, KafkaMode: ModeNone, Logstash: true},
+	5: {ElasticsearchMode: ModeSingle, KafkaMode: ModeSingle, Logstash: true},
+	6: {ElasticsearchMode: ModeCluster, KafkaMode: ModeCluster, Logstash: true},
+}
+
+// CertManager 返回cert-manager的配置，未使用cert-manager时返回nil
+func (s *LogFileSpec) CertManager() *CertManagerSpec {
+	if s.Elasticsearch == nil || s.Elasticsearch.TLS == nil {
+		return nil
+	}
+	return s.Elasticsearch.TLS.CertManager
+}
+
+// ElasticsearchMode 返回elasticsearch的部署模式，未设置时为单节点
+func (s *LogFileSpec) ElasticsearchMode() string {
+	if s.Elasticsearch == nil || s.Elasticsearch.Mode == "" {
+		return ModeSingle
+	}
+	return s.Elasticsearch.Mode
+}
+
+// KafkaMode 返回kafka的部署模式，未设置时不部署
+func (s *LogFileSpec) KafkaMode() string {
+	if s.Kafka == nil || s.Kafka.Mode == "" {
+		return ModeNone
+	}
+	return s.Kafka.Mode
+}
+
+// LogstashEnabled 返回是否部署logstash
+func (s *LogFileSpec) LogstashEnabled() bool {
+	return s.Logstash != nil && s.Logstash.Enabled != nil && *s.Logstash.Enabled
+}
+
+// LogFile的整体阶段
+const (
+	// 组件正在创建中，尚未全部就绪
+	PhaseProvisioning = "Provisioning"
+	// 方案中的所有组件均已就绪
+	PhaseReady = "Ready"
+	// 曾经就绪过，但当前有组件不可用
+	PhaseDegraded = "Degraded"
+	// LogFile已被删除，正在按顺序停止并删除组件
+	PhaseTerminating = "Terminating"
+)
+
+// 删除LogFile时的各个步骤，按顺序执行
+const (
+	// 删除filebeat-sidecar配置，新建的pod不再注入sidecar
+	TeardownStopShippers = "StopShippers"
+	// 等待logstash处理完kafka中和内存中的日志
+	TeardownDrainPipeline = "DrainPipeline"
+	// 删除logstash、kafka和zookeeper
+	TeardownDeletePipeline = "DeletePipeline"
+	// 删除elasticsearch、kibana和其余资源，并按保留策略处理pvc
+	TeardownDeleteElasticsearch = "DeleteElasticsearch"
+)
+
+// TeardownStatus 删除LogFile时的进度
+type TeardownStatus struct {
+	// 当前步骤
+	Step string `json:"step,omitempty"`
+	// 开始删除的时间
+	StartTime metav1.Time `json:"startTime,omitempty"`
+	// logstash已接收的事件数，一段时间内不再变化且全部输出后认为已排空
+	EventsIn int64 `json:"eventsIn,omitempty"`
+	// EventsIn最近一次变化的时间
+	EventsInChangeTime *metav1.Time `json:"eventsInChangeTime,omitempty"`
+}
+
+// 各组件的condition类型
+const (
+	ConditionFilebeatConfigReady = "FilebeatConfigReady"
+	ConditionKafkaReady          = "KafkaReady"
+	ConditionZookeeperReady      = "ZookeeperReady"
+	ConditionLogstashReady       = "LogstashReady"
+	ConditionElasticsearchReady  = "ElasticsearchReady"
+	ConditionKibanaReady         = "KibanaReady"
+	// 汇总condition，方案中的所有组件均就绪时为True
+	ConditionReady = "Ready"
+)
+
 // LogFileStatus defines the observed state of LogFile
 type LogFileStatus struct {
-	Status string `json:"status"`
+	// 整体阶段: Provisioning、Ready、Degraded、Terminating
+	Phase string `json:"phase,omitempty"`
+	// 最近一次调谐所处理的spec版本
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// 方案中各组件的状态，不属于当前方案的组件不会出现
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+	// 删除LogFile时的进度
+	Teardown *TeardownStatus `json:"teardown,omitempty"`
+	// elasticsearch所有节点当前运行的版本，kibana、logstash、filebeat使用该版本，保证先升级elasticsearch
+	Version string `json:"version,omitempty"`
+	// elasticsearch集群滚动重启的进度
+	Upgrade *UpgradeStatus `json:"upgrade,omitempty"`
+}
+
+// UpgradeStatus elasticsearch集群滚动重启的进度，每次只重启一个pod
+type UpgradeStatus struct {
+	// 正在重启的pod
+	Pod string `json:"pod,omitempty"`
+	// 开始重启该pod的时间
+	StartTime metav1.Time `json:"startTime,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="ES-Mode",type=string,JSONPath=`.spec.elasticsearch.mode`
+//+kubebuilder:printcolumn:name="Kafka-Mode",type=string,JSONPath=`.spec.kafka.mode`
+//+kubebuilder:printcolumn:name="Logstash",type=boolean,JSONPath=`.spec.logstash.enabled`
+//+kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.version`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Elasticsearch",type=string,JSONPath=`.status.conditions[?(@.type=="ElasticsearchReady")].status`
+//+kubebuilder:printcolumn:name="Kibana",type=string,JSONPath=`.status.conditions[?(@.type=="KibanaReady")].status`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // LogFile is the Schema for the logfiles API
 type LogFile struct {
@@ -67,6 +505,16 @@ type NodePortS struct {
 	Kibana        int `json:"kibana"`
 }
 
+// 单节点模式的elasticsearch和kafka固定为1个副本，集群模式至少3个副本
+type Replicas struct {
+	Elasticsearch int32 `json:"elasticsearch,omitempty"`
+	Kafka         int32 `json:"kafka,omitempty"`
+	// kafka集群模式下zookeeper的副本数，至少3个且为奇数，默认3个
+	Zookeeper int32 `json:"zookeeper,omitempty"`
+	Logstash  int32 `json:"logstash,omitempty"`
+	Kibana    int32 `json:"kibana,omitempty"`
+}
+
 //+kubebuilder:object:root=true
 
 // LogFileList contains a list of LogFile
@@ -17,12 +17,18 @@ limitations under the License.
 package v1
 
 import (
+	"fmt"
 	"reflect"
+	"strings"
 
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/version"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -46,6 +52,36 @@ var _ webhook.Defaulter = &LogFile{}
 // Default implements webhook.Defaulter so a webhook will be registered for the type
 func (r *LogFile) Default() {
 	logfilelog.Info("default", "name", r.Name)
+
+	// 将方案序号展开为各组件配置，已显式设置的配置不会被覆盖
+	programme, ok := Programmes[r.Spec.ProgrammeNum]
+	if !ok {
+		// 未使用方案序号时默认单节点elasticsearch，使用kafka时需要logstash消费
+		programme = Programme{
+			ElasticsearchMode: ModeSingle,
+			KafkaMode:         r.Spec.KafkaMode(),
+			Logstash:          r.Spec.KafkaMode() != ModeNone,
+		}
+	}
+	if r.Spec.Elasticsearch == nil {
+		r.Spec.Elasticsearch = &ElasticsearchSpec{}
+	}
+	if r.Spec.Elasticsearch.Mode == "" {
+		r.Spec.Elasticsearch.Mode = programme.ElasticsearchMode
+	}
+	if r.Spec.Kafka == nil {
+		r.Spec.Kafka = &KafkaSpec{}
+	}
+	if r.Spec.Kafka.Mode == "" {
+		r.Spec.Kafka.Mode = programme.KafkaMode
+	}
+	if r.Spec.Logstash == nil {
+		r.Spec.Logstash = &LogstashSpec{}
+	}
+	if r.Spec.Logstash.Enabled == nil {
+		enabled := programme.Logstash
+		r.Spec.Logstash.Enabled = &enabled
+	}
 	Elasticsearchstorage := "100Gi"
 	Kafkastorage := "10Gi"
 	Zookeeperstorage := "10Gi"
@@ -103,6 +139,55 @@ func (r *LogFile) Default() {
 		}
 	}
 
+	// 单节点模式默认1个副本，集群模式默认3个副本
+	ElasticsearchReplicas := int32(1)
+	KafkaReplicas := int32(1)
+	if r.Spec.ElasticsearchMode() == ModeCluster {
+		ElasticsearchReplicas = 3
+	}
+	if r.Spec.KafkaMode() == ModeCluster {
+		KafkaReplicas = 3
+	}
+	if r.Spec.Replicas == nil {
+		r.Spec.Replicas = &Replicas{}
+	}
+	// 对未传递的副本数进行赋值
+	replicasv := reflect.ValueOf(*r.Spec.Replicas)
+	replicast := reflect.TypeOf(*r.Spec.Replicas)
+	for i := 0; i < replicasv.NumField(); i++ {
+		if replicasv.Field(i).Int() == 0 {
+			switch replicast.Field(i).Name {
+			case "Elasticsearch":
+				r.Spec.Replicas.Elasticsearch = ElasticsearchReplicas
+			case "Kafka":
+				r.Spec.Replicas.Kafka = KafkaReplicas
+			case "Zookeeper":
+				r.Spec.Replicas.Zookeeper = KafkaReplicas
+			case "Logstash":
+				r.Spec.Replicas.Logstash = 1
+			case "Kibana":
+				r.Spec.Replicas.Kibana = 1
+			}
+		}
+	}
+
+	if r.Spec.Version == "" {
+		r.Spec.Version = DefaultVersion
+	}
+	if r.Spec.Retention != nil {
+		if r.Spec.Retention.RolloverSize == "" {
+			r.Spec.Retention.RolloverSize = DefaultRolloverSize
+		}
+		if r.Spec.Retention.RolloverAge == "" {
+			r.Spec.Retention.RolloverAge = DefaultRolloverAge
+		}
+	}
+
+	// 默认删除LogFile时一并删除数据
+	if r.Spec.RetainPolicy == "" {
+		r.Spec.RetainPolicy = RetainPolicyDelete
+	}
+
 	// TODO(user): fill in your defaulting logic.
 }
 
@@ -121,14 +206,78 @@ func (r *LogFile) ValidateCreate() error {
 }
 
 // ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+// 允许修改密码、扩容存储、修改nodePort和副本数，拒绝会导致数据丢失的变更
 func (r *LogFile) ValidateUpdate(old runtime.Object) error {
 	logfilelog.Info("validate update", "name", r.Name)
 
+	// 删除过程中只会修改finalizers，不再校验spec，避免校验规则变化后阻止删除
+	if r.DeletionTimestamp != nil {
+		return nil
+	}
+	if err := r.validate(); err != nil {
+		return err
+	}
+	oldlogfile, ok := old.(*LogFile)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a LogFile but got a %T", old))
+	}
+
 	var allErrs field.ErrorList
-	err := field.Invalid(field.NewPath("LogFile").Child("Spec"),
-		r.Name,
-		"不允许进行更新操作，请执行delete后在执行create")
-	allErrs = append(allErrs, err)
+	specpath := field.NewPath("Spec")
+	// 部署模式决定了组件的部署拓扑，变更后原有的elasticsearch、kafka数据无法迁移
+	if r.Spec.ElasticsearchMode() != oldlogfile.Spec.ElasticsearchMode() {
+		allErrs = append(allErrs, field.Forbidden(specpath.Child("Elasticsearch").Child("Mode"),
+			"不允许变更elasticsearch的部署模式，请执行delete后在执行create"))
+	}
+	if r.Spec.KafkaMode() != oldlogfile.Spec.KafkaMode() {
+		allErrs = append(allErrs, field.Forbidden(specpath.Child("Kafka").Child("Mode"),
+			"不允许变更kafka的部署模式，请执行delete后在执行create"))
+	}
+	if r.Spec.LogstashEnabled() != oldlogfile.Spec.LogstashEnabled() {
+		allErrs = append(allErrs, field.Forbidden(specpath.Child("Logstash").Child("Enabled"),
+			"不允许启用或停用logstash，请执行delete后在执行create"))
+	}
+	// 已创建的pvc无法更换storageclass
+	if r.Spec.TargetNamespace != oldlogfile.Spec.TargetNamespace {
+		allErrs = append(allErrs, field.Forbidden(specpath.Child("TargetNamespace"), "不允许修改组件部署的namespace"))
+	}
+	if r.Spec.StorageClassName != oldlogfile.Spec.StorageClassName {
+		allErrs = append(allErrs, field.Forbidden(specpath.Child("StorageClassName"),
+			"不允许变更storageclass"))
+	}
+	// pvc只能扩容不能缩容
+	if r.Spec.ResourceStorage != nil && oldlogfile.Spec.ResourceStorage != nil {
+		storagepath := specpath.Child("ResourceStorage")
+		allErrs = append(allErrs, validateStorageExpansion(storagepath.Child("Elasticsearch"), oldlogfile.Spec.ResourceStorage.Elasticsearch, r.Spec.ResourceStorage.Elasticsearch)...)
+		allErrs = append(allErrs, validateStorageExpansion(storagepath.Child("Kafka"), oldlogfile.Spec.ResourceStorage.Kafka, r.Spec.ResourceStorage.Kafka)...)
+		allErrs = append(allErrs, validateStorageExpansion(storagepath.Child("Zookeeper"), oldlogfile.Spec.ResourceStorage.Zookeeper, r.Spec.ResourceStorage.Zookeeper)...)
+	}
+	// elasticsearch不支持降级，跨大版本只能升级到下一个大版本
+	if r.Spec.Version != oldlogfile.Spec.Version && oldlogfile.Spec.Version != "" {
+		newversion, newerr := version.ParseGeneric(r.Spec.Version)
+		oldversion, olderr := version.ParseGeneric(oldlogfile.Spec.Version)
+		// 版本范围由validateVersion限制在同一个大版本内，这里只禁止降级
+		if newerr == nil && olderr == nil && newversion.LessThan(oldversion) {
+			allErrs = append(allErrs, field.Forbidden(specpath.Child("Version"), fmt.Sprintf("不允许从%s降级到%s", oldlogfile.Spec.Version, r.Spec.Version)))
+		}
+	}
+	allErrs = append(allErrs, validateNodeSetsUpdate(specpath.Child("Elasticsearch").Child("NodeSets"), r.Spec.StorageClassName, oldlogfile.Spec.ElasticsearchNodeSets(), r.Spec.ElasticsearchNodeSets())...)
+	// 直接缩容elasticsearch、kafka会丢失所在节点上的分片和分区
+	if r.Spec.Replicas != nil && oldlogfile.Spec.Replicas != nil {
+		replicaspath := specpath.Child("Replicas")
+		if r.Spec.Replicas.Elasticsearch < oldlogfile.Spec.Replicas.Elasticsearch {
+			allErrs = append(allErrs, field.Forbidden(replicaspath.Child("Elasticsearch"), "不允许缩容elasticsearch"))
+		}
+		if r.Spec.Replicas.Kafka < oldlogfile.Spec.Replicas.Kafka {
+			allErrs = append(allErrs, field.Forbidden(replicaspath.Child("Kafka"), "不允许缩容kafka"))
+		}
+		if r.Spec.Replicas.Zookeeper < oldlogfile.Spec.Replicas.Zookeeper {
+			allErrs = append(allErrs, field.Forbidden(replicaspath.Child("Zookeeper"), "不允许缩容zookeeper"))
+		}
+	}
+	if len(allErrs) == 0 {
+		return nil
+	}
 	return apierrors.NewInvalid(
 		schema.GroupKind{Group: "apiv1.huisebug.org", Kind: "LogFile"},
 		r.Name,
@@ -136,6 +285,177 @@ func (r *LogFile) ValidateUpdate(old runtime.Object) error {
 
 }
 
+// validateStorageExpansion 校验申请空间只增不减
+func validateStorageExpansion(path *field.Path, oldstorage, newstorage string) field.ErrorList {
+	var allErrs field.ErrorList
+	oldquantity, err := resource.ParseQuantity(oldstorage)
+	if err != nil {
+		return allErrs
+	}
+	newquantity, err := resource.ParseQuantity(newstorage)
+	if err != nil {
+		return append(allErrs, field.Invalid(path, newstorage, err.Error()))
+	}
+	if newquantity.Cmp(oldquantity) < 0 {
+		allErrs = append(allErrs, field.Forbidden(path, fmt.Sprintf("申请空间只允许扩容, 当前为%s", oldstorage)))
+	}
+	return allErrs
+}
+
+// validateNodeSets 校验elasticsearch集群的节点组
+func validateNodeSets(fldPath *field.Path, nodesets []NodeSet) field.ErrorList {
+	var allErrs field.ErrorList
+	names := map[string]bool{}
+	var masters, datanodes int32
+	for i, nodeset := range nodesets {
+		path := fldPath.Index(i)
+		if names[nodeset.Name] {
+			allErrs = append(allErrs, field.Duplicate(path.Child("Name"), nodeset.Name))
+		}
+		names[nodeset.Name] = true
+		for _, msg := range validation.IsDNS1123Label(nodeset.Name) {
+			allErrs = append(allErrs, field.Invalid(path.Child("Name"), nodeset.Name, msg))
+		}
+		roles := map[string]bool{}
+		for j, role := range nodeset.Roles {
+			supported := false
+			for _, r := range NodeRoles {
+				if role == r {
+					supported = true
+				}
+			}
+			if !supported {
+				allErrs = append(allErrs, field.NotSupported(path.Child("Roles").Index(j), role, NodeRoles))
+			}
+			if roles[role] {
+				allErrs = append(allErrs, field.Duplicate(path.Child("Roles").Index(j), role))
+			}
+			roles[role] = true
+		}
+		if nodeset.Storage != "" {
+			if _, err := resource.ParseQuantity(nodeset.Storage); err != nil {
+				allErrs = append(allErrs, field.Invalid(path.Child("Storage"), nodeset.Storage, err.Error()))
+			}
+		}
+		allErrs = append(allErrs, validatePodOverride(path.Child("PodOverride"), nodeset.PodOverride)...)
+		if nodeset.MasterEligible() {
+			masters += nodeset.Replicas
+		}
+		for _, role := range nodeset.NodeRoles() {
+			if role == "data" || strings.HasPrefix(role, "data_") {
+				datanodes += nodeset.Replicas
+				break
+			}
+		}
+	}
+	if masters < 3 {
+		allErrs = append(allErrs, field.Invalid(fldPath, masters, "elasticsearch集群至少需要3个master节点"))
+	}
+	if datanodes < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath, datanodes, "elasticsearch集群至少需要1个数据节点"))
+	}
+	return allErrs
+}
+
+// validateNodeSetsUpdate 校验节点组的变更，master节点不能缩容，节点的角色不能修改
+// 数据节点组需要先缩容为0，等待分片迁移完成后再删除
+func validateNodeSetsUpdate(fldPath *field.Path, storageclassname string, oldnodesets, newnodesets []NodeSet) field.ErrorList {
+	var allErrs field.ErrorList
+	if (oldnodesets[0].Name == "") != (newnodesets[0].Name == "") {
+		return append(allErrs, field.Forbidden(fldPath, "不能在默认节点和节点组之间切换"))
+	}
+	for _, oldnodeset := range oldnodesets {
+		// 默认节点的副本数由spec.replicas.elasticsearch校验
+		if oldnodeset.Name == "" {
+			continue
+		}
+		var newnodeset *NodeSet
+		for i := range newnodesets {
+			if newnodesets[i].Name == oldnodeset.Name {
+				newnodeset = &newnodesets[i]
+			}
+		}
+		path := fldPath.Key(oldnodeset.Name)
+		if newnodeset == nil {
+			if oldnodeset.MasterEligible() {
+				allErrs = append(allErrs, field.Forbidden(path, "不允许删除master节点组"))
+			} else if oldnodeset.Replicas > 0 {
+				allErrs = append(allErrs, field.Forbidden(path, "需要先将replicas缩容为0，等待分片迁移完成后再删除节点组"))
+			}
+			continue
+		}
+		if strings.Join(oldnodeset.NodeRoles(), ",") != strings.Join(newnodeset.NodeRoles(), ",") {
+			allErrs = append(allErrs, field.Forbidden(path.Child("Roles"), "节点组的角色不能修改"))
+		}
+		if oldnodeset.MasterEligible() && newnodeset.Replicas < oldnodeset.Replicas {
+			allErrs = append(allErrs, field.Forbidden(path.Child("Replicas"), "不允许缩容master节点"))
+		}
+		if oldnodeset.Storage != "" && newnodeset.Storage != "" {
+			allErrs = append(allErrs, validateStorageExpansion(path.Child("Storage"), oldnodeset.Storage, newnodeset.Storage)...)
+		}
+		// statefulset的volumeClaimTemplates创建后不可变更，修改storageclass不会生效
+		if nodesetStorageClassName(oldnodeset, storageclassname) != nodesetStorageClassName(*newnodeset, storageclassname) {
+			allErrs = append(allErrs, field.Forbidden(path.Child("StorageClassName"), "不允许修改节点组的storageclass，请新建节点组迁移数据后删除原节点组"))
+		}
+	}
+	return allErrs
+}
+
+// nodesetStorageClassName 节点组使用的storageclass，未设置时使用spec.storageClassName
+func nodesetStorageClassName(nodeset NodeSet, storageclassname string) string {
+	if nodeset.StorageClassName != "" {
+		return nodeset.StorageClassName
+	}
+	return storageclassname
+}
+
+// validateRetention 校验保留策略，各阶段的天数需要递增，warm、cold阶段需要有对应的数据节点
+func validateRetention(fldPath *field.Path, retention *RetentionSpec, nodesets []NodeSet) field.ErrorList {
+	var allErrs field.ErrorList
+	phases := []struct {
+		name string
+		days int32
+		tier string
+	}{
+		{"WarmAfterDays", retention.WarmAfterDays, "data_warm"},
+		{"ColdAfterDays", retention.ColdAfterDays, "data_cold"},
+		{"DeleteAfterDays", retention.DeleteAfterDays, ""},
+	}
+	var previous int32
+	for _, phase := range phases {
+		if phase.days == 0 {
+			continue
+		}
+		if phase.days <= previous {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child(phase.name), phase.days, fmt.Sprintf("需要大于前一个阶段的%d天", previous)))
+		}
+		previous = phase.days
+		if phase.tier == "" || hasDataTier(nodesets, phase.tier) {
+			continue
+		}
+		allErrs = append(allErrs, field.Invalid(fldPath.Child(phase.name), phase.days, "没有"+phase.tier+"或data角色的节点组，索引无法移动到该阶段"))
+	}
+	if retention.DeleteAfterDays < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("DeleteAfterDays"), retention.DeleteAfterDays, "至少保留1天"))
+	}
+	return allErrs
+}
+
+// hasDataTier 判断是否有存储该数据层的节点，data角色的节点可以存储所有层的数据
+func hasDataTier(nodesets []NodeSet, tier string) bool {
+	for _, nodeset := range nodesets {
+		if nodeset.Replicas == 0 {
+			continue
+		}
+		for _, role := range nodeset.NodeRoles() {
+			if role == tier || role == "data" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // ValidateDelete implements webhook.Validator so a webhook will be registered for the type
 func (r *LogFile) ValidateDelete() error {
 	logfilelog.Info("validate delete", "name", r.Name)
@@ -146,7 +466,7 @@ func (r *LogFile) ValidateDelete() error {
 
 func (r *LogFile) validate() error {
 	var allErrs field.ErrorList
-	if r.Spec.ProgrammeNum > 6 || r.Spec.ProgrammeNum <= 0 {
+	if r.Spec.ProgrammeNum > 6 || r.Spec.ProgrammeNum < 0 {
 		err := field.Invalid(field.NewPath("Spec").Child("ProgrammeNum"),
 			r.Spec.ProgrammeNum,
 			"当前仅支持6种部署方案")
@@ -157,5 +477,205 @@ func (r *LogFile) validate() error {
 			allErrs)
 	}
 
+	// 同时设置方案序号和组件配置时，两者必须一致
+	if programme, ok := Programmes[r.Spec.ProgrammeNum]; ok {
+		if programme.ElasticsearchMode != r.Spec.ElasticsearchMode() || programme.KafkaMode != r.Spec.KafkaMode() || programme.Logstash != r.Spec.LogstashEnabled() {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("Spec").Child("ProgrammeNum"), r.Spec.ProgrammeNum,
+				fmt.Sprintf("方案序号对应elasticsearch.mode=%s, kafka.mode=%s, logstash.enabled=%t, 与组件配置不一致", programme.ElasticsearchMode, programme.KafkaMode, programme.Logstash)))
+		}
+	}
+	switch r.Spec.ElasticsearchMode() {
+	case ModeSingle, ModeCluster:
+	default:
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("Spec").Child("Elasticsearch").Child("Mode"), r.Spec.ElasticsearchMode(), []string{ModeSingle, ModeCluster}))
+	}
+	switch r.Spec.KafkaMode() {
+	case ModeNone, ModeSingle, ModeCluster:
+	default:
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("Spec").Child("Kafka").Child("Mode"), r.Spec.KafkaMode(), []string{ModeNone, ModeSingle, ModeCluster}))
+	}
+	if r.Spec.Version != "" {
+		allErrs = append(allErrs, validateVersion(field.NewPath("Spec").Child("Version"), r.Spec.Version)...)
+	}
+	if r.Spec.TargetNamespace != "" {
+		for _, msg := range validation.IsDNS1123Label(r.Spec.TargetNamespace) {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("Spec").Child("TargetNamespace"), r.Spec.TargetNamespace, msg))
+		}
+	}
+	if r.Spec.AllowedNamespaces != nil {
+		if _, err := metav1.LabelSelectorAsSelector(r.Spec.AllowedNamespaces); err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("Spec").Child("AllowedNamespaces"), r.Spec.AllowedNamespaces, err.Error()))
+		}
+	}
+	if certmanager := r.Spec.CertManager(); certmanager != nil {
+		tlspath := field.NewPath("Spec").Child("Elasticsearch").Child("TLS")
+		if r.Spec.ElasticsearchMode() != ModeCluster {
+			allErrs = append(allErrs, field.Forbidden(tlspath, "只有集群模式的elasticsearch使用https证书"))
+		}
+		if certmanager.IssuerRef.Name == "" {
+			allErrs = append(allErrs, field.Required(tlspath.Child("CertManager").Child("IssuerRef").Child("Name"), "需要指定签发证书的Issuer"))
+		}
+	}
+	// 同一个密码不能同时使用明文和secret
+	if r.Spec.ElasticPasswordSecretRef != nil && r.Spec.ELASTIC_PASSWORD != "" {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("Spec").Child("ELASTIC_PASSWORD"), "已设置ElasticPasswordSecretRef时不能再设置明文密码"))
+	}
+	if r.Spec.KibanaPasswordSecretRef != nil && r.Spec.KIBANA_PASSWORD != "" {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("Spec").Child("KIBANA_PASSWORD"), "已设置KibanaPasswordSecretRef时不能再设置明文密码"))
+	}
+	if r.Spec.Elasticsearch != nil && len(r.Spec.Elasticsearch.NodeSets) > 0 {
+		nodesetspath := field.NewPath("Spec").Child("Elasticsearch").Child("NodeSets")
+		if r.Spec.ElasticsearchMode() != ModeCluster {
+			allErrs = append(allErrs, field.Forbidden(nodesetspath, "只有集群模式的elasticsearch可以设置节点组"))
+		}
+		allErrs = append(allErrs, validateNodeSets(nodesetspath, r.Spec.Elasticsearch.NodeSets)...)
+	}
+	if r.Spec.PodOverrides != nil {
+		overridespath := field.NewPath("Spec").Child("PodOverrides")
+		for _, component := range []string{ComponentElasticsearch, ComponentKibana, ComponentLogstash, ComponentKafka, ComponentZookeeper} {
+			allErrs = append(allErrs, validatePodOverride(overridespath.Child(component), r.Spec.PodOverride(component))...)
+		}
+	}
+	if r.Spec.Retention != nil {
+		allErrs = append(allErrs, validateRetention(field.NewPath("Spec").Child("Retention"), r.Spec.Retention, r.Spec.ElasticsearchNodeSets())...)
+	}
+	// kafka中的日志需要由logstash消费后写入elasticsearch
+	if r.Spec.KafkaMode() != ModeNone && !r.Spec.LogstashEnabled() {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("Spec").Child("Logstash").Child("Enabled"), r.Spec.LogstashEnabled(), "使用kafka时必须启用logstash"))
+	}
+
+	if r.Spec.ResourceStorage != nil {
+		storagepath := field.NewPath("Spec").Child("ResourceStorage")
+		storages := []struct {
+			name    string
+			storage string
+		}{
+			{"Elasticsearch", r.Spec.ResourceStorage.Elasticsearch},
+			{"Kafka", r.Spec.ResourceStorage.Kafka},
+			{"Zookeeper", r.Spec.ResourceStorage.Zookeeper},
+		}
+		for _, s := range storages {
+			if _, err := resource.ParseQuantity(s.storage); err != nil {
+				allErrs = append(allErrs, field.Invalid(storagepath.Child(s.name), s.storage, err.Error()))
+			}
+		}
+	}
+
+	if r.Spec.NodePortS != nil {
+		nodeportspath := field.NewPath("Spec").Child("NodePortS")
+		if r.Spec.NodePortS.Elasticsearch < 30000 || r.Spec.NodePortS.Elasticsearch > 32767 {
+			allErrs = append(allErrs, field.Invalid(nodeportspath.Child("Elasticsearch"), r.Spec.NodePortS.Elasticsearch, "nodePort范围为30000-32767"))
+		}
+		if r.Spec.NodePortS.Kibana < 30000 || r.Spec.NodePortS.Kibana > 32767 {
+			allErrs = append(allErrs, field.Invalid(nodeportspath.Child("Kibana"), r.Spec.NodePortS.Kibana, "nodePort范围为30000-32767"))
+		}
+		if r.Spec.NodePortS.Elasticsearch == r.Spec.NodePortS.Kibana {
+			allErrs = append(allErrs, field.Duplicate(nodeportspath.Child("Kibana"), r.Spec.NodePortS.Kibana))
+		}
+	}
+
+	if r.Spec.Replicas != nil {
+		replicaspath := field.NewPath("Spec").Child("Replicas")
+		switch r.Spec.ElasticsearchMode() {
+		case ModeSingle:
+			if r.Spec.Replicas.Elasticsearch != 1 {
+				allErrs = append(allErrs, field.Invalid(replicaspath.Child("Elasticsearch"), r.Spec.Replicas.Elasticsearch, "单节点elasticsearch只能为1个副本"))
+			}
+		case ModeCluster:
+			if r.Spec.Replicas.Elasticsearch < 3 {
+				allErrs = append(allErrs, field.Invalid(replicaspath.Child("Elasticsearch"), r.Spec.Replicas.Elasticsearch, "elasticsearch集群至少为3个副本"))
+			}
+		}
+		switch r.Spec.KafkaMode() {
+		case ModeSingle:
+			if r.Spec.Replicas.Kafka != 1 {
+				allErrs = append(allErrs, field.Invalid(replicaspath.Child("Kafka"), r.Spec.Replicas.Kafka, "单节点kafka只能为1个副本"))
+			}
+		case ModeCluster:
+			if r.Spec.Replicas.Kafka < 3 {
+				allErrs = append(allErrs, field.Invalid(replicaspath.Child("Kafka"), r.Spec.Replicas.Kafka, "kafka集群至少为3个副本"))
+			}
+			if r.Spec.Replicas.Zookeeper < 3 || r.Spec.Replicas.Zookeeper%2 == 0 {
+				allErrs = append(allErrs, field.Invalid(replicaspath.Child("Zookeeper"), r.Spec.Replicas.Zookeeper, "zookeeper集群至少为3个副本且为奇数"))
+			}
+		}
+		if r.Spec.Replicas.Logstash < 1 {
+			allErrs = append(allErrs, field.Invalid(replicaspath.Child("Logstash"), r.Spec.Replicas.Logstash, "至少为1个副本"))
+		}
+		if r.Spec.Replicas.Kibana < 1 {
+			allErrs = append(allErrs, field.Invalid(replicaspath.Child("Kibana"), r.Spec.Replicas.Kibana, "至少为1个副本"))
+		}
+	}
+
+	if len(allErrs) > 0 {
+		return apierrors.NewInvalid(
+			schema.GroupKind{Group: "apiv1.huisebug.org", Kind: "LogFile"},
+			r.Name,
+			allErrs)
+	}
 	return nil
 }
+
+// validateVersion 校验elastic stack版本是否在支持的范围内
+func validateVersion(fldPath *field.Path, value string) field.ErrorList {
+	var allErrs field.ErrorList
+	v, err := version.ParseGeneric(value)
+	if err != nil {
+		return append(allErrs, field.Invalid(fldPath, value, err.Error()))
+	}
+	if v.LessThan(version.MustParseGeneric(MinimumVersion)) || v.Major() > MaximumMajor {
+		allErrs = append(allErrs, field.Invalid(fldPath, value, fmt.Sprintf("支持的版本为%s及以上的%d.x", MinimumVersion, MaximumMajor)))
+	}
+	return allErrs
+}
+
+// PodSelectorLabels 组件pod模板中用作selector的标签，不能被podOverrides覆盖
+var PodSelectorLabels = []string{"app", "logfile-operator"}
+
+// validatePodOverride 校验组件pod的覆盖配置
+func validatePodOverride(fldPath *field.Path, override *PodOverride) field.ErrorList {
+	var allErrs field.ErrorList
+	if override == nil {
+		return allErrs
+	}
+	if override.PriorityClassName != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(override.PriorityClassName) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("PriorityClassName"), override.PriorityClassName, msg))
+		}
+	}
+	for k, v := range override.Labels {
+		for _, selectorlabel := range PodSelectorLabels {
+			if k == selectorlabel {
+				allErrs = append(allErrs, field.Forbidden(fldPath.Child("Labels").Key(k), "不能覆盖用作selector的标签"))
+			}
+		}
+		for _, msg := range validation.IsQualifiedName(k) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("Labels").Key(k), k, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(v) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("Labels").Key(k), v, msg))
+		}
+	}
+	for k, v := range override.NodeSelector {
+		for _, msg := range validation.IsQualifiedName(k) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("NodeSelector").Key(k), k, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(v) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("NodeSelector").Key(k), v, msg))
+		}
+	}
+	for i, env := range override.Env {
+		if env.Name == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("Env").Index(i).Child("Name"), ""))
+		}
+	}
+	// 资源的requests不能大于limits
+	if override.Resources != nil {
+		for name, request := range override.Resources.Requests {
+			if limit, ok := override.Resources.Limits[name]; ok && request.Cmp(limit) > 0 {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("Resources").Child("Requests").Key(string(name)), request.String(), "不能大于limits"))
+			}
+		}
+	}
+	return allErrs
+}
@@ -0,0 +1,97 @@
+package v1
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testLogFile(mutate func(*LogFile)) *LogFile {
+	enabled := true
+	logfile := &LogFile{
+		ObjectMeta: metav1.ObjectMeta{Name: "logfile", Namespace: "logging"},
+		Spec: LogFileSpec{
+			Version:          "8.5.0",
+			Elasticsearch:    &ElasticsearchSpec{Mode: ModeCluster},
+			Kafka:            &KafkaSpec{Mode: ModeSingle},
+			Logstash:         &LogstashSpec{Enabled: &enabled},
+			StorageClassName: "nfs-storageclass",
+		},
+	}
+	logfile.Default()
+	if mutate != nil {
+		mutate(logfile)
+	}
+	return logfile
+}
+
+func TestLogFileValidateUpdate(t *testing.T) {
+	old := testLogFile(nil)
+	if err := old.ValidateCreate(); err != nil {
+		t.Fatalf("base LogFile is invalid: %v", err)
+	}
+
+	// 可以原地更新的字段
+	allowed := map[string]func(*LogFile){
+		"unchanged":               func(*LogFile) {},
+		"storage expanded":        func(l *LogFile) { l.Spec.ResourceStorage.Elasticsearch = "100Gi" },
+		"kibana nodePort changed": func(l *LogFile) { l.Spec.NodePortS.Kibana = 30999 },
+		"minor upgrade":           func(l *LogFile) { l.Spec.Version = "8.6.2" },
+	}
+	for name, mutate := range allowed {
+		if err := testLogFile(mutate).ValidateUpdate(old); err != nil {
+			t.Errorf("%s: ValidateUpdate() = %v, want nil", name, err)
+		}
+	}
+
+	// 不能修改的字段，按错误信息中的字段路径检查
+	rejected := map[string]func(*LogFile){
+		"Spec.ResourceStorage.Elasticsearch": func(l *LogFile) { l.Spec.ResourceStorage.Elasticsearch = "1Mi" },
+		"Spec.Elasticsearch.Mode":            func(l *LogFile) { l.Spec.Elasticsearch.Mode = ModeSingle; l.Spec.Replicas.Elasticsearch = 1 },
+		"Spec.Kafka.Mode":                    func(l *LogFile) { l.Spec.Kafka.Mode = ModeNone },
+		"Spec.StorageClassName":              func(l *LogFile) { l.Spec.StorageClassName = "ssd" },
+		"Spec.TargetNamespace":               func(l *LogFile) { l.Spec.TargetNamespace = "logging-staging" },
+		"Spec.Version":                       func(l *LogFile) { l.Spec.Version = "8.4.0" },
+	}
+	for fieldpath, mutate := range rejected {
+		err := testLogFile(mutate).ValidateUpdate(old)
+		if err == nil || !strings.Contains(err.Error(), fieldpath) {
+			t.Errorf("ValidateUpdate() = %v, want error on %s", err, fieldpath)
+		}
+	}
+}
+
+func TestLogFileValidateUpdateDeleting(t *testing.T) {
+	now := metav1.Now()
+	logfile := testLogFile(func(l *LogFile) {
+		l.DeletionTimestamp = &now
+		l.Spec.StorageClassName = "ssd"
+	})
+	if err := logfile.ValidateUpdate(testLogFile(nil)); err != nil {
+		t.Errorf("ValidateUpdate() = %v, want nil while deleting", err)
+	}
+}
+
+func TestLogFileValidateUpdateNodeSetStorageClass(t *testing.T) {
+	nodesets := func(storageclassname string) func(*LogFile) {
+		return func(l *LogFile) {
+			l.Spec.Elasticsearch.NodeSets = []NodeSet{
+				{Name: "master", Replicas: 3, Roles: []string{"master"}},
+				{Name: "hot", Replicas: 2, Roles: []string{"data"}, StorageClassName: storageclassname},
+			}
+		}
+	}
+	old := testLogFile(nodesets(""))
+	if err := old.ValidateCreate(); err != nil {
+		t.Fatalf("base LogFile is invalid: %v", err)
+	}
+	// 显式设置为spec.storageClassName时实际使用的storageclass不变
+	if err := testLogFile(nodesets("nfs-storageclass")).ValidateUpdate(old); err != nil {
+		t.Errorf("ValidateUpdate() = %v, want nil for the same effective storageclass", err)
+	}
+	err := testLogFile(nodesets("ssd")).ValidateUpdate(old)
+	if err == nil || !strings.Contains(err.Error(), "Spec.Elasticsearch.NodeSets[hot].StorageClassName") {
+		t.Errorf("ValidateUpdate() = %v, want error on the hot nodeset storageclass", err)
+	}
+}
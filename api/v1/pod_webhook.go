@@ -17,4 +17,4 @@ limitations under the License.
 package v1
 
 // 下面的注释用于生成 MutatingWebhookConfiguration 下webhook配置
-//+kubebuilder:webhook:path=/mutate-huisebug-core-v1-pod,mutating=true,failurePolicy=fail,sideEffects=None,groups=core,resources=pods,verbs=create;update,versions=v1,name=mhuisebugpod.kb.io,admissionReviewVersions=v1
+//+kubebuilder:webhook:path=/mutate-huisebug-core-v1-pod,mutating=true,failurePolicy=fail,sideEffects=NoneOnDryRun,groups=core,resources=pods,verbs=create,versions=v1,name=mhuisebugpod.kb.io,admissionReviewVersions=v1
@@ -0,0 +1,49 @@
+package v1
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDefaultExpandsProgrammeNum(t *testing.T) {
+	for num, programme := range Programmes {
+		logfile := &LogFile{Spec: LogFileSpec{ProgrammeNum: num}}
+		logfile.Default()
+		if logfile.Spec.ElasticsearchMode() != programme.ElasticsearchMode || logfile.Spec.KafkaMode() != programme.KafkaMode || logfile.Spec.LogstashEnabled() != programme.Logstash {
+			t.Errorf("programme %d expanded to elasticsearch=%s kafka=%s logstash=%t, want %+v", num,
+				logfile.Spec.ElasticsearchMode(), logfile.Spec.KafkaMode(), logfile.Spec.LogstashEnabled(), programme)
+		}
+		// 副本数按展开后的模式设置默认值
+		wantReplicas := int32(1)
+		if programme.ElasticsearchMode == ModeCluster {
+			wantReplicas = 3
+		}
+		if logfile.Spec.Replicas.Elasticsearch != wantReplicas {
+			t.Errorf("programme %d elasticsearch replicas = %d, want %d", num, logfile.Spec.Replicas.Elasticsearch, wantReplicas)
+		}
+		if err := logfile.validate(); err != nil {
+			t.Errorf("programme %d defaulted spec rejected: %v", num, err)
+		}
+	}
+}
+
+func TestDefaultKeepsExplicitComponents(t *testing.T) {
+	// 未使用方案序号时，设置了kafka则默认部署logstash消费
+	logfile := &LogFile{Spec: LogFileSpec{Kafka: &KafkaSpec{Mode: ModeSingle}}}
+	logfile.Default()
+	if logfile.Spec.ElasticsearchMode() != ModeSingle || !logfile.Spec.LogstashEnabled() {
+		t.Errorf("defaulted to elasticsearch=%s logstash=%t, want single and logstash enabled", logfile.Spec.ElasticsearchMode(), logfile.Spec.LogstashEnabled())
+	}
+
+	// 显式关闭logstash不会被方案序号覆盖，两者不一致时拒绝
+	disabled := false
+	logfile = &LogFile{Spec: LogFileSpec{ProgrammeNum: 5, Logstash: &LogstashSpec{Enabled: &disabled}}}
+	logfile.Default()
+	if logfile.Spec.LogstashEnabled() {
+		t.Fatal("explicit logstash.enabled=false overwritten by programme 5")
+	}
+	err := logfile.validate()
+	if err == nil || !strings.Contains(err.Error(), "Spec.ProgrammeNum") {
+		t.Errorf("validate() = %v, want a Spec.ProgrammeNum mismatch", err)
+	}
+}
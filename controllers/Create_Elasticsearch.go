@@ -6,14 +6,12 @@ import (
 
 	apiv1 "github.com/huisebug/logfile-operator/api/v1"
 	appsv1 "k8s.io/api/apps/v1"
-	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/pointer"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 func (r *LogFileReconciler) ElasticsearchCreteConfigMap(ctx context.Context, logfile *apiv1.LogFile, typesname types.NamespacedName, meta metav1.ObjectMeta, labels map[string]string) error {
@@ -35,16 +33,16 @@ xpack.security.enabled: true
 
 	// 级联删除
 	customizelog.Info("set configmap reference")
-	if err := controllerutil.SetControllerReference(logfile, configmap, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, configmap, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建
-	if err := r.Create(ctx, configmap); err != nil {
+	// 新建或更新
+	if _, err := r.CreteOrUpdate(ctx, logfile, configmap); err != nil {
 		return err
 	}
 
-	customizelog.Info("create configmap success", "name", typesname.String())
+	customizelog.Info("reconcile configmap success", "name", typesname.String())
 
 	return nil
 }
@@ -79,7 +77,7 @@ func (r *LogFileReconciler) ElasticsearchCreteStatefulSet(ctx context.Context, l
 					},
 				},
 			},
-			Replicas:    pointer.Int32Ptr(1),
+			Replicas:    pointer.Int32Ptr(logfile.Spec.Replicas.Elasticsearch),
 			Selector:    metav1.SetAsLabelSelector(labels),
 			ServiceName: meta.Name + "-headless",
 			Template: corev1.PodTemplateSpec{
@@ -87,10 +85,11 @@ func (r *LogFileReconciler) ElasticsearchCreteStatefulSet(ctx context.Context, l
 					Labels: labels,
 				},
 				Spec: corev1.PodSpec{
+					ImagePullSecrets: r.Images.ImagePullSecrets(logfile),
 					Containers: []corev1.Container{
 						{
 							Name:            "elasticsearch",
-							Image:           "registry.cn-hangzhou.aliyuncs.com/huisebug/logfile-operator:elasticsearch-8.5.0",
+							Image:           r.Images.Image(logfile, apiv1.ComponentElasticsearch),
 							ImagePullPolicy: corev1.PullIfNotPresent,
 							Env: []corev1.EnvVar{
 								{
@@ -106,12 +105,9 @@ func (r *LogFileReconciler) ElasticsearchCreteStatefulSet(ctx context.Context, l
 									Value: "-Xms512m -Xmx512m",
 								},
 								{
-									Name:  "ELASTIC_PASSWORD",
-									Value: logfile.Spec.ELASTIC_PASSWORD,
-								},
-								{
-									Name:  "KIBANA_PASSWORD",
-									Value: logfile.Spec.KIBANA_PASSWORD,
+									// 只在首次初始化数据目录时生效，之后由operator调用接口轮换
+									Name:      "ELASTIC_PASSWORD",
+									ValueFrom: SecretKeyRef(AppliedCredentialsSecretName(meta.Name), ElasticPasswordKey),
 								},
 							},
 							Ports: []corev1.ContainerPort{
@@ -179,76 +175,24 @@ func (r *LogFileReconciler) ElasticsearchCreteStatefulSet(ctx context.Context, l
 		},
 	}
 
+	// 合并LogFile中的pod配置
+	ApplyPodOverride(&statefulset.Spec.Template, logfile.Spec.PodOverride(apiv1.ComponentElasticsearch), "elasticsearch")
+
 	// 级联删除statefulset
 	customizelog.Info("set statefulset reference")
-	if err := controllerutil.SetControllerReference(logfile, statefulset, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, statefulset, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建statefulset
-	if err := r.Create(ctx, statefulset); err != nil {
+	// 新建或更新statefulset
+	if _, err := r.CreteOrUpdate(ctx, logfile, statefulset); err != nil {
 		return err
 	}
 
-	customizelog.Info("create statefulset success", "name", typesname.String())
+	customizelog.Info("reconcile statefulset success", "name", typesname.String())
 
 	return nil
 }
-func (r *LogFileReconciler) ElasticsearchKibanaUserCreteJob(ctx context.Context, logfile *apiv1.LogFile, typesname types.NamespacedName, meta metav1.ObjectMeta, labels map[string]string) error {
-
-	customizelog := logger.WithValues("func", "ElasticsearchKibanaUserCreteJob")
-	job := &batchv1.Job{
-		ObjectMeta: meta,
-		Spec: batchv1.JobSpec{
-			Template: corev1.PodTemplateSpec{
-				Spec: corev1.PodSpec{
-					RestartPolicy: corev1.RestartPolicyNever,
-					Containers: []corev1.Container{
-						{
-							Name:            "set-kibana-user-password",
-							Image:           "registry.cn-hangzhou.aliyuncs.com/huisebug/logfile-operator:elasticsearch-8.5.0",
-							ImagePullPolicy: "IfNotPresent",
-							Env: []corev1.EnvVar{
-								{
-									Name:  "ELASTIC_PASSWORD",
-									Value: logfile.Spec.ELASTIC_PASSWORD,
-								},
-								{
-									Name:  "KIBANA_PASSWORD",
-									Value: logfile.Spec.KIBANA_PASSWORD,
-								},
-							},
-							Command: []string{
-								"/bin/bash",
-								"-c",
-							},
-							Args: []string{
-								`until curl -s -X POST -u "elastic:${ELASTIC_PASSWORD}" -H "Content-Type: application/json" http://elasticsearch.logfile-operator-system:9200/_security/user/kibana_system/_password -d "{\"password\":\"${KIBANA_PASSWORD}\"}" | grep -q "^{}"; do sleep 10; done;`,
-							},
-						},
-					},
-				},
-			},
-		},
-	}
-
-	// 级联删除job
-	customizelog.Info("set job reference")
-	if err := controllerutil.SetControllerReference(logfile, job, r.Scheme); err != nil {
-		customizelog.Error(err, "SetControllerReference error")
-		return err
-	}
-
-	// 新建job
-	if err := r.Create(ctx, job); err != nil {
-		return err
-	}
-
-	customizelog.Info("create job success", "name", typesname.String())
-
-	return nil
-}
-
 func (r *LogFileReconciler) ElasticsearchCreteService(ctx context.Context, logfile *apiv1.LogFile, typesname types.NamespacedName, meta metav1.ObjectMeta, labels map[string]string) error {
 
 	customizelog := logger.WithValues("func", "ElasticsearchCreteStatefulSet")
@@ -303,23 +247,23 @@ func (r *LogFileReconciler) ElasticsearchCreteService(ctx context.Context, logfi
 
 	// 级联删除service
 	customizelog.Info("set serviceheadless reference")
-	if err := controllerutil.SetControllerReference(logfile, serviceheadless, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, serviceheadless, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	if err := controllerutil.SetControllerReference(logfile, service, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, service, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建service
-	if err := r.Create(ctx, serviceheadless); err != nil {
+	// 新建或更新service
+	if _, err := r.CreteOrUpdate(ctx, logfile, serviceheadless); err != nil {
 		return err
 	}
-	if err := r.Create(ctx, service); err != nil {
+	if _, err := r.CreteOrUpdate(ctx, logfile, service); err != nil {
 		return err
 	}
 
-	customizelog.Info("create serviceheadless and service success", "name", typesname.String())
+	customizelog.Info("reconcile serviceheadless and service success", "name", typesname.String())
 
 	return nil
 }
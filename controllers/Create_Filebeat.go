@@ -4,93 +4,110 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
 
 	apiv1 "github.com/huisebug/logfile-operator/api/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 func (r *LogFileReconciler) FilebeatCreteConfigMap(ctx context.Context, logfile *apiv1.LogFile, typesname types.NamespacedName, meta metav1.ObjectMeta, labels map[string]string) error {
 	var filebeatyml string
 	customizelog := logger.WithValues("func", "FilebeatCreteConfigMap")
-	switch logfile.Spec.ProgrammeNum {
-	case 1:
+	// 设置了保留策略时索引模板和ILM策略由operator创建
+	setup := ""
+	if logfile.Spec.Retention != nil {
+		setup = `
+setup.template.enabled: false
+setup.ilm.enabled: false`
+	}
+	switch {
+	case logfile.Spec.KafkaMode() != apiv1.ModeNone:
 		filebeatyml = fmt.Sprintf(`
-output.elasticsearch:
-  index: "logfile-operator-filebeat-%%{+yyyy.MM.dd}"
-  hosts: ['http://elasticsearch.logfile-operator-system:9200']
-  username: elastic
-  password: %s
-#自定义索引名
-setup.template.name: "logfile-operator-filebeat"
-setup.template.pattern: "logfile-operator-filebeat-*"
+output.kafka:
+  # 使用kafka
+  hosts: ['%s']
+  # 主题，设置了路由topic的日志写入各自的topic
+  topic: '%%{[fields.%s]:kafka_log}'
+  # 大于max_message_bytes将被丢弃的事件
+  max_message_bytes: 1000000
 
 http.enabled: true
 http.host: 0.0.0.0
-`, logfile.Spec.ELASTIC_PASSWORD)
+`, KafkaBootstrapServers(logfile), RouteTopicField)
 
-	case 2:
+	case logfile.Spec.LogstashEnabled():
 		filebeatyml = fmt.Sprintf(`
-output.elasticsearch:
-  index: "logfile-operator-filebeat-%%{+yyyy.MM.dd}"
-  hosts: ['https://elasticsearch-master.logfile-operator-system:9200']
-  protocol: https
-  ssl.certificate_authorities: ["/usr/share/elasticsearch/config/certs/ca.crt"]
-  username: elastic
-  password: %s
-#自定义索引名
-setup.template.name: "logfile-operator-filebeat"
-setup.template.pattern: "logfile-operator-filebeat-*"
-
-http.enabled: true
-http.host: 0.0.0.0
-`, logfile.Spec.ELASTIC_PASSWORD)
-
-	case 3, 4:
-		filebeatyml = `
 output.logstash:
   # 使用logstash
-  hosts: ['logstash.logfile-operator-system:5044']
+  hosts: ['%s']
 
 http.enabled: true
 http.host: 0.0.0.0
-`
+`, LogstashHosts(logfile))
 
-	case 5:
-		filebeatyml = `
-output.kafka:
-  # 使用kafka
-  hosts: ['kafka.logfile-operator-system:9092']
-  # 主题
-  topic: kafka_log
-  # 大于max_message_bytes将被丢弃的事件
-  max_message_bytes: 1000000
+	case logfile.Spec.ElasticsearchMode() == apiv1.ModeCluster:
+		filebeatyml = fmt.Sprintf(`
+output.elasticsearch:
+  index: "%s"
+  hosts: ['%s']
+  protocol: https
+  ssl.certificate_authorities: ["/usr/share/elasticsearch/config/certs/ca.crt"]
+  username: %s
+  # 密码由sidecar从同namespace的secret读取
+  password: "${LOGFILE_ES_PASSWORD}"
+#自定义索引名
+setup.template.name: "logfile-operator-filebeat"
+setup.template.pattern: "logfile-operator-filebeat-*"%s
 
 http.enabled: true
 http.host: 0.0.0.0
-`
+`, IndexName(logfile, RouteIndex("logfile-operator-filebeat")), ElasticsearchURL(logfile), WriterUsername, setup)
 
-	case 6:
-		filebeatyml = `
-output.kafka:
-  # 使用kafka集群
-  hosts: ['kafka-cluster-headless.logfile-operator-system:9092']
-  # 主题
-  topic: kafka_log
-  # 大于max_message_bytes将被丢弃的事件
-  max_message_bytes: 1000000
+	default:
+		filebeatyml = fmt.Sprintf(`
+output.elasticsearch:
+  index: "%s"
+  hosts: ['%s']
+  username: %s
+  # 密码由sidecar从同namespace的secret读取
+  password: "${LOGFILE_ES_PASSWORD}"
+#自定义索引名
+setup.template.name: "logfile-operator-filebeat"
+setup.template.pattern: "logfile-operator-filebeat-*"%s
 
 http.enabled: true
 http.host: 0.0.0.0
-`
+`, IndexName(logfile, RouteIndex("logfile-operator-filebeat")), ElasticsearchURL(logfile), WriterUsername, setup)
 
 	}
 	tmpmap := make(map[string]string)
 	tmpmap["filebeat.yml"] = filebeatyml
-	// 传递方案序号，让sidecar可以获取到
-	tmpmap["programmenumber"] = strconv.Itoa(logfile.Spec.ProgrammeNum)
+	// 传递各组件的部署模式，让sidecar可以获取到
+	tmpmap["elasticsearch.mode"] = logfile.Spec.ElasticsearchMode()
+	tmpmap["kafka.mode"] = logfile.Spec.KafkaMode()
+	tmpmap["logstash.enabled"] = strconv.FormatBool(logfile.Spec.LogstashEnabled())
+	// 传递sidecar使用的镜像，与组件使用相同的镜像仓库配置
+	tmpmap["filebeat.image"] = r.Images.Image(logfile, apiv1.ComponentFilebeat)
+	pullsecrets := []string{}
+	for _, pullsecret := range r.Images.ImagePullSecrets(logfile) {
+		pullsecrets = append(pullsecrets, pullsecret.Name)
+	}
+	tmpmap["image.pullsecrets"] = strings.Join(pullsecrets, ",")
+	// 传递sidecar的安全配置
+	profile, runAsUser := logfile.Spec.SidecarSecurityProfile()
+	tmpmap["sidecar.securityprofile"] = profile
+	tmpmap["sidecar.runasuser"] = strconv.FormatInt(runAsUser, 10)
+	// 传递允许写入该组件的namespace，注入前由pod webhook校验
+	if logfile.Spec.AllowedNamespaces != nil {
+		selector, err := metav1.LabelSelectorAsSelector(logfile.Spec.AllowedNamespaces)
+		if err != nil {
+			customizelog.Error(err, "allowedNamespaces error")
+			return err
+		}
+		tmpmap[AllowedNamespacesKey] = selector.String()
+	}
 
 	configmap := &corev1.ConfigMap{
 		ObjectMeta: meta,
@@ -99,16 +116,16 @@ http.host: 0.0.0.0
 
 	// 级联删除
 	customizelog.Info("set configmap reference")
-	if err := controllerutil.SetControllerReference(logfile, configmap, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, configmap, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建
-	if err := r.Create(ctx, configmap); err != nil {
+	// 新建或更新
+	if _, err := r.CreteOrUpdate(ctx, logfile, configmap); err != nil {
 		return err
 	}
 
-	customizelog.Info("create configmap success", "name", typesname.String())
+	customizelog.Info("reconcile configmap success", "name", typesname.String())
 
 	return nil
 }
@@ -14,7 +14,6 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/pointer"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 func FormatStorageint(storage string) int {
@@ -60,7 +59,7 @@ func (r *LogFileReconciler) KafkaCreteStatefulSet(ctx context.Context, logfile *
 					},
 				},
 			},
-			Replicas:    pointer.Int32Ptr(1),
+			Replicas:    pointer.Int32Ptr(logfile.Spec.Replicas.Kafka),
 			Selector:    metav1.SetAsLabelSelector(labels),
 			ServiceName: meta.Name + "-headless",
 
@@ -69,10 +68,11 @@ func (r *LogFileReconciler) KafkaCreteStatefulSet(ctx context.Context, logfile *
 					Labels: labels,
 				},
 				Spec: corev1.PodSpec{
+					ImagePullSecrets: r.Images.ImagePullSecrets(logfile),
 					Containers: []corev1.Container{
 						{
 							Name:            "zookeeper",
-							Image:           "registry.cn-hangzhou.aliyuncs.com/huisebug/logfile-operator:zookeeper-3.8",
+							Image:           r.Images.Image(logfile, apiv1.ComponentZookeeper),
 							ImagePullPolicy: corev1.PullIfNotPresent,
 							Env: []corev1.EnvVar{
 								{
@@ -125,7 +125,7 @@ func (r *LogFileReconciler) KafkaCreteStatefulSet(ctx context.Context, logfile *
 						},
 						{
 							Name:            "kafka",
-							Image:           "registry.cn-hangzhou.aliyuncs.com/huisebug/logfile-operator:kafka-3.3",
+							Image:           r.Images.Image(logfile, apiv1.ComponentKafka),
 							ImagePullPolicy: corev1.PullIfNotPresent,
 							Env: []corev1.EnvVar{
 								{
@@ -138,7 +138,7 @@ func (r *LogFileReconciler) KafkaCreteStatefulSet(ctx context.Context, logfile *
 								},
 								{
 									Name:  "KAFKA_CFG_ADVERTISED_LISTENERS",
-									Value: "PLAINTEXT://kafka.logfile-operator-system:9092",
+									Value: "PLAINTEXT://" + KafkaBootstrapServers(logfile),
 								},
 								{
 									Name:  "KAFKA_CFG_ZOOKEEPER_CONNECT",
@@ -198,17 +198,21 @@ func (r *LogFileReconciler) KafkaCreteStatefulSet(ctx context.Context, logfile *
 		},
 	}
 
+	// 合并LogFile中的pod配置
+	ApplyPodOverride(&statefulset.Spec.Template, logfile.Spec.PodOverride(apiv1.ComponentKafka), "kafka")
+	ApplyContainerOverride(&statefulset.Spec.Template, logfile.Spec.PodOverride(apiv1.ComponentZookeeper), "zookeeper")
+
 	// 级联删除
 	customizelog.Info("set statefulset reference")
-	if err := controllerutil.SetControllerReference(logfile, statefulset, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, statefulset, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建
-	if err := r.Create(ctx, statefulset); err != nil {
+	// 新建或更新
+	if _, err := r.CreteOrUpdate(ctx, logfile, statefulset); err != nil {
 		return err
 	}
-	customizelog.Info("create statefulset success", "name", typesname.String())
+	customizelog.Info("reconcile statefulset success", "name", typesname.String())
 	return nil
 }
 func (r *LogFileReconciler) KafkaCreteService(ctx context.Context, logfile *apiv1.LogFile, typesname types.NamespacedName, meta metav1.ObjectMeta, labels map[string]string) error {
@@ -249,23 +253,23 @@ func (r *LogFileReconciler) KafkaCreteService(ctx context.Context, logfile *apiv
 	}
 
 	// 级联删除service
-	if err := controllerutil.SetControllerReference(logfile, serviceheadless, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, serviceheadless, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	if err := controllerutil.SetControllerReference(logfile, service, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, service, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建service
-	if err := r.Create(ctx, serviceheadless); err != nil {
+	// 新建或更新service
+	if _, err := r.CreteOrUpdate(ctx, logfile, serviceheadless); err != nil {
 		return err
 	}
-	if err := r.Create(ctx, service); err != nil {
+	if _, err := r.CreteOrUpdate(ctx, logfile, service); err != nil {
 		return err
 	}
 
-	customizelog.Info("create  serviceheadless and service success", "name", typesname.String())
+	customizelog.Info("reconcile  serviceheadless and service success", "name", typesname.String())
 
 	return nil
 }
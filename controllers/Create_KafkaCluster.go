@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"log"
 
 	apiv1 "github.com/huisebug/logfile-operator/api/v1"
@@ -12,7 +13,6 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/pointer"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 func (r *LogFileReconciler) KafkaClusterCreteServiceAccount(ctx context.Context, logfile *apiv1.LogFile, typesname types.NamespacedName, meta metav1.ObjectMeta, labels map[string]string) error {
@@ -26,16 +26,16 @@ func (r *LogFileReconciler) KafkaClusterCreteServiceAccount(ctx context.Context,
 
 	// 级联删除
 	customizelog.Info("set sa reference")
-	if err := controllerutil.SetControllerReference(logfile, sa, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, sa, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建
-	if err := r.Create(ctx, sa); err != nil {
+	// 新建或更新
+	if _, err := r.CreteOrUpdate(ctx, logfile, sa); err != nil {
 		return err
 	}
 
-	customizelog.Info("create sa success", "name", typesname.String())
+	customizelog.Info("reconcile sa success", "name", typesname.String())
 
 	return nil
 }
@@ -69,16 +69,16 @@ exec /entrypoint.sh /run.sh
 	}
 
 	// 级联删除
-	if err := controllerutil.SetControllerReference(logfile, kafkaconfigmap, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, kafkaconfigmap, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建
-	if err := r.Create(ctx, kafkaconfigmap); err != nil {
+	// 新建或更新
+	if _, err := r.CreteOrUpdate(ctx, logfile, kafkaconfigmap); err != nil {
 		return err
 	}
 
-	customizelog.Info("create kafkaconfigmap success", "name", typesname.String())
+	customizelog.Info("reconcile kafkaconfigmap success", "name", typesname.String())
 
 	return nil
 }
@@ -145,23 +145,23 @@ func (r *LogFileReconciler) KafkaClusterCreteService(ctx context.Context, logfil
 
 	// 级联删除service
 	customizelog.Info("set serviceheadless reference")
-	if err := controllerutil.SetControllerReference(logfile, kafkaserviceheadless, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, kafkaserviceheadless, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	if err := controllerutil.SetControllerReference(logfile, kafkaservice, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, kafkaservice, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建service
-	if err := r.Create(ctx, kafkaserviceheadless); err != nil {
+	// 新建或更新service
+	if _, err := r.CreteOrUpdate(ctx, logfile, kafkaserviceheadless); err != nil {
 		return err
 	}
-	if err := r.Create(ctx, kafkaservice); err != nil {
+	if _, err := r.CreteOrUpdate(ctx, logfile, kafkaservice); err != nil {
 		return err
 	}
 
-	customizelog.Info("create kafkaserviceheadless and kafkaservice success", "name", typesname.String())
+	customizelog.Info("reconcile kafkaserviceheadless and kafkaservice success", "name", typesname.String())
 
 	return nil
 }
@@ -200,7 +200,7 @@ func (r *LogFileReconciler) KafkaClusterCreteStatefulSet(ctx context.Context, lo
 					},
 				},
 			},
-			Replicas:            pointer.Int32Ptr(3),
+			Replicas:            pointer.Int32Ptr(logfile.Spec.Replicas.Kafka),
 			PodManagementPolicy: appsv1.PodManagementPolicyType("Parallel"),
 			Selector:            metav1.SetAsLabelSelector(labels),
 			ServiceName:         meta.Name + "-headless",
@@ -213,6 +213,7 @@ func (r *LogFileReconciler) KafkaClusterCreteStatefulSet(ctx context.Context, lo
 					Labels: labels,
 				},
 				Spec: corev1.PodSpec{
+					ImagePullSecrets:   r.Images.ImagePullSecrets(logfile),
 					HostNetwork:        false,
 					HostIPC:            false,
 					ServiceAccountName: meta.Name,
@@ -267,7 +268,7 @@ func (r *LogFileReconciler) KafkaClusterCreteStatefulSet(ctx context.Context, lo
 								AllowPrivilegeEscalation: &AllowPrivilegeEscalation,
 							},
 							Name:            "kafka",
-							Image:           "registry.cn-hangzhou.aliyuncs.com/huisebug/logfile-operator:kafka-3.3",
+							Image:           r.Images.Image(logfile, apiv1.ComponentKafka),
 							ImagePullPolicy: corev1.PullIfNotPresent,
 							Command:         []string{"bash", "-c", "/scripts/setup.sh"},
 							LivenessProbe: &corev1.Probe{
@@ -357,7 +358,7 @@ func (r *LogFileReconciler) KafkaClusterCreteStatefulSet(ctx context.Context, lo
 								},
 								{
 									Name:  "KAFKA_CFG_ADVERTISED_LISTENERS",
-									Value: "INTERNAL://$(MY_POD_NAME).kafka-cluster-headless.logfile-operator-system.svc.cluster.local:9093,CLIENT://$(MY_POD_NAME).kafka-cluster-headless.logfile-operator-system.svc.cluster.local:9092",
+									Value: fmt.Sprintf("INTERNAL://$(MY_POD_NAME).%[1]s-headless.%[2]s.svc.cluster.local:9093,CLIENT://$(MY_POD_NAME).%[1]s-headless.%[2]s.svc.cluster.local:9092", meta.Name, meta.Namespace),
 								},
 								{
 									Name:  "ALLOW_PLAINTEXT_LISTENER",
@@ -503,18 +504,21 @@ func (r *LogFileReconciler) KafkaClusterCreteStatefulSet(ctx context.Context, lo
 		},
 	}
 
+	// 合并LogFile中的pod配置
+	ApplyPodOverride(&statefulset.Spec.Template, logfile.Spec.PodOverride(apiv1.ComponentKafka), "kafka")
+
 	// 级联删除statefulset
 	customizelog.Info("set statefulset reference")
-	if err := controllerutil.SetControllerReference(logfile, statefulset, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, statefulset, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建statefulset
-	if err := r.Create(ctx, statefulset); err != nil {
+	// 新建或更新statefulset
+	if _, err := r.CreteOrUpdate(ctx, logfile, statefulset); err != nil {
 		return err
 	}
 
-	customizelog.Info("create statefulset success", "name", typesname.String())
+	customizelog.Info("reconcile statefulset success", "name", typesname.String())
 
 	return nil
 }
@@ -12,7 +12,6 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/pointer"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 func (r *LogFileReconciler) KibanaCreteConfigMap(ctx context.Context, logfile *apiv1.LogFile, typesname types.NamespacedName, meta metav1.ObjectMeta, labels map[string]string) error {
@@ -20,26 +19,14 @@ func (r *LogFileReconciler) KibanaCreteConfigMap(ctx context.Context, logfile *a
 	var kibanayml string
 	customizelog := logger.WithValues("func", "KibanaCreteConfigMap")
 
-	switch logfile.Spec.ProgrammeNum {
-	case 1, 3, 5:
-		kibanayml = fmt.Sprintf(`
+	kibanayml = fmt.Sprintf(`
 server.name: kibana
 server.host: 0.0.0.0
-elasticsearch.hosts: [ "http://elasticsearch.logfile-operator-system:9200" ]
+elasticsearch.hosts: [ "%s" ]
 monitoring.ui.container.elasticsearch.enabled: true
 elasticsearch.username: kibana_system
-elasticsearch.password: %s
-`, logfile.Spec.KIBANA_PASSWORD)
-	case 2, 4, 6:
-		kibanayml = fmt.Sprintf(`
-server.name: kibana
-server.host: 0.0.0.0
-elasticsearch.hosts: [ "https://elasticsearch-master.logfile-operator-system:9200" ]
-monitoring.ui.container.elasticsearch.enabled: true
-elasticsearch.username: kibana_system
-elasticsearch.password: %s
-`, logfile.Spec.KIBANA_PASSWORD)
-	}
+elasticsearch.password: "${KIBANA_PASSWORD}"
+`, ElasticsearchURL(logfile))
 
 	tmpmap := make(map[string]string)
 	tmpmap["kibana.yml"] = kibanayml
@@ -51,15 +38,15 @@ elasticsearch.password: %s
 
 	// 级联删除
 	customizelog.Info("set configmap reference")
-	if err := controllerutil.SetControllerReference(logfile, configmap, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, configmap, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建
-	if err := r.Create(ctx, configmap); err != nil {
+	// 新建或更新
+	if _, err := r.CreteOrUpdate(ctx, logfile, configmap); err != nil {
 		return err
 	}
-	customizelog.Info("create configmap success", "name", typesname.String())
+	customizelog.Info("reconcile configmap success", "name", typesname.String())
 
 	return nil
 }
@@ -72,6 +59,10 @@ func (r *LogFileReconciler) KibanaCreteDeployment(ctx context.Context, logfile *
 			Name:  "I18N_LOCALE",
 			Value: "zh-CN",
 		},
+		{
+			Name:      "KIBANA_PASSWORD",
+			ValueFrom: SecretKeyRef(CredentialsSecretName, KibanaPasswordKey),
+		},
 	}
 
 	volume := []corev1.Volume{
@@ -95,7 +86,7 @@ func (r *LogFileReconciler) KibanaCreteDeployment(ctx context.Context, logfile *
 		},
 	}
 
-	if logfile.Spec.ProgrammeNum == 2 || logfile.Spec.ProgrammeNum == 4 || logfile.Spec.ProgrammeNum == 6 {
+	if logfile.Spec.ElasticsearchMode() == apiv1.ModeCluster {
 		env = append(env,
 			corev1.EnvVar{
 				Name:  "ELASTICSEARCH_SSL_CERTIFICATEAUTHORITIES",
@@ -104,37 +95,61 @@ func (r *LogFileReconciler) KibanaCreteDeployment(ctx context.Context, logfile *
 		)
 		volumemount = append(volumemount,
 			corev1.VolumeMount{
-				Name:      "elasticsearch-master-certs",
+				Name:      CertsSecretName("elasticsearch-master"),
 				MountPath: "/usr/share/elasticsearch/config/certs/",
 				ReadOnly:  true,
 			},
 		)
 		volume = append(volume,
 			corev1.Volume{
-				Name: "elasticsearch-master-certs",
+				Name: CertsSecretName("elasticsearch-master"),
 				VolumeSource: corev1.VolumeSource{
 					Secret: &corev1.SecretVolumeSource{
-						SecretName: "elasticsearch-master-certs",
+						SecretName: CertsSecretName("elasticsearch-master"),
 					},
 				},
 			},
 		)
 	}
+	// 配置文件变更后滚动重启kibana
+	confighash, err := r.ConfigMapHash(ctx, meta.Namespace, meta.Name)
+	if err != nil {
+		return err
+	}
+	// 密码变更后环境变量不会更新，同样需要滚动重启
+	credentialsversion, err := r.SecretVersion(ctx, meta.Namespace, CredentialsSecretName)
+	if err != nil {
+		return err
+	}
+	annotations := map[string]string{
+		ConfigHashAnnotation:         confighash,
+		CredentialsVersionAnnotation: credentialsversion,
+	}
+	// ca轮换后需要重新加载证书
+	if logfile.Spec.ElasticsearchMode() == apiv1.ModeCluster {
+		cahash, err := r.SecretHash(ctx, meta.Namespace, CertsSecretName("elasticsearch-master"), "ca.crt")
+		if err != nil {
+			return err
+		}
+		annotations[CAHashAnnotation] = cahash
+	}
 	deployment := &appsv1.Deployment{
 		ObjectMeta: meta,
 		Spec: appsv1.DeploymentSpec{
 
-			Replicas: pointer.Int32Ptr(1),
+			Replicas: pointer.Int32Ptr(logfile.Spec.Replicas.Kibana),
 			Selector: metav1.SetAsLabelSelector(labels),
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+					Labels:      labels,
+					Annotations: annotations,
 				},
 				Spec: corev1.PodSpec{
+					ImagePullSecrets: r.Images.ImagePullSecrets(logfile),
 					Containers: []corev1.Container{
 						{
 							Name:            "kibana",
-							Image:           "registry.cn-hangzhou.aliyuncs.com/huisebug/logfile-operator:kibana-8.5.0",
+							Image:           r.Images.Image(logfile, apiv1.ComponentKibana),
 							ImagePullPolicy: corev1.PullIfNotPresent,
 							Env:             env,
 							Ports: []corev1.ContainerPort{
@@ -181,17 +196,20 @@ func (r *LogFileReconciler) KibanaCreteDeployment(ctx context.Context, logfile *
 		},
 	}
 
+	// 合并LogFile中的pod配置
+	ApplyPodOverride(&deployment.Spec.Template, logfile.Spec.PodOverride(apiv1.ComponentKibana), "kibana")
+
 	// 级联删除deployment
 	customizelog.Info("set deployment reference")
-	if err := controllerutil.SetControllerReference(logfile, deployment, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, deployment, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建deployment
-	if err := r.Create(ctx, deployment); err != nil {
+	// 新建或更新deployment
+	if _, err := r.CreteOrUpdate(ctx, logfile, deployment); err != nil {
 		return err
 	}
-	customizelog.Info("create deployment success", "name", typesname.String())
+	customizelog.Info("reconcile deployment success", "name", typesname.String())
 	return nil
 }
 func (r *LogFileReconciler) KibanaCreteService(ctx context.Context, logfile *apiv1.LogFile, typesname types.NamespacedName, meta metav1.ObjectMeta, labels map[string]string) error {
@@ -216,16 +234,16 @@ func (r *LogFileReconciler) KibanaCreteService(ctx context.Context, logfile *api
 	}
 
 	// 级联删除service
-	if err := controllerutil.SetControllerReference(logfile, service, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, service, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建service
-	if err := r.Create(ctx, service); err != nil {
+	// 新建或更新service
+	if _, err := r.CreteOrUpdate(ctx, logfile, service); err != nil {
 		return err
 	}
 
-	customizelog.Info("create  service success", "name", typesname.String())
+	customizelog.Info("reconcile  service success", "name", typesname.String())
 
 	return nil
 }
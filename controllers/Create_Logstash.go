@@ -11,7 +11,6 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/pointer"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 func (r *LogFileReconciler) LogstashCreteConfigMap(ctx context.Context, logfile *apiv1.LogFile, typesname types.NamespacedName, meta metav1.ObjectMeta, labels map[string]string) error {
@@ -24,9 +23,8 @@ func (r *LogFileReconciler) LogstashCreteConfigMap(ctx context.Context, logfile
 http.host: "0.0.0.0"
 `
 
-	switch logfile.Spec.ProgrammeNum {
-	case 3:
-		logstashconf = fmt.Sprintf(`
+	// 使用kafka时从kafka消费，否则接收filebeat发送的数据
+	input := `
 input {
   # 配置接收Filebeat数据源，监听端口为5044
   # Filebeat的output.logstash地址保持跟这里一致
@@ -34,67 +32,20 @@ input {
     port => 5044
   }
 }
-
-output {
-  # 将数据导入到ES中
-  elasticsearch {
-    hosts => ["http://elasticsearch.logfile-operator-system:9200"]
-    index => "logfile-operator-logstash-%%{+yyyy.MM.dd}"
-
-    # 需要不断新建索引和进行写入索引，不然会提示：
-    # only write ops with an op_type of create are allowed in data streams
-    action => "create"
-     #es的用户名和密码
-    user => "elastic"
-    password => %s
-  }
-  stdout {
-    codec => rubydebug
-  }  
-}	
-`, logfile.Spec.KIBANA_PASSWORD)
-
-	case 4:
-		logstashconf = fmt.Sprintf(`
-input {
-  # 配置接收Filebeat数据源，监听端口为5044
-  # Filebeat的output.logstash地址保持跟这里一致
-  beats {
-    port => 5044
-  }
-}
-output {
-  #将数据导入到ES中
-  elasticsearch {  
-    index => "logfile-operator-logstash-%%{+yyyy.MM.dd}"
-    # 处理因kafka转换过的日志内容
-    codec => line { format => "%%{message}"}
-    # 需要不断新建索引和进行写入索引，不然会提示：
-    # only write ops with an op_type of create are allowed in data streams
-    action => "create"
-    #es的用户名和密码
-    user => "elastic"
-    password => %s
-    #使用https的es8集群配置
-    hosts => ["https://elasticsearch-master.logfile-operator-system:9200"]
-    ssl => true
-    #crt证书的所在路径
-    cacert => '/usr/share/elasticsearch/config/certs/ca.crt'
-  }
-  stdout {
-    codec => rubydebug
-  }
-}	
-`, logfile.Spec.KIBANA_PASSWORD)
-
-	case 5:
-		logstashconf = fmt.Sprintf(`
+`
+	index := "logfile-operator-logstash"
+	codec := ""
+	switch logfile.Spec.KafkaMode() {
+	case apiv1.ModeSingle, apiv1.ModeCluster:
+		input = fmt.Sprintf(`
 input {
   kafka {
     #kafka地址
-    bootstrap_servers => "kafka.logfile-operator-system:9092"
-    # kafka主题
-    topics => "kafka_log"
+    bootstrap_servers => "%s"
+    # kafka主题，包括日志路由的topic
+    topics_pattern => "kafka_log|%s.*"
+    # 定期刷新元数据，发现新创建的路由topic
+    metadata_max_age_ms => 60000
     # 消费者线程数
     consumer_threads => 1
     # 当 Kafka 中没有初始偏移量或偏移量超出范围时该怎么办
@@ -103,69 +54,71 @@ input {
     codec => "json"
   }
 }
-
-output {
-  # 将数据导入到ES中
-  elasticsearch {
-    hosts => ["http://elasticsearch.logfile-operator-system:9200"]
-    index => "logfile-operator-kafka-logstash-%%{+yyyy.MM.dd}"
+`, KafkaBootstrapServers(logfile), RoutePrefix)
+		index = "logfile-operator-kafka-logstash"
+		if logfile.Spec.KafkaMode() == apiv1.ModeCluster {
+			index = "logfile-operator-kafka-cluster-logstash"
+		}
+		codec = `
     # 处理因kafka转换过的日志内容
-    codec => line { format => "%%{message}"}
-    # 需要不断新建索引和进行写入索引，不然会提示：
-    # only write ops with an op_type of create are allowed in data streams
-    action => "create"
-     #es的用户名和密码
-    user => "elastic"
-    password => %s
-  }
-  stdout {
-    codec => rubydebug
-  }
-}	
-`, logfile.Spec.KIBANA_PASSWORD)
+    codec => line { format => "%{message}"}`
+	}
 
-	case 6:
-		logstashconf = fmt.Sprintf(`
-input {
-  kafka {
-    #kafka地址
-    bootstrap_servers => "kafka-cluster-headless.logfile-operator-system:9092"
-    # kafka主题
-    topics => "kafka_log"
-    # 消费者线程数
-    consumer_threads => 1
-    # 当 Kafka 中没有初始偏移量或偏移量超出范围时该怎么办
-    auto_offset_reset => "latest"
-    # 处理因kafka转换过的日志内容
-    codec => "json"
+	// 集群模式的elasticsearch使用https
+	ssl := ""
+	switch {
+	case logfile.Spec.ElasticsearchMode() != apiv1.ModeCluster:
+	case VersionAtLeast(StackVersion(logfile), "8.8.0"):
+		// 8.8开始ssl和cacert参数已废弃
+		ssl = `
+    ssl_enabled => true
+    #crt证书的所在路径
+    ssl_certificate_authorities => ['/usr/share/elasticsearch/config/certs/ca.crt']`
+	default:
+		ssl = `
+    #使用https的es集群配置
+    ssl => true
+    #crt证书的所在路径
+    cacert => '/usr/share/elasticsearch/config/certs/ca.crt'`
+	}
+
+	// 设置了保留策略时写入data stream，索引模板和ILM策略由operator创建
+	setup := ""
+	if logfile.Spec.Retention != nil {
+		setup = `
+    manage_template => false
+    ilm_enabled => false`
+	}
+
+	// 设置了路由索引的日志写入各自的索引
+	filter := fmt.Sprintf(`
+filter {
+  if [fields][%s] {
+    mutate { add_field => { "[@metadata][logfile_index]" => "%%{[fields][%s]}" } }
+  } else {
+    mutate { add_field => { "[@metadata][logfile_index]" => "%s" } }
   }
 }
+`, RouteIndexField, RouteIndexField, index)
 
+	logstashconf = fmt.Sprintf(`%s%s
 output {
-  #将数据导入到ES中
-  elasticsearch {  
-    index => "logfile-operator-kafka-cluster-logstash-%%{+yyyy.MM.dd}"
-    # 处理因kafka转换过的日志内容
-    codec => line { format => "%%{message}"}
+  # 将数据导入到ES中
+  elasticsearch {
+    hosts => ["%s"]
+    index => "%s"%s%s
     # 需要不断新建索引和进行写入索引，不然会提示：
     # only write ops with an op_type of create are allowed in data streams
     action => "create"
-    #es的用户名和密码
-    user => "elastic"
-    password => %s
-    #使用https的es8集群配置
-    hosts => ["https://elasticsearch-master.logfile-operator-system:9200"]
-    ssl => true
-    #crt证书的所在路径
-    cacert => '/usr/share/elasticsearch/config/certs/ca.crt'
+    #es的用户名和密码，密码从环境变量读取
+    user => "%s"
+    password => "${LOGFILE_ES_PASSWORD}"%s
   }
   stdout {
     codec => rubydebug
   }
-}	
-`, logfile.Spec.KIBANA_PASSWORD)
-
-	}
+}
+`, input, filter, ElasticsearchURL(logfile), IndexName(logfile, "%{[@metadata][logfile_index]}"), codec, setup, WriterUsername, ssl)
 
 	ymlmap := make(map[string]string)
 	confmap := make(map[string]string)
@@ -189,23 +142,23 @@ output {
 
 	// 级联删除
 	customizelog.Info("set configmap reference")
-	if err := controllerutil.SetControllerReference(logfile, ymlconfigmap, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, ymlconfigmap, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
 	customizelog.Info("set configmap reference")
-	if err := controllerutil.SetControllerReference(logfile, confconfigmap, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, confconfigmap, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建
-	if err := r.Create(ctx, ymlconfigmap); err != nil {
+	// 新建或更新
+	if _, err := r.CreteOrUpdate(ctx, logfile, ymlconfigmap); err != nil {
 		return err
 	}
-	if err := r.Create(ctx, confconfigmap); err != nil {
+	if _, err := r.CreteOrUpdate(ctx, logfile, confconfigmap); err != nil {
 		return err
 	}
-	customizelog.Info("create configmap success", "name", typesname.String())
+	customizelog.Info("reconcile configmap success", "name", typesname.String())
 
 	return nil
 }
@@ -218,6 +171,10 @@ func (r *LogFileReconciler) LogstashCreteDeployment(ctx context.Context, logfile
 			Name:  "I18N_LOCALE",
 			Value: "zh-CN",
 		},
+		{
+			Name:      "LOGFILE_ES_PASSWORD",
+			ValueFrom: SecretKeyRef(CredentialsSecretName, WriterPasswordKey),
+		},
 	}
 
 	volume := []corev1.Volume{
@@ -258,40 +215,64 @@ func (r *LogFileReconciler) LogstashCreteDeployment(ctx context.Context, logfile
 		},
 	}
 
-	if logfile.Spec.ProgrammeNum == 4 || logfile.Spec.ProgrammeNum == 6 {
+	if logfile.Spec.ElasticsearchMode() == apiv1.ModeCluster {
 		volumemount = append(volumemount,
 			corev1.VolumeMount{
-				Name:      "elasticsearch-master-certs",
+				Name:      CertsSecretName("elasticsearch-master"),
 				MountPath: "/usr/share/elasticsearch/config/certs/",
 				ReadOnly:  true,
 			},
 		)
 		volume = append(volume,
 			corev1.Volume{
-				Name: "elasticsearch-master-certs",
+				Name: CertsSecretName("elasticsearch-master"),
 				VolumeSource: corev1.VolumeSource{
 					Secret: &corev1.SecretVolumeSource{
-						SecretName: "elasticsearch-master-certs",
+						SecretName: CertsSecretName("elasticsearch-master"),
 					},
 				},
 			},
 		)
 	}
+	// 配置文件以subPath挂载不会自动更新，变更后滚动重启logstash
+	confighash, err := r.ConfigMapHash(ctx, meta.Namespace, meta.Name+"yml", meta.Name+"conf")
+	if err != nil {
+		return err
+	}
+	// 密码变更后环境变量不会更新，同样需要滚动重启
+	credentialsversion, err := r.SecretVersion(ctx, meta.Namespace, CredentialsSecretName)
+	if err != nil {
+		return err
+	}
+	annotations := map[string]string{
+		ConfigHashAnnotation:         confighash,
+		CredentialsVersionAnnotation: credentialsversion,
+	}
+	// ca轮换后需要重新加载证书
+	if logfile.Spec.ElasticsearchMode() == apiv1.ModeCluster {
+		cahash, err := r.SecretHash(ctx, meta.Namespace, CertsSecretName("elasticsearch-master"), "ca.crt")
+		if err != nil {
+			return err
+		}
+		annotations[CAHashAnnotation] = cahash
+	}
 	deployment := &appsv1.Deployment{
 		ObjectMeta: meta,
 		Spec: appsv1.DeploymentSpec{
 
-			Replicas: pointer.Int32Ptr(1),
+			Replicas: pointer.Int32Ptr(logfile.Spec.Replicas.Logstash),
 			Selector: metav1.SetAsLabelSelector(labels),
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+					Labels:      labels,
+					Annotations: annotations,
 				},
 				Spec: corev1.PodSpec{
+					ImagePullSecrets: r.Images.ImagePullSecrets(logfile),
 					Containers: []corev1.Container{
 						{
 							Name:            "logstash",
-							Image:           "registry.cn-hangzhou.aliyuncs.com/huisebug/logfile-operator:logstash-8.5.0",
+							Image:           r.Images.Image(logfile, apiv1.ComponentLogstash),
 							ImagePullPolicy: corev1.PullIfNotPresent,
 							Env:             env,
 							Ports: []corev1.ContainerPort{
@@ -312,7 +293,7 @@ func (r *LogFileReconciler) LogstashCreteDeployment(ctx context.Context, logfile
 	}
 
 	// 当logstash作为kafka消费者时候，不会运行5044端口服务
-	if logfile.Spec.ProgrammeNum == 3 || logfile.Spec.ProgrammeNum == 4 {
+	if logfile.Spec.KafkaMode() == apiv1.ModeNone {
 		livenssprobe := &corev1.Probe{
 			ProbeHandler: corev1.ProbeHandler{
 				TCPSocket: &corev1.TCPSocketAction{
@@ -351,17 +332,20 @@ func (r *LogFileReconciler) LogstashCreteDeployment(ctx context.Context, logfile
 
 	}
 
+	// 合并LogFile中的pod配置
+	ApplyPodOverride(&deployment.Spec.Template, logfile.Spec.PodOverride(apiv1.ComponentLogstash), "logstash")
+
 	// 级联删除deployment
 	customizelog.Info("set deployment reference")
-	if err := controllerutil.SetControllerReference(logfile, deployment, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, deployment, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建deployment
-	if err := r.Create(ctx, deployment); err != nil {
+	// 新建或更新deployment
+	if _, err := r.CreteOrUpdate(ctx, logfile, deployment); err != nil {
 		return err
 	}
-	customizelog.Info("create deployment success", "name", typesname.String())
+	customizelog.Info("reconcile deployment success", "name", typesname.String())
 	return nil
 }
 func (r *LogFileReconciler) LogstashCreteService(ctx context.Context, logfile *apiv1.LogFile, typesname types.NamespacedName, meta metav1.ObjectMeta, labels map[string]string) error {
@@ -384,16 +368,16 @@ func (r *LogFileReconciler) LogstashCreteService(ctx context.Context, logfile *a
 	}
 
 	// 级联删除service
-	if err := controllerutil.SetControllerReference(logfile, service, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, service, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建service
-	if err := r.Create(ctx, service); err != nil {
+	// 新建或更新service
+	if _, err := r.CreteOrUpdate(ctx, logfile, service); err != nil {
 		return err
 	}
 
-	customizelog.Info("create  service success", "name", typesname.String())
+	customizelog.Info("reconcile  service success", "name", typesname.String())
 
 	return nil
 }
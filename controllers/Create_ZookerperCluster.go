@@ -12,7 +12,6 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/pointer"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 func (r *LogFileReconciler) ZookerperClusterCreteConfigMap(ctx context.Context, logfile *apiv1.LogFile, typesname types.NamespacedName, meta metav1.ObjectMeta, labels map[string]string) error {
@@ -50,17 +49,17 @@ exec /entrypoint.sh /run.sh
 
 	// 级联删除
 	customizelog.Info("set configmap reference")
-	if err := controllerutil.SetControllerReference(logfile, zookeeperconfigmap, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, zookeeperconfigmap, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
 
-	// 新建
-	if err := r.Create(ctx, zookeeperconfigmap); err != nil {
+	// 新建或更新
+	if _, err := r.CreteOrUpdate(ctx, logfile, zookeeperconfigmap); err != nil {
 		return err
 	}
 
-	customizelog.Info("create zookeeperconfigmap success", "name", typesname.String())
+	customizelog.Info("reconcile zookeeperconfigmap success", "name", typesname.String())
 
 	return nil
 }
@@ -156,23 +155,23 @@ func (r *LogFileReconciler) ZookerperClusterCreteService(ctx context.Context, lo
 
 	// 级联删除service
 	customizelog.Info("set serviceheadless reference")
-	if err := controllerutil.SetControllerReference(logfile, zookeeperserviceheadless, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, zookeeperserviceheadless, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	if err := controllerutil.SetControllerReference(logfile, zookeeperservice, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, zookeeperservice, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建service
-	if err := r.Create(ctx, zookeeperserviceheadless); err != nil {
+	// 新建或更新service
+	if _, err := r.CreteOrUpdate(ctx, logfile, zookeeperserviceheadless); err != nil {
 		return err
 	}
-	if err := r.Create(ctx, zookeeperservice); err != nil {
+	if _, err := r.CreteOrUpdate(ctx, logfile, zookeeperservice); err != nil {
 		return err
 	}
 
-	customizelog.Info("create zookeeperserviceheadless and zookeeperservice success", "name", typesname.String())
+	customizelog.Info("reconcile zookeeperserviceheadless and zookeeperservice success", "name", typesname.String())
 
 	return nil
 }
@@ -212,7 +211,7 @@ func (r *LogFileReconciler) ZookerperClusterCreteStatefulSet(ctx context.Context
 					},
 				},
 			},
-			Replicas:            pointer.Int32Ptr(3),
+			Replicas:            pointer.Int32Ptr(logfile.Spec.Replicas.Zookeeper),
 			PodManagementPolicy: appsv1.PodManagementPolicyType("Parallel"),
 			Selector:            metav1.SetAsLabelSelector(labels),
 			ServiceName:         meta.Name + "-headless",
@@ -224,6 +223,7 @@ func (r *LogFileReconciler) ZookerperClusterCreteStatefulSet(ctx context.Context
 					Labels: labels,
 				},
 				Spec: corev1.PodSpec{
+					ImagePullSecrets:   r.Images.ImagePullSecrets(logfile),
 					ServiceAccountName: "default",
 					Affinity: &corev1.Affinity{
 						PodAntiAffinity: &corev1.PodAntiAffinity{
@@ -270,7 +270,7 @@ func (r *LogFileReconciler) ZookerperClusterCreteStatefulSet(ctx context.Context
 								AllowPrivilegeEscalation: &AllowPrivilegeEscalation,
 							},
 							Name:            "zookeeper",
-							Image:           "registry.cn-hangzhou.aliyuncs.com/huisebug/logfile-operator:zookeeper-3.8",
+							Image:           r.Images.Image(logfile, apiv1.ComponentZookeeper),
 							ImagePullPolicy: corev1.PullIfNotPresent,
 							Command:         []string{"bash", "-c", "/scripts/setup.sh"},
 							LivenessProbe: &corev1.Probe{
@@ -386,7 +386,7 @@ func (r *LogFileReconciler) ZookerperClusterCreteStatefulSet(ctx context.Context
 								},
 								{
 									Name:  "ZOO_SERVERS",
-									Value: "kafka-cluster-zookeeper-0.kafka-cluster-zookeeper-headless.logfile-operator-system.svc.cluster.local:2888:3888::1 kafka-cluster-zookeeper-1.kafka-cluster-zookeeper-headless.logfile-operator-system.svc.cluster.local:2888:3888::2 kafka-cluster-zookeeper-2.kafka-cluster-zookeeper-headless.logfile-operator-system.svc.cluster.local:2888:3888::3",
+									Value: ZookeeperServers(meta.Name, meta.Namespace, int(logfile.Spec.Replicas.Zookeeper)),
 								},
 								{
 									Name:  "ZOO_ENABLE_AUTH",
@@ -437,18 +437,21 @@ func (r *LogFileReconciler) ZookerperClusterCreteStatefulSet(ctx context.Context
 		},
 	}
 
+	// 合并LogFile中的pod配置
+	ApplyPodOverride(&statefulset.Spec.Template, logfile.Spec.PodOverride(apiv1.ComponentZookeeper), "zookeeper")
+
 	// 级联删除statefulset
 	customizelog.Info("set statefulset reference")
-	if err := controllerutil.SetControllerReference(logfile, statefulset, r.Scheme); err != nil {
+	if err := SetOwnerReference(logfile, statefulset, r.Scheme); err != nil {
 		customizelog.Error(err, "SetControllerReference error")
 		return err
 	}
-	// 新建statefulset
-	if err := r.Create(ctx, statefulset); err != nil {
+	// 新建或更新statefulset
+	if _, err := r.CreteOrUpdate(ctx, logfile, statefulset); err != nil {
 		return err
 	}
 
-	customizelog.Info("create statefulset success", "name", typesname.String())
+	customizelog.Info("reconcile statefulset success", "name", typesname.String())
 
 	return nil
 }
@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"reflect"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// 证书有效期和提前轮换的时间
+const (
+	CAValidity          = 10 * 365 * 24 * time.Hour
+	CARenewBefore       = 365 * 24 * time.Hour
+	NodeCertValidity    = 365 * 24 * time.Hour
+	NodeCertRenewBefore = 30 * 24 * time.Hour
+)
+
+// CAHashAnnotation pod模板上记录所信任ca证书的哈希值，ca轮换时触发滚动更新，kibana和logstash只在启动时读取ca
+const CAHashAnnotation = "logfile.huisebug.org/ca-hash"
+
+// CertificateGVK cert-manager的Certificate，使用unstructured访问，不依赖cert-manager的代码
+var CertificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+
+// CASecretName 保存ca私钥的secret，不挂载到任何pod中
+func CASecretName(elasticsearch string) string {
+	return elasticsearch + "-ca"
+}
+
+// CertsSecretName 节点证书所在的secret，包含tls.crt、tls.key和ca.crt
+func CertsSecretName(elasticsearch string) string {
+	return elasticsearch + "-certs"
+}
+
+// ElasticsearchDNSNames 返回节点证书需要包含的域名，覆盖client和headless两个service
+func ElasticsearchDNSNames(name, namespace string) []string {
+	var names []string
+	for _, service := range []string{name, name + "-headless"} {
+		names = append(names,
+			service,
+			service+"."+namespace,
+			service+"."+namespace+".svc",
+			service+"."+namespace+".svc.cluster.local",
+		)
+	}
+	// statefulset各个pod的域名
+	names = append(names,
+		"*."+name+"-headless",
+		"*."+name+"-headless."+namespace,
+		"*."+name+"-headless."+namespace+".svc",
+		"*."+name+"-headless."+namespace+".svc.cluster.local",
+		"localhost",
+	)
+	return names
+}
+
+// CertificateAuthority ca证书和私钥
+type CertificateAuthority struct {
+	Cert *x509.Certificate
+	Key  *rsa.PrivateKey
+	// 轮换前的ca证书，在过期前继续被信任，保证轮换期间新旧证书的节点可以互相访问
+	Previous []*x509.Certificate
+}
+
+// GenerateCA 生成自签名的ca证书
+func GenerateCA(commonname string, now time.Time) (*CertificateAuthority, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randSerial()
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonname},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(CAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateAuthority{Cert: cert, Key: key}, nil
+}
+
+// GenerateNodeCert 使用ca签发节点证书，同时用于http和transport
+func GenerateNodeCert(ca *CertificateAuthority, commonname string, dnsnames []string, now time.Time) (certPEM, keyPEM []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := randSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+	notafter := now.Add(NodeCertValidity)
+	// 节点证书不能比ca更晚过期
+	if notafter.After(ca.Cert.NotAfter) {
+		notafter = ca.Cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonname},
+		DNSNames:     dnsnames,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     notafter,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM, nil
+}
+
+// NodeCertValid 判断节点证书是否由当前ca签发、域名是否一致以及是否临近过期
+func NodeCertValid(ca *CertificateAuthority, certPEM []byte, dnsnames []string, now time.Time) bool {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false
+	}
+	if err := cert.CheckSignatureFrom(ca.Cert); err != nil {
+		return false
+	}
+	if now.Add(NodeCertRenewBefore).After(cert.NotAfter) {
+		return false
+	}
+	have := append([]string(nil), cert.DNSNames...)
+	want := append([]string(nil), dnsnames...)
+	sort.Strings(have)
+	sort.Strings(want)
+	return reflect.DeepEqual(have, want)
+}
+
+// TrustBundle 返回需要信任的ca证书，包含当前ca和尚未过期的旧ca
+func (ca *CertificateAuthority) TrustBundle(now time.Time) []byte {
+	var bundle bytes.Buffer
+	bundle.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Cert.Raw}))
+	for _, previous := range ca.Previous {
+		if now.Before(previous.NotAfter) {
+			bundle.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: previous.Raw}))
+		}
+	}
+	return bundle.Bytes()
+}
+
+// EncodeCASecretData 将ca编码为secret的数据
+func (ca *CertificateAuthority) EncodeCASecretData(now time.Time) map[string][]byte {
+	data := map[string][]byte{
+		"ca.crt": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Cert.Raw}),
+		"ca.key": pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(ca.Key)}),
+	}
+	var previous bytes.Buffer
+	for _, cert := range ca.Previous {
+		if now.Before(cert.NotAfter) {
+			previous.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+		}
+	}
+	if previous.Len() > 0 {
+		data["previous.crt"] = previous.Bytes()
+	}
+	return data
+}
+
+// DecodeCASecretData 从secret的数据中解析ca
+func DecodeCASecretData(data map[string][]byte) (*CertificateAuthority, error) {
+	certs, err := parseCertificates(data["ca.crt"])
+	if err != nil || len(certs) == 0 {
+		return nil, fmt.Errorf("invalid ca.crt: %v", err)
+	}
+	block, _ := pem.Decode(data["ca.key"])
+	if block == nil {
+		return nil, fmt.Errorf("invalid ca.key")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := parseCertificates(data["previous.crt"])
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateAuthority{Cert: certs[0], Key: key, Previous: previous}, nil
+}
+
+// EnsureCA 读取或生成ca，临近过期时生成新的ca，旧的ca在过期前继续被信任
+func (r *LogFileReconciler) EnsureCA(ctx context.Context, namespace, elasticsearch string, now time.Time) (*CertificateAuthority, bool, error) {
+	customizelog := logger.WithValues("func", "EnsureCA")
+
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: CASecretName(elasticsearch)}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, false, err
+	}
+	if err == nil {
+		ca, err := DecodeCASecretData(secret.Data)
+		if err == nil && now.Add(CARenewBefore).Before(ca.Cert.NotAfter) {
+			return ca, false, nil
+		}
+		if err != nil {
+			customizelog.Error(err, "regenerate invalid ca", "name", secret.Name)
+		}
+		newca, generr := GenerateCA(elasticsearch+"-ca", now)
+		if generr != nil {
+			return nil, false, generr
+		}
+		if ca != nil {
+			newca.Previous = append([]*x509.Certificate{ca.Cert}, ca.Previous...)
+		}
+		customizelog.Info("rotate ca", "name", secret.Name)
+		return newca, true, nil
+	}
+	ca, err := GenerateCA(elasticsearch+"-ca", now)
+	if err != nil {
+		return nil, false, err
+	}
+	return ca, true, nil
+}
+
+// SecretHash 计算secret中指定键的哈希值，secret尚未创建时按空内容计算，创建后会再触发一次滚动更新
+func (r *LogFileReconciler) SecretHash(ctx context.Context, namespace, name string, keys ...string) (string, error) {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret); err != nil && !errors.IsNotFound(err) {
+		return "", err
+	}
+	hash := sha256.New()
+	for _, key := range keys {
+		fmt.Fprintf(hash, "%s=%s\n", key, secret.Data[key])
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
+
+// DeleteCertificate 删除cert-manager的Certificate，未安装cert-manager时忽略
+func (r *LogFileReconciler) DeleteCertificate(ctx context.Context, namespace, name string) error {
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(CertificateGVK)
+	certificate.SetName(name)
+	certificate.SetNamespace(namespace)
+	err := r.Delete(ctx, certificate)
+	if err == nil || errors.IsNotFound(err) || apimeta.IsNoMatchError(err) {
+		return nil
+	}
+	return err
+}
+
+func toInterfaceSlice(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
+
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs, nil
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+}
+
+func randSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
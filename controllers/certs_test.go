@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGenerateNodeCert(t *testing.T) {
+	now := time.Now()
+	ca, err := GenerateCA("elasticsearch-master-ca", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ca.Cert.IsCA {
+		t.Errorf("ca certificate is not a CA")
+	}
+	dnsnames := ElasticsearchDNSNames("elasticsearch-master", "logging")
+	certPEM, _, err := GenerateNodeCert(ca, "elasticsearch-master", dnsnames, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, host := range []string{
+		"elasticsearch-master",
+		"elasticsearch-master.logging.svc",
+		"elasticsearch-master-headless.logging.svc.cluster.local",
+		"elasticsearch-master-0.elasticsearch-master-headless",
+		"elasticsearch-master-2.elasticsearch-master-headless.logging.svc",
+		"localhost",
+		"127.0.0.1",
+	} {
+		if err := cert.VerifyHostname(host); err != nil {
+			t.Errorf("node certificate does not cover %s: %v", host, err)
+		}
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: certPool(ca.Cert), CurrentTime: now}); err != nil {
+		t.Errorf("node certificate is not signed by the ca: %v", err)
+	}
+}
+
+func TestNodeCertValid(t *testing.T) {
+	now := time.Now()
+	ca, err := GenerateCA("elasticsearch-master-ca", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherca, err := GenerateCA("elasticsearch-master-ca", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dnsnames := ElasticsearchDNSNames("elasticsearch-master", "logging")
+	certPEM, _, err := GenerateNodeCert(ca, "elasticsearch-master", dnsnames, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		ca       *CertificateAuthority
+		dnsnames []string
+		now      time.Time
+		valid    bool
+	}{
+		{name: "current", ca: ca, dnsnames: dnsnames, now: now, valid: true},
+		{name: "namespace changed", ca: ca, dnsnames: ElasticsearchDNSNames("elasticsearch-master", "logging-staging"), now: now},
+		{name: "ca rotated", ca: otherca, dnsnames: dnsnames, now: now},
+		{name: "renew before expiry", ca: ca, dnsnames: dnsnames, now: now.Add(NodeCertValidity - NodeCertRenewBefore + time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if valid := NodeCertValid(tt.ca, certPEM, tt.dnsnames, tt.now); valid != tt.valid {
+				t.Errorf("NodeCertValid() = %v, want %v", valid, tt.valid)
+			}
+		})
+	}
+}
+
+func TestEnsureCA(t *testing.T) {
+	now := time.Now()
+	current, err := GenerateCA("elasticsearch-master-ca", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		secret    map[string][]byte
+		now       time.Time
+		generated bool
+		previous  bool
+	}{
+		{name: "not created", now: now, generated: true},
+		{name: "valid", secret: current.EncodeCASecretData(now), now: now},
+		{name: "invalid", secret: map[string][]byte{"ca.crt": []byte("invalid")}, now: now, generated: true},
+		{name: "rotated before expiry", secret: current.EncodeCASecretData(now), now: now.Add(CAValidity - CARenewBefore + time.Hour), generated: true, previous: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReconciler(t)
+			if tt.secret != nil {
+				secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "logging", Name: CASecretName("elasticsearch-master")}, Data: tt.secret}
+				if err := r.Create(context.Background(), secret); err != nil {
+					t.Fatal(err)
+				}
+			}
+			ca, generated, err := r.EnsureCA(context.Background(), "logging", "elasticsearch-master", tt.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if generated != tt.generated {
+				t.Errorf("generated = %v, want %v", generated, tt.generated)
+			}
+			if !generated && !ca.Cert.Equal(current.Cert) {
+				t.Errorf("valid ca was replaced")
+			}
+			// 轮换后旧ca在过期前继续被信任
+			trusted := bytes.Contains(ca.TrustBundle(tt.now), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: current.Cert.Raw}))
+			if tt.previous && (len(ca.Previous) != 1 || !trusted) {
+				t.Errorf("previous ca is not trusted after rotation")
+			}
+			decoded, err := DecodeCASecretData(ca.EncodeCASecretData(tt.now))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !decoded.Cert.Equal(ca.Cert) || len(decoded.Previous) != len(ca.Previous) {
+				t.Errorf("ca secret data does not round trip")
+			}
+		})
+	}
+}
+
+func certPool(certs ...*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool
+}
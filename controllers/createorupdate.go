@@ -0,0 +1,296 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// ConfigHashAnnotation pod模板上记录所引用配置文件的哈希值，配置变更时触发滚动更新
+const ConfigHashAnnotation = "logfile.huisebug.org/config-hash"
+
+// ConfigHash 计算configmap内容的哈希值
+func ConfigHash(configmaps ...*corev1.ConfigMap) string {
+	hash := sha256.New()
+	for _, configmap := range configmaps {
+		keys := make([]string, 0, len(configmap.Data))
+		for k := range configmap.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(hash, "%s/%s=%s\n", configmap.Name, k, configmap.Data[k])
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
+
+// ConfigMapHash 读取集群中的configmap并计算哈希值，需要在configmap调谐之后调用
+func (r *LogFileReconciler) ConfigMapHash(ctx context.Context, namespace string, names ...string) (string, error) {
+	configmaps := make([]*corev1.ConfigMap, 0, len(names))
+	for _, name := range names {
+		configmap := &corev1.ConfigMap{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, configmap); err != nil {
+			return "", err
+		}
+		configmaps = append(configmaps, configmap)
+	}
+	return ConfigHash(configmaps...), nil
+}
+
+// CreteOrUpdate 将desired收敛到集群中: 不存在则创建, 已存在则只修补发生漂移的可变字段
+// 这样Run中途失败后重新入队也能继续把整套服务创建完成, 而不会一直返回AlreadyExists
+func (r *LogFileReconciler) CreteOrUpdate(ctx context.Context, logfile *apiv1.LogFile, desired client.Object) (controllerutil.OperationResult, error) {
+	customizelog := logger.WithValues("func", "CreteOrUpdate")
+
+	// statefulset扩容存储时需要先重建
+	if statefulset, ok := desired.(*appsv1.StatefulSet); ok {
+		recreating, err := r.ExpandStatefulSetStorage(ctx, statefulset)
+		if err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+		if recreating {
+			return controllerutil.OperationResultUpdated, nil
+		}
+	}
+
+	// 记录期望spec的哈希值，spec变化时整体覆盖operator管理的字段
+	if err := setSpecHash(desired); err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+
+	// 新建同类型的空对象用于接收集群中已存在的对象
+	existing, ok := reflect.New(reflect.TypeOf(desired).Elem()).Interface().(client.Object)
+	if !ok {
+		return controllerutil.OperationResultNone, fmt.Errorf("unsupported object type %T", desired)
+	}
+	existing.SetName(desired.GetName())
+	existing.SetNamespace(desired.GetNamespace())
+	// unstructured对象需要指定类型才能查询
+	if u, ok := desired.(*unstructured.Unstructured); ok {
+		existing.(*unstructured.Unstructured).SetGroupVersionKind(u.GroupVersionKind())
+	}
+
+	result, err := controllerutil.CreateOrPatch(ctx, r.Client, existing, func() error {
+		// 不存在时直接使用期望的完整对象进行创建
+		if existing.GetResourceVersion() == "" {
+			reflect.ValueOf(existing).Elem().Set(reflect.ValueOf(desired).Elem())
+		} else {
+			changed := existing.GetAnnotations()[SpecHashAnnotation] != desired.GetAnnotations()[SpecHashAnnotation]
+			mergeObjectMeta(existing, desired)
+			if err := mergeObjectSpec(existing, desired, changed); err != nil {
+				return err
+			}
+		}
+		return SetOwnerReference(logfile, existing, r.Scheme)
+	})
+	if err != nil {
+		return result, err
+	}
+	if result != controllerutil.OperationResultNone {
+		kind := reflect.TypeOf(desired).Elem().Name()
+		if u, ok := desired.(*unstructured.Unstructured); ok {
+			kind = u.GetKind()
+		}
+		customizelog.Info("converge object", "kind", kind, "name", desired.GetName(), "operation", result)
+		r.RecordConverge(logfile, kind, desired.GetName(), result)
+	}
+	// 将集群中的最新状态写回desired，方便调用方读取
+	reflect.ValueOf(desired).Elem().Set(reflect.ValueOf(existing).Elem())
+	return result, nil
+}
+
+// RecordConverge 将收敛操作记录为logfile的event
+// 当前spec版本已经全部就绪过，再次创建或修补对象说明对象被删除或被手动修改过
+// 首次部署或spec变更后等待组件就绪期间陆续创建的对象不算修复
+func (r *LogFileReconciler) RecordConverge(logfile *apiv1.LogFile, kind, name string, result controllerutil.OperationResult) {
+	provisioned := logfile.Status.Phase == apiv1.PhaseReady || logfile.Status.Phase == apiv1.PhaseDegraded
+	repaired := provisioned && logfile.Status.ObservedGeneration == logfile.Generation
+	switch {
+	case result == controllerutil.OperationResultCreated && repaired:
+		r.Recorder.Eventf(logfile, corev1.EventTypeWarning, "Recreated", "%s %s was missing and has been recreated", kind, name)
+	case result == controllerutil.OperationResultCreated:
+		r.Recorder.Eventf(logfile, corev1.EventTypeNormal, "Created", "Created %s %s", kind, name)
+	case repaired:
+		r.Recorder.Eventf(logfile, corev1.EventTypeWarning, "DriftRepaired", "%s %s was modified outside of the operator and has been repaired", kind, name)
+	default:
+		r.Recorder.Eventf(logfile, corev1.EventTypeNormal, "Updated", "Updated %s %s", kind, name)
+	}
+}
+
+// mergeObjectMeta 合并期望的labels和annotations，不删除其他组件写入的键
+func mergeObjectMeta(existing, desired client.Object) {
+	labels := existing.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for k, v := range desired.GetLabels() {
+		labels[k] = v
+	}
+	existing.SetLabels(labels)
+
+	if len(desired.GetAnnotations()) == 0 {
+		return
+	}
+	annotations := existing.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for k, v := range desired.GetAnnotations() {
+		annotations[k] = v
+	}
+	existing.SetAnnotations(annotations)
+}
+
+// SpecHashAnnotation 对象上记录operator上次写入的期望spec的哈希值
+// 这些类型的spec有apiserver补全的默认值，无法与desired逐字段比较，spec删除的字段通过哈希值变化发现
+const SpecHashAnnotation = "logfile.huisebug.org/spec-hash"
+
+// setSpecHash 在statefulset、deployment、service、pdb的desired上记录spec的哈希值
+func setSpecHash(desired client.Object) error {
+	var spec interface{}
+	switch d := desired.(type) {
+	case *appsv1.StatefulSet:
+		spec = d.Spec
+	case *appsv1.Deployment:
+		spec = d.Spec
+	case *corev1.Service:
+		spec = d.Spec
+	case *policyv1.PodDisruptionBudget:
+		spec = d.Spec
+	default:
+		return nil
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(data)
+	annotations := desired.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[SpecHashAnnotation] = hex.EncodeToString(hash[:])[:16]
+	desired.SetAnnotations(annotations)
+	return nil
+}
+
+// RestartedAtAnnotation kubectl rollout restart写入pod模板的注解，保留该注解避免重启后再触发一次滚动更新
+const RestartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// desiredTemplate 返回期望的pod模板，保留现有模板上kubectl rollout restart的注解
+func desiredTemplate(existing, desired corev1.PodTemplateSpec) corev1.PodTemplateSpec {
+	template := *desired.DeepCopy()
+	if restartedAt, ok := existing.Annotations[RestartedAtAnnotation]; ok {
+		if template.Annotations == nil {
+			template.Annotations = map[string]string{}
+		}
+		template.Annotations[RestartedAtAnnotation] = restartedAt
+	}
+	return template
+}
+
+// mergeObjectSpec 只覆盖各类型由operator管理的字段，并且只在desired与现有对象不一致时覆盖
+// changed为true时期望的spec与上次写入时不同，直接覆盖；否则用DeepDerivative只比较desired中设置的字段，
+// desired未设置的字段由apiserver补全默认值，不算漂移，被手动修改的字段会被还原
+func mergeObjectSpec(existing, desired client.Object, changed bool) error {
+	switch e := existing.(type) {
+	case *corev1.ConfigMap:
+		d := desired.(*corev1.ConfigMap)
+		if !reflect.DeepEqual(e.Data, d.Data) {
+			e.Data = d.Data
+		}
+		if !reflect.DeepEqual(e.BinaryData, d.BinaryData) {
+			e.BinaryData = d.BinaryData
+		}
+	case *corev1.Secret:
+		d := desired.(*corev1.Secret)
+		if !reflect.DeepEqual(e.Data, d.Data) {
+			e.Data = d.Data
+		}
+	case *corev1.ServiceAccount:
+		d := desired.(*corev1.ServiceAccount)
+		if !equality.Semantic.DeepEqual(d.AutomountServiceAccountToken, e.AutomountServiceAccountToken) {
+			e.AutomountServiceAccountToken = d.AutomountServiceAccountToken
+		}
+	case *corev1.Service:
+		d := desired.(*corev1.Service)
+		// clusterIP不可变更，由apiserver分配的nodePort在未显式指定时保持不变
+		ports := make([]corev1.ServicePort, len(d.Spec.Ports))
+		for i, port := range d.Spec.Ports {
+			ports[i] = port
+			if port.NodePort != 0 {
+				continue
+			}
+			for _, current := range e.Spec.Ports {
+				if current.Name == port.Name {
+					ports[i].NodePort = current.NodePort
+				}
+			}
+		}
+		if changed || len(ports) != len(e.Spec.Ports) || !equality.Semantic.DeepDerivative(ports, e.Spec.Ports) {
+			e.Spec.Ports = ports
+		}
+		if d.Spec.Type != "" && d.Spec.Type != e.Spec.Type {
+			e.Spec.Type = d.Spec.Type
+		}
+		if !reflect.DeepEqual(d.Spec.Selector, e.Spec.Selector) {
+			e.Spec.Selector = d.Spec.Selector
+		}
+		if d.Spec.PublishNotReadyAddresses != e.Spec.PublishNotReadyAddresses {
+			e.Spec.PublishNotReadyAddresses = d.Spec.PublishNotReadyAddresses
+		}
+	case *appsv1.StatefulSet:
+		// selector、serviceName、volumeClaimTemplates、podManagementPolicy创建后不可变更
+		d := desired.(*appsv1.StatefulSet)
+		if !equality.Semantic.DeepEqual(d.Spec.Replicas, e.Spec.Replicas) {
+			e.Spec.Replicas = d.Spec.Replicas
+		}
+		if changed || !equality.Semantic.DeepDerivative(d.Spec.UpdateStrategy, e.Spec.UpdateStrategy) {
+			e.Spec.UpdateStrategy = d.Spec.UpdateStrategy
+		}
+		if template := desiredTemplate(e.Spec.Template, d.Spec.Template); changed || !equality.Semantic.DeepDerivative(template, e.Spec.Template) {
+			e.Spec.Template = template
+		}
+	case *appsv1.Deployment:
+		// selector创建后不可变更
+		d := desired.(*appsv1.Deployment)
+		if !equality.Semantic.DeepEqual(d.Spec.Replicas, e.Spec.Replicas) {
+			e.Spec.Replicas = d.Spec.Replicas
+		}
+		if template := desiredTemplate(e.Spec.Template, d.Spec.Template); changed || !equality.Semantic.DeepDerivative(template, e.Spec.Template) {
+			e.Spec.Template = template
+		}
+	case *policyv1.PodDisruptionBudget:
+		d := desired.(*policyv1.PodDisruptionBudget)
+		if changed || !equality.Semantic.DeepDerivative(d.Spec, e.Spec) {
+			e.Spec = d.Spec
+		}
+	case *unstructured.Unstructured:
+		// cert-manager的Certificate等外部资源只同步spec
+		d := desired.(*unstructured.Unstructured)
+		if !reflect.DeepEqual(d.Object["spec"], e.Object["spec"]) {
+			e.Object["spec"] = d.Object["spec"]
+		}
+	case *batchv1.Job:
+		// job的模板创建后不可变更，已存在时只同步元数据
+	default:
+		return fmt.Errorf("unsupported object type %T", existing)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func newTestReconciler(t *testing.T, objects ...runtime.Object) *LogFileReconciler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := apiv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return &LogFileReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objects...).Build(),
+		Scheme:   scheme,
+		Recorder: record.NewFakeRecorder(100),
+	}
+}
+
+func testDeployment(mutate func(*corev1.PodTemplateSpec)) *appsv1.Deployment {
+	replicas := int32(1)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "kibana", Namespace: "logging", Labels: map[string]string{"app": "kibana"}},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "kibana"}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "kibana"}},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "kibana", Image: "kibana:8.5.0"}},
+				},
+			},
+		},
+	}
+	if mutate != nil {
+		mutate(&deployment.Spec.Template)
+	}
+	return deployment
+}
+
+func TestCreteOrUpdateRepairsDrift(t *testing.T) {
+	toleration := corev1.Toleration{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "logging", Effect: corev1.TaintEffectNoSchedule}
+	withToleration := func(t *corev1.PodTemplateSpec) { t.Spec.Tolerations = []corev1.Toleration{toleration} }
+	tests := []struct {
+		name string
+		// 上次调谐时operator写入的模板
+		previous func(*corev1.PodTemplateSpec)
+		// 调谐之间手动修改集群中的模板
+		edit      func(*corev1.PodTemplateSpec)
+		desired   func(*corev1.PodTemplateSpec)
+		operation controllerutil.OperationResult
+		expected  func(*corev1.PodTemplateSpec)
+	}{
+		{
+			name:      "toleration removed from spec",
+			previous:  withToleration,
+			operation: controllerutil.OperationResultUpdated,
+		},
+		{
+			name: "env removed from spec",
+			previous: func(t *corev1.PodTemplateSpec) {
+				t.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "A", Value: "1"}, {Name: "B", Value: "2"}}
+			},
+			desired: func(t *corev1.PodTemplateSpec) {
+				t.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "A", Value: "1"}}
+			},
+			operation: controllerutil.OperationResultUpdated,
+			expected: func(t *corev1.PodTemplateSpec) {
+				t.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "A", Value: "1"}}
+			},
+		},
+		{
+			name:      "toleration removed by hand",
+			previous:  withToleration,
+			edit:      func(t *corev1.PodTemplateSpec) { t.Spec.Tolerations = nil },
+			desired:   withToleration,
+			operation: controllerutil.OperationResultUpdated,
+			expected:  withToleration,
+		},
+		{
+			name:      "image changed by hand",
+			edit:      func(t *corev1.PodTemplateSpec) { t.Spec.Containers[0].Image = "kibana:8.4.0" },
+			operation: controllerutil.OperationResultUpdated,
+		},
+		{
+			// 只比较operator设置的字段
+			name:      "nodeSelector added by hand",
+			edit:      func(t *corev1.PodTemplateSpec) { t.Spec.NodeSelector = map[string]string{"disk": "ssd"} },
+			operation: controllerutil.OperationResultNone,
+			expected:  func(t *corev1.PodTemplateSpec) { t.Spec.NodeSelector = map[string]string{"disk": "ssd"} },
+		},
+		{
+			name:      "template annotation removed from spec",
+			previous:  func(t *corev1.PodTemplateSpec) { t.Annotations = map[string]string{ConfigHashAnnotation: "old"} },
+			operation: controllerutil.OperationResultUpdated,
+		},
+		{
+			name:      "unchanged",
+			previous:  withToleration,
+			desired:   withToleration,
+			operation: controllerutil.OperationResultNone,
+			expected:  withToleration,
+		},
+		{
+			name:      "rollout restart annotation kept",
+			edit:      func(t *corev1.PodTemplateSpec) { t.Annotations = map[string]string{RestartedAtAnnotation: "now"} },
+			operation: controllerutil.OperationResultNone,
+			expected:  func(t *corev1.PodTemplateSpec) { t.Annotations = map[string]string{RestartedAtAnnotation: "now"} },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			logfile := &apiv1.LogFile{ObjectMeta: metav1.ObjectMeta{Name: "logfile", Namespace: "logging", UID: "uid"}}
+			r := newTestReconciler(t, logfile)
+			if _, err := r.CreteOrUpdate(ctx, logfile, testDeployment(tt.previous)); err != nil {
+				t.Fatal(err)
+			}
+			key := types.NamespacedName{Namespace: "logging", Name: "kibana"}
+			if tt.edit != nil {
+				edited := &appsv1.Deployment{}
+				if err := r.Get(ctx, key, edited); err != nil {
+					t.Fatal(err)
+				}
+				tt.edit(&edited.Spec.Template)
+				if err := r.Update(ctx, edited); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			operation, err := r.CreteOrUpdate(ctx, logfile, testDeployment(tt.desired))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if operation != tt.operation {
+				t.Errorf("operation = %s, want %s", operation, tt.operation)
+			}
+			current := &appsv1.Deployment{}
+			if err := r.Get(ctx, key, current); err != nil {
+				t.Fatal(err)
+			}
+			expected := testDeployment(tt.expected)
+			if !equality.Semantic.DeepEqual(current.Spec.Template, expected.Spec.Template) {
+				t.Errorf("template = %+v, want %+v", current.Spec.Template, expected.Spec.Template)
+			}
+		})
+	}
+}
+
+func TestRecordConverge(t *testing.T) {
+	tests := []struct {
+		name               string
+		phase              string
+		observedGeneration int64
+		result             controllerutil.OperationResult
+		event              string
+	}{
+		{name: "first install", phase: apiv1.PhaseProvisioning, observedGeneration: 2, result: controllerutil.OperationResultCreated, event: "Normal Created"},
+		{name: "spec changed", phase: apiv1.PhaseReady, observedGeneration: 1, result: controllerutil.OperationResultUpdated, event: "Normal Updated"},
+		{name: "deleted after ready", phase: apiv1.PhaseReady, observedGeneration: 2, result: controllerutil.OperationResultCreated, event: "Warning Recreated"},
+		{name: "edited while degraded", phase: apiv1.PhaseDegraded, observedGeneration: 2, result: controllerutil.OperationResultUpdated, event: "Warning DriftRepaired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(1)
+			r := &LogFileReconciler{Recorder: recorder}
+			logfile := &apiv1.LogFile{ObjectMeta: metav1.ObjectMeta{Generation: 2}}
+			logfile.Status.Phase = tt.phase
+			logfile.Status.ObservedGeneration = tt.observedGeneration
+			r.RecordConverge(logfile, "Deployment", "kibana", tt.result)
+			if event := <-recorder.Events; !strings.HasPrefix(event, tt.event+" ") {
+				t.Errorf("event = %q, want %s", event, tt.event)
+			}
+		})
+	}
+}
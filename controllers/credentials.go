@@ -0,0 +1,308 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net/http"
+	"reflect"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CredentialsSecretName 保存期望密码的secret，各组件通过secretKeyRef引用，不再把密码写入配置文件
+const CredentialsSecretName = "logfile-credentials"
+
+// secret中的键
+const (
+	ElasticPasswordKey = "ELASTIC_PASSWORD"
+	KibanaPasswordKey  = "KIBANA_PASSWORD"
+	WriterPasswordKey  = "WRITER_PASSWORD"
+)
+
+// WriterUsername filebeat和logstash写入日志使用的用户，只有logfile-operator-*索引的写入权限
+const WriterUsername = "logfile_writer"
+
+// Credentials elastic、kibana_system和日志写入用户的密码
+type Credentials struct {
+	ElasticPassword string
+	KibanaPassword  string
+	WriterPassword  string
+}
+
+// AppliedCredentialsSecretName 记录已经在elasticsearch中生效的密码的secret名称
+// elasticsearch只在首次初始化数据目录时读取ELASTIC_PASSWORD，之后修改密码需要调用接口轮换
+func AppliedCredentialsSecretName(elasticsearch string) string {
+	return elasticsearch + "-applied-credentials"
+}
+
+// GeneratePassword 生成随机密码
+func GeneratePassword(length int) (string, error) {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	password := make([]byte, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
+		if err != nil {
+			return "", err
+		}
+		password[i] = letters[n.Int64()]
+	}
+	return string(password), nil
+}
+
+// ResolveCredentials 解析期望的密码并写入logfile-credentials
+// 优先使用spec中引用的secret，其次兼容已废弃的明文密码，都未设置时沿用已生成的密码或生成随机密码
+func (r *LogFileReconciler) ResolveCredentials(ctx context.Context, logfile *apiv1.LogFile, meta metav1.ObjectMeta) (*Credentials, error) {
+	existing := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: meta.Namespace, Name: meta.Name}, existing); err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	resolve := func(ref *corev1.SecretKeySelector, plaintext, key string) (string, error) {
+		if ref != nil {
+			secret := &corev1.Secret{}
+			if err := r.Get(ctx, types.NamespacedName{Namespace: logfile.Namespace, Name: ref.Name}, secret); err != nil {
+				return "", fmt.Errorf("get password secret %s/%s: %w", logfile.Namespace, ref.Name, err)
+			}
+			password, ok := secret.Data[ref.Key]
+			if !ok || len(password) == 0 {
+				return "", fmt.Errorf("password secret %s/%s has no key %s", logfile.Namespace, ref.Name, ref.Key)
+			}
+			return string(password), nil
+		}
+		if plaintext != "" {
+			return plaintext, nil
+		}
+		if password := existing.Data[key]; len(password) > 0 {
+			return string(password), nil
+		}
+		return GeneratePassword(24)
+	}
+
+	var err error
+	credentials := &Credentials{}
+	if credentials.ElasticPassword, err = resolve(logfile.Spec.ElasticPasswordSecretRef, logfile.Spec.ELASTIC_PASSWORD, ElasticPasswordKey); err != nil {
+		return nil, err
+	}
+	if credentials.KibanaPassword, err = resolve(logfile.Spec.KibanaPasswordSecretRef, logfile.Spec.KIBANA_PASSWORD, KibanaPasswordKey); err != nil {
+		return nil, err
+	}
+	if credentials.WriterPassword, err = resolve(nil, "", WriterPasswordKey); err != nil {
+		return nil, err
+	}
+
+	if err := r.SaveCredentials(ctx, logfile, meta, credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// GetAppliedCredentials 读取已生效的密码
+// 首次部署时elasticsearch会使用期望的elastic密码初始化，kibana_system和日志写入用户的密码尚未设置
+func (r *LogFileReconciler) GetAppliedCredentials(ctx context.Context, logfile *apiv1.LogFile, meta metav1.ObjectMeta, desired *Credentials) (*Credentials, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: meta.Namespace, Name: AppliedCredentialsSecretName(meta.Name)}, secret)
+	if errors.IsNotFound(err) {
+		applied := &Credentials{ElasticPassword: desired.ElasticPassword}
+		// elasticsearch的statefulset引用该secret，需要在创建statefulset之前记录
+		if err := r.SaveAppliedCredentials(ctx, logfile, meta, applied); err != nil {
+			return nil, err
+		}
+		return applied, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Credentials{
+		ElasticPassword: string(secret.Data[ElasticPasswordKey]),
+		KibanaPassword:  string(secret.Data[KibanaPasswordKey]),
+		WriterPassword:  string(secret.Data[WriterPasswordKey]),
+	}, nil
+}
+
+// SyncElasticsearchPasswords 将期望的密码通过elasticsearch接口设置生效，并记录到secret中
+// 每设置一个密码立即记录一次，避免中途失败后使用错误的密码访问elasticsearch
+func (r *LogFileReconciler) SyncElasticsearchPasswords(ctx context.Context, logfile *apiv1.LogFile, meta metav1.ObjectMeta, caSecret string, applied, desired *Credentials) error {
+	customizelog := logger.WithValues("func", "SyncElasticsearchPasswords")
+	elasticsearch := types.NamespacedName{Namespace: meta.Namespace, Name: meta.Name}
+
+	if applied.ElasticPassword != desired.ElasticPassword {
+		esclient, err := r.NewElasticsearchClient(ctx, elasticsearch, caSecret, "elastic", applied.ElasticPassword)
+		if err != nil {
+			return err
+		}
+		body := map[string]string{"password": desired.ElasticPassword}
+		if err := esclient.Do(ctx, http.MethodPost, "/_security/user/elastic/_password", body, nil); err != nil {
+			return err
+		}
+		applied.ElasticPassword = desired.ElasticPassword
+		if err := r.SaveAppliedCredentials(ctx, logfile, meta, applied); err != nil {
+			return err
+		}
+		customizelog.Info("rotate elastic password", "name", elasticsearch.String())
+	}
+
+	esclient, err := r.NewElasticsearchClient(ctx, elasticsearch, caSecret, "elastic", applied.ElasticPassword)
+	if err != nil {
+		return err
+	}
+
+	if applied.KibanaPassword != desired.KibanaPassword {
+		body := map[string]string{"password": desired.KibanaPassword}
+		if err := esclient.Do(ctx, http.MethodPost, "/_security/user/kibana_system/_password", body, nil); err != nil {
+			return err
+		}
+		applied.KibanaPassword = desired.KibanaPassword
+		if err := r.SaveAppliedCredentials(ctx, logfile, meta, applied); err != nil {
+			return err
+		}
+		customizelog.Info("set kibana_system password", "name", elasticsearch.String())
+	}
+
+	if applied.WriterPassword != desired.WriterPassword {
+		role := map[string]interface{}{
+			"cluster": []string{"monitor", "manage_index_templates", "manage_ilm"},
+			"indices": []map[string]interface{}{
+				{
+					"names":      []string{"logfile-operator-*"},
+					"privileges": []string{"create_doc", "create_index", "view_index_metadata", "auto_configure"},
+				},
+			},
+		}
+		if err := esclient.Do(ctx, http.MethodPut, "/_security/role/"+WriterUsername, role, nil); err != nil {
+			return err
+		}
+		user := map[string]interface{}{
+			"password": desired.WriterPassword,
+			"roles":    []string{WriterUsername},
+		}
+		if err := esclient.Do(ctx, http.MethodPut, "/_security/user/"+WriterUsername, user, nil); err != nil {
+			return err
+		}
+		applied.WriterPassword = desired.WriterPassword
+		if err := r.SaveAppliedCredentials(ctx, logfile, meta, applied); err != nil {
+			return err
+		}
+		customizelog.Info("set "+WriterUsername+" password", "name", elasticsearch.String())
+	}
+	return nil
+}
+
+// SaveCredentials 记录期望的密码
+func (r *LogFileReconciler) SaveCredentials(ctx context.Context, logfile *apiv1.LogFile, meta metav1.ObjectMeta, credentials *Credentials) error {
+	secret := &corev1.Secret{
+		ObjectMeta: meta,
+		Type:       corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			ElasticPasswordKey: []byte(credentials.ElasticPassword),
+			KibanaPasswordKey:  []byte(credentials.KibanaPassword),
+			WriterPasswordKey:  []byte(credentials.WriterPassword),
+		},
+	}
+	_, err := r.CreteOrUpdate(ctx, logfile, secret)
+	return err
+}
+
+// SaveAppliedCredentials 记录已生效的密码
+func (r *LogFileReconciler) SaveAppliedCredentials(ctx context.Context, logfile *apiv1.LogFile, meta metav1.ObjectMeta, applied *Credentials) error {
+	secretmeta := meta.DeepCopy()
+	secretmeta.Name = AppliedCredentialsSecretName(meta.Name)
+	return r.SaveCredentials(ctx, logfile, *secretmeta, applied)
+}
+
+// CredentialsVersionAnnotation pod模板上记录所引用密码secret的版本，密码变更时触发滚动更新
+const CredentialsVersionAnnotation = "logfile.huisebug.org/credentials-version"
+
+// SecretVersion 返回secret的resourceVersion，不在pod模板中记录密码本身
+func (r *LogFileReconciler) SecretVersion(ctx context.Context, namespace, name string) (string, error) {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, secret); err != nil {
+		return "", err
+	}
+	return secret.ResourceVersion, nil
+}
+
+// SecretKeyRef 引用secret中的键
+func SecretKeyRef(name, key string) *corev1.EnvVarSource {
+	return &corev1.EnvVarSource{
+		SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: name},
+			Key:                  key,
+		},
+	}
+}
+
+// MirrorSourceLabel 复制到业务namespace的secret上记录源secret的名称
+const MirrorSourceLabel = "logfile.huisebug.org/mirror-of"
+
+// MirrorLabels 返回复制到业务namespace的secret的标签，owner为组件configmap的标签，记录所属的LogFile
+func MirrorLabels(owner map[string]string, source string) map[string]string {
+	labels := map[string]string{
+		"app.kubernetes.io/managed-by": "logfile-operator",
+		MirrorSourceLabel:              source,
+	}
+	for _, key := range []string{OwnerNameLabel, OwnerNamespaceLabel} {
+		if value, ok := owner[key]; ok {
+			labels[key] = value
+		}
+	}
+	return labels
+}
+
+// MirrorData 返回复制的secret内容，密码secret只复制日志写入用户的密码
+func MirrorData(source *corev1.Secret) map[string][]byte {
+	if source.Name == CredentialsSecretName {
+		return map[string][]byte{WriterPasswordKey: source.Data[WriterPasswordKey]}
+	}
+	return source.Data
+}
+
+// ListMirroredSecrets 返回复制到业务namespace的属于该LogFile的secret
+func (r *LogFileReconciler) ListMirroredSecrets(ctx context.Context, logfile *apiv1.LogFile) ([]corev1.Secret, error) {
+	secrets := &corev1.SecretList{}
+	if err := r.List(ctx, secrets, client.HasLabels{MirrorSourceLabel}, client.MatchingLabels{
+		OwnerNameLabel:      logfile.Name,
+		OwnerNamespaceLabel: logfile.Namespace,
+	}); err != nil {
+		return nil, err
+	}
+	return secrets.Items, nil
+}
+
+// SyncMirroredSecrets 将组件namespace中secret的变更同步到业务namespace中的副本
+// 密码轮换后新启动的sidecar使用新的密码，已运行的pod需要重建后才会生效
+func (r *LogFileReconciler) SyncMirroredSecrets(ctx context.Context, logfile *apiv1.LogFile) error {
+	customizelog := logger.WithValues("func", "SyncMirroredSecrets")
+
+	mirrors, err := r.ListMirroredSecrets(ctx, logfile)
+	if err != nil {
+		return err
+	}
+	for i := range mirrors {
+		mirror := &mirrors[i]
+		source := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: StackNamespace(logfile), Name: mirror.Labels[MirrorSourceLabel]}, source); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		data := MirrorData(source)
+		if reflect.DeepEqual(mirror.Data, data) {
+			continue
+		}
+		patch := client.MergeFrom(mirror.DeepCopy())
+		mirror.Data = data
+		if err := r.Patch(ctx, mirror, patch); err != nil {
+			return err
+		}
+		customizelog.Info("update mirrored secret", "name", mirror.Namespace+"/"+mirror.Name)
+	}
+	return nil
+}
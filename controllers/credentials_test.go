@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSyncMirroredSecrets(t *testing.T) {
+	logfile := &apiv1.LogFile{ObjectMeta: metav1.ObjectMeta{Name: "logfile", Namespace: "logging"}}
+	owner := map[string]string{OwnerNameLabel: "logfile", OwnerNamespaceLabel: "logging"}
+	source := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: CredentialsSecretName, Namespace: "logging", Labels: owner},
+		Data: map[string][]byte{
+			ElasticPasswordKey: []byte("elastic"),
+			WriterPasswordKey:  []byte("rotated"),
+		},
+	}
+	mirror := func(namespace string, labels map[string]string) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: FilebeatCredentialsSecretName, Namespace: namespace, Labels: labels},
+			Data:       map[string][]byte{WriterPasswordKey: []byte("stale")},
+		}
+	}
+	other := map[string]string{OwnerNameLabel: "other", OwnerNamespaceLabel: "logging"}
+	r := newTestReconciler(t, source,
+		mirror("app", MirrorLabels(owner, CredentialsSecretName)),
+		mirror("other", MirrorLabels(other, CredentialsSecretName)),
+	)
+
+	if err := r.SyncMirroredSecrets(context.Background(), logfile); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		namespace string
+		password  string
+	}{
+		{namespace: "app", password: "rotated"},
+		// 属于其他LogFile的副本不修改
+		{namespace: "other", password: "stale"},
+	}
+	for _, tt := range tests {
+		secret := &corev1.Secret{}
+		if err := r.Get(context.Background(), types.NamespacedName{Namespace: tt.namespace, Name: FilebeatCredentialsSecretName}, secret); err != nil {
+			t.Fatal(err)
+		}
+		if string(secret.Data[WriterPasswordKey]) != tt.password {
+			t.Errorf("%s: password = %s, want %s", tt.namespace, secret.Data[WriterPasswordKey], tt.password)
+		}
+		if _, ok := secret.Data[ElasticPasswordKey]; ok {
+			t.Errorf("%s: elastic password must not be mirrored", tt.namespace)
+		}
+	}
+
+	if err := r.DeleteMirroredSecrets(context.Background(), logfile); err != nil {
+		t.Fatal(err)
+	}
+	mirrors, err := r.ListMirroredSecrets(context.Background(), logfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mirrors) != 0 {
+		t.Errorf("mirrored secrets left after teardown: %d", len(mirrors))
+	}
+}
+
+func TestSecretMapFunc(t *testing.T) {
+	logfile := &apiv1.LogFile{
+		ObjectMeta: metav1.ObjectMeta{Name: "logfile", Namespace: "logging"},
+		Spec: apiv1.LogFileSpec{
+			KibanaPasswordSecretRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "kibana-password"}, Key: "password"},
+		},
+	}
+	r := newTestReconciler(t, logfile)
+	tests := []struct {
+		name     string
+		secret   metav1.ObjectMeta
+		requests int
+	}{
+		{name: "referenced password", secret: metav1.ObjectMeta{Name: "kibana-password", Namespace: "logging"}, requests: 1},
+		{name: "unrelated secret", secret: metav1.ObjectMeta{Name: "tls", Namespace: "logging"}, requests: 0},
+		{name: "same name in other namespace", secret: metav1.ObjectMeta{Name: "kibana-password", Namespace: "app"}, requests: 0},
+		{name: "owned secret", secret: metav1.ObjectMeta{Name: "x", Namespace: "app", Labels: map[string]string{OwnerNameLabel: "logfile", OwnerNamespaceLabel: "logging"}}, requests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			object := &metav1.PartialObjectMetadata{ObjectMeta: tt.secret}
+			if requests := r.SecretMapFunc(object); len(requests) != tt.requests {
+				t.Errorf("requests = %v, want %d", requests, tt.requests)
+			}
+		})
+	}
+}
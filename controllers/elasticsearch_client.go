@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ElasticsearchClient 通过REST接口访问operator创建的elasticsearch
+type ElasticsearchClient struct {
+	url        string
+	username   string
+	password   string
+	httpClient *http.Client
+}
+
+// ElasticsearchClusterHealth 为_cluster/health接口返回的部分字段
+type ElasticsearchClusterHealth struct {
+	Status              string  `json:"status"`
+	NumberOfNodes       int     `json:"number_of_nodes"`
+	RelocatingShards    int     `json:"relocating_shards"`
+	InitializingShards  int     `json:"initializing_shards"`
+	UnassignedShards    int     `json:"unassigned_shards"`
+	ActiveShardsPercent float64 `json:"active_shards_percent_as_number"`
+}
+
+// NewElasticsearchClient 创建访问elasticsearch服务的客户端
+// 当caSecret不为空时使用https，并使用secret中的ca.crt校验服务端证书
+func (r *LogFileReconciler) NewElasticsearchClient(ctx context.Context, service types.NamespacedName, caSecret string, username, password string) (*ElasticsearchClient, error) {
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if caSecret != "" {
+		scheme = "https"
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: service.Namespace, Name: caSecret}, secret); err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(secret.Data["ca.crt"]) {
+			return nil, fmt.Errorf("secret %s/%s has no valid ca.crt", service.Namespace, caSecret)
+		}
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	return &ElasticsearchClient{
+		url:      fmt.Sprintf("%s://%s.%s.svc:9200", scheme, service.Name, service.Namespace),
+		username: username,
+		password: password,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   10 * time.Second,
+		},
+	}, nil
+}
+
+// Do 发送请求，body和out为nil时分别表示无请求体和忽略响应体
+func (c *ElasticsearchClient) Do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.url+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: http %d: %s", method, path, resp.StatusCode, string(data))
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// ClusterHealth 查询集群健康状态
+func (c *ElasticsearchClient) ClusterHealth(ctx context.Context) (*ElasticsearchClusterHealth, error) {
+	health := &ElasticsearchClusterHealth{}
+	if err := c.Do(ctx, http.MethodGet, "/_cluster/health", nil, health); err != nil {
+		return nil, err
+	}
+	return health, nil
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"strings"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultImageRegistry 默认镜像所在的仓库
+const DefaultImageRegistry = "registry.cn-hangzhou.aliyuncs.com/huisebug"
+
+// defaultImage 返回组件的默认镜像，都在DefaultImageRegistry中
+// elastic stack组件的tag为 组件名-版本号，例如 elasticsearch-8.5.0
+func defaultImage(component, version string) string {
+	switch component {
+	case apiv1.ComponentKafka:
+		return DefaultImageRegistry + "/logfile-operator:kafka-3.3"
+	case apiv1.ComponentZookeeper:
+		return DefaultImageRegistry + "/logfile-operator:zookeeper-3.8"
+	}
+	return DefaultImageRegistry + "/logfile-operator:" + component + "-" + version
+}
+
+// ImageOptions operator级别的镜像配置，通过启动参数或环境变量设置，对组件和注入的sidecar同时生效
+type ImageOptions struct {
+	// 替换默认镜像的仓库地址，例如内部的harbor: harbor.example.com/logging
+	Registry string
+	// 追加到默认镜像tag之后，用于使用内部重新构建的镜像，例如 -harbor
+	TagSuffix string
+	// 拉取镜像的secret名称，组件namespace中必须存在
+	PullSecrets []string
+}
+
+// Image 返回组件镜像，LogFile中指定的镜像优先，其次是按operator配置改写后的默认镜像
+func (o ImageOptions) Image(logfile *apiv1.LogFile, component string) string {
+	if logfile != nil {
+		if image := logfile.Spec.Image(component); image != "" {
+			return image
+		}
+	}
+	version := apiv1.DefaultVersion
+	switch {
+	case logfile == nil:
+	case component == apiv1.ComponentElasticsearch:
+		version = ElasticsearchVersion(logfile)
+	default:
+		version = StackVersion(logfile)
+	}
+	image := defaultImage(component, version)
+	if o.Registry != "" {
+		// 只保留镜像名称和tag，例如 logfile-operator:elasticsearch-8.5.0
+		image = strings.TrimSuffix(o.Registry, "/") + "/" + image[strings.LastIndex(image, "/")+1:]
+	}
+	return image + o.TagSuffix
+}
+
+// ImagePullSecrets 返回拉取镜像的secret，包括operator配置的和LogFile中指定的
+func (o ImageOptions) ImagePullSecrets(logfile *apiv1.LogFile) []corev1.LocalObjectReference {
+	var pullsecrets []corev1.LocalObjectReference
+	for _, name := range o.PullSecrets {
+		pullsecrets = appendPullSecret(pullsecrets, corev1.LocalObjectReference{Name: name})
+	}
+	if logfile != nil && logfile.Spec.Images != nil {
+		for _, pullsecret := range logfile.Spec.Images.PullSecrets {
+			pullsecrets = appendPullSecret(pullsecrets, pullsecret)
+		}
+	}
+	return pullsecrets
+}
+
+// appendPullSecret 按名称去重追加
+func appendPullSecret(pullsecrets []corev1.LocalObjectReference, pullsecret corev1.LocalObjectReference) []corev1.LocalObjectReference {
+	if pullsecret.Name == "" {
+		return pullsecrets
+	}
+	for _, existing := range pullsecrets {
+		if existing.Name == pullsecret.Name {
+			return pullsecrets
+		}
+	}
+	return append(pullsecrets, pullsecret)
+}
+
+// ParseImagePullSecrets 解析逗号分隔的secret名称
+func ParseImagePullSecrets(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
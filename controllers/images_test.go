@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestImage(t *testing.T) {
+	mirror := ImageOptions{Registry: "harbor.example.com/logging/", TagSuffix: "-harbor"}
+	logfile := &apiv1.LogFile{Spec: apiv1.LogFileSpec{Images: &apiv1.Images{Kibana: "docker.elastic.co/kibana/kibana:8.5.0"}}}
+
+	for _, tt := range []struct {
+		options   ImageOptions
+		logfile   *apiv1.LogFile
+		component string
+		image     string
+	}{
+		{ImageOptions{}, nil, apiv1.ComponentElasticsearch, DefaultImageRegistry + "/logfile-operator:elasticsearch-8.5.0"},
+		{ImageOptions{}, nil, apiv1.ComponentKafka, DefaultImageRegistry + "/logfile-operator:kafka-3.3"},
+		// 私有仓库只替换仓库地址，保留镜像名称和tag
+		{mirror, nil, apiv1.ComponentFilebeat, "harbor.example.com/logging/logfile-operator:filebeat-8.5.0-harbor"},
+		{mirror, logfile, apiv1.ComponentLogstash, "harbor.example.com/logging/logfile-operator:logstash-8.5.0-harbor"},
+		// LogFile中指定的镜像不改写
+		{mirror, logfile, apiv1.ComponentKibana, "docker.elastic.co/kibana/kibana:8.5.0"},
+	} {
+		if image := tt.options.Image(tt.logfile, tt.component); image != tt.image {
+			t.Errorf("Image(%s) with %+v = %s, want %s", tt.component, tt.options, image, tt.image)
+		}
+	}
+}
+
+func TestImagePullSecrets(t *testing.T) {
+	options := ImageOptions{PullSecrets: ParseImagePullSecrets(" harbor-pull-secret, ,registry-secret")}
+	logfile := &apiv1.LogFile{Spec: apiv1.LogFileSpec{Images: &apiv1.Images{
+		PullSecrets: []corev1.LocalObjectReference{{Name: "registry-secret"}, {Name: ""}, {Name: "team-secret"}},
+	}}}
+	expected := []corev1.LocalObjectReference{{Name: "harbor-pull-secret"}, {Name: "registry-secret"}, {Name: "team-secret"}}
+	if pullsecrets := options.ImagePullSecrets(logfile); !reflect.DeepEqual(pullsecrets, expected) {
+		t.Errorf("ImagePullSecrets() = %v, want %v", pullsecrets, expected)
+	}
+	if pullsecrets := (ImageOptions{}).ImagePullSecrets(nil); len(pullsecrets) != 0 {
+		t.Errorf("ImagePullSecrets() = %v, want none", pullsecrets)
+	}
+}
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// InjectionLabel pod上设置为"false"时不注入sidecar
+// namespace上的同名标签控制整个namespace的注入方式，webhook只拦截设置了enabled或force的namespace
+const InjectionLabel = "pod-admission-webhook-injection"
+
+// namespace的注入方式
+const (
+	// InjectionEnabled 有日志注解或LogCollector的pod注入sidecar，都没有时使用默认日志路径，pod可以通过标签关闭注入
+	InjectionEnabled = "enabled"
+	// InjectionDisabled 不注入sidecar
+	InjectionDisabled = "disabled"
+	// InjectionForce 与enabled相同，但忽略pod上关闭注入的标签
+	InjectionForce = "force"
+)
+
+// DefaultPathsAnnotation namespace上的注解，值为逗号分隔的日志文件路径
+// 开启注入的namespace中没有声明日志路径的pod使用这些路径，未设置时使用operator的默认路径
+const DefaultPathsAnnotation = "logfile.huisebug.org/default-paths"
+
+// SystemNamespaces 不论如何配置都不注入sidecar的namespace
+var SystemNamespaces = []string{"kube-system", "kube-public", "kube-node-lease", DefaultStackNamespace}
+
+// InjectionPolicy operator级别的注入策略，由启动参数设置
+type InjectionPolicy struct {
+	// 只在这些namespace中注入，为空时不限制，namespace仍需要设置注入标签
+	AllowNamespaces []string
+	// 不在这些namespace中注入，优先于AllowNamespaces
+	DenyNamespaces []string
+	// 开启注入的namespace中没有声明日志路径的pod使用的默认路径
+	DefaultPaths []string
+	// pod未设置注解时sidecar的注入方式
+	DefaultSidecarMode string
+	// 注解写错时拒绝创建pod，默认跳过写错的路由并返回警告
+	RejectInvalidAnnotations bool
+}
+
+// ParseNamespaces 解析逗号分隔的namespace列表
+func ParseNamespaces(value string) []string {
+	return splitMetadataKeys(value)
+}
+
+// ParseLogPaths 解析逗号分隔的日志文件路径，路径不合法时返回错误
+func ParseLogPaths(value string) ([]string, error) {
+	paths := splitMetadataKeys(value)
+	var allErrs field.ErrorList
+	for i, logpath := range paths {
+		allErrs = append(allErrs, apiv1.ValidateLogPath(field.NewPath("paths").Index(i), logpath)...)
+	}
+	return paths, allErrs.ToAggregate()
+}
+
+// Denied 返回namespace不允许注入的原因，允许时为空
+func (p InjectionPolicy) Denied(namespace string) string {
+	switch {
+	case containsString(SystemNamespaces, namespace):
+		return "系统namespace"
+	case containsString(p.DenyNamespaces, namespace):
+		return "在operator的禁止注入列表中"
+	case len(p.AllowNamespaces) > 0 && !containsString(p.AllowNamespaces, namespace):
+		return "不在operator的允许注入列表中"
+	}
+	return ""
+}
+
+// NamespaceInjection 返回namespace的注入方式，兼容旧版本的enabled标签，未设置或无法识别时为空
+func NamespaceInjection(ns *corev1.Namespace) string {
+	switch value := ns.Labels[InjectionLabel]; value {
+	case InjectionEnabled, InjectionForce:
+		return value
+	case InjectionDisabled, "false":
+		return InjectionDisabled
+	}
+	return ""
+}
+
+// DefaultRoutes 返回开启注入的namespace中没有声明日志路径的pod使用的路由
+func (p InjectionPolicy) DefaultRoutes(ns *corev1.Namespace) []LogRoute {
+	paths := p.DefaultPaths
+	if value, ok := ns.Annotations[DefaultPathsAnnotation]; ok {
+		paths = splitMetadataKeys(value)
+	}
+	defaults := []string{}
+	for i, logpath := range paths {
+		if errs := apiv1.ValidateLogPath(field.NewPath("paths").Index(i), logpath); len(errs) > 0 {
+			logger.Info("invalid default log path, ignored", "namespace", ns.Name, "error", errs.ToAggregate().Error())
+			continue
+		}
+		defaults = append(defaults, logpath)
+	}
+	if len(defaults) == 0 {
+		return nil
+	}
+	return []LogRoute{{Paths: defaults}}
+}
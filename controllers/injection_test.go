@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestParseLogPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		paths   []string
+		invalid bool
+	}{
+		{name: "empty", value: "", paths: []string{}},
+		{name: "multiple paths", value: "/var/log/*.log, /data/logs/app.log", paths: []string{"/var/log/*.log", "/data/logs/app.log"}},
+		{name: "relative path", value: "/var/log/*.log,logs/app.log", invalid: true},
+		{name: "quote in path", value: `/var/log/"app".log`, invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths, err := ParseLogPaths(tt.value)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("ParseLogPaths(%q) succeeded, want error", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(paths) != len(tt.paths) || (len(paths) > 0 && !reflect.DeepEqual(paths, tt.paths)) {
+				t.Errorf("paths = %v, want %v", paths, tt.paths)
+			}
+		})
+	}
+}
+
+func TestNamespaceInjection(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		annotations map[string]string
+		injection   string
+	}{
+		{name: "not opted in", injection: ""},
+		{name: "label", labels: map[string]string{InjectionLabel: InjectionForce}, injection: InjectionForce},
+		{name: "legacy false label", labels: map[string]string{InjectionLabel: "false"}, injection: InjectionDisabled},
+		// webhook只拦截设置了标签的namespace，注解不能开启注入
+		{name: "annotation ignored", annotations: map[string]string{InjectionLabel: InjectionEnabled}, injection: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app", Labels: tt.labels, Annotations: tt.annotations}}
+			if injection := NamespaceInjection(ns); injection != tt.injection {
+				t.Errorf("injection = %q, want %q", injection, tt.injection)
+			}
+		})
+	}
+}
+
+func TestHandleSkipsBeforeLookups(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace *corev1.Namespace
+		policy    InjectionPolicy
+		pod       map[string]string
+	}{
+		{name: "system namespace", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "kube-system"}}},
+		{name: "deny list", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app"}}, policy: InjectionPolicy{DenyNamespaces: []string{"app"}}},
+		{name: "not opted in", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app"}}},
+		{name: "disabled", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app", Labels: map[string]string{InjectionLabel: InjectionDisabled}}}},
+		{name: "pod opted out", namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app", Labels: map[string]string{InjectionLabel: InjectionEnabled}}}, pod: map[string]string{InjectionLabel: "false"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestReconciler(t, tt.namespace)
+			decoder, err := admission.NewDecoder(r.Scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := NewPodSideCarMutate(r.Client, ImageOptions{}, tt.policy, false).(*PodSidecarMutate)
+			if err := v.InjectDecoder(decoder); err != nil {
+				t.Fatal(err)
+			}
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{GenerateName: "app-", Namespace: tt.namespace.Name, Labels: tt.pod, Annotations: map[string]string{"logfile.huisebug.org/log": "/var/log/app.log"}},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			}
+			raw, err := json.Marshal(pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Create,
+				Namespace: tt.namespace.Name,
+				Object:    runtime.RawExtension{Raw: raw},
+			}}
+			// 跳过注入时不访问集群外的clientset，也不修改pod
+			resp := v.Handle(context.Background(), req)
+			if !resp.Allowed || len(resp.Patches) > 0 {
+				t.Errorf("response = %+v, want allowed without patches", resp)
+			}
+		})
+	}
+}
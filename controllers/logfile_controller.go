@@ -18,31 +18,44 @@ package controllers
 
 import (
 	"context"
-	"reflect"
+	"fmt"
 	"time"
 
 	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/utils/pointer"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // LogFileReconciler reconciles a LogFile object
 type LogFileReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// operator级别的镜像仓库配置
+	Images ImageOptions
 }
 
-var Status = apiv1.LogFileStatus{
-	Status: "Active",
-}
+// WaitResult 依赖的组件尚未就绪时重新入队，重试间隔由控制器的限速队列按指数退避计算
+var WaitResult = ctrl.Result{Requeue: true}
 
 //+kubebuilder:rbac:groups=api.huisebug.org,resources=logfiles,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=api.huisebug.org,resources=logfiles/status,verbs=get;update;patch
@@ -53,8 +66,11 @@ var Status = apiv1.LogFileStatus{
 //+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+//存储扩容时直接修改statefulset创建的pvc
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;update;patch;delete
 //+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
 //可以往其他namespace写入event
 //+kubebuilder:rbac:groups=core,resources=events,verbs=get;list;watch;create;update;patch;delete
 
@@ -78,216 +94,337 @@ func (r *LogFileReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	// 如果处在删除中直接跳过
+	// 如果处在删除中，按顺序停止并删除组件，完成后移除finalizer
 	if logfile.DeletionTimestamp != nil {
 		customizelog.Info("logfile in deleting", "name", req.String())
-		return ctrl.Result{}, nil
-	}
-	// 如果处在活跃状态说明已经运行一个方案了，直接跳过
-	if logfile.Status == Status {
-		customizelog.Info("logfile in Already active", "name", req.String())
-		return ctrl.Result{}, nil
+		if !controllerutil.ContainsFinalizer(logfile, CleanupFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		original := logfile.DeepCopy()
+		logfile.Default()
+		result, done, err := r.Teardown(ctx, logfile)
+		if err != nil || !done {
+			if !equality.Semantic.DeepEqual(original.Status, logfile.Status) {
+				if updateErr := r.Status().Update(ctx, logfile); updateErr != nil && err == nil {
+					err = updateErr
+				}
+			}
+			return result, err
+		}
+		patch := client.MergeFrom(logfile.DeepCopy())
+		controllerutil.RemoveFinalizer(logfile, CleanupFinalizer)
+		return ctrl.Result{}, r.Patch(ctx, logfile, patch)
 	}
-	// 运行
-	if err := r.Run(ctx, logfile); err != nil {
+	// 每次都完整调谐，组件崩溃或被误删时可以重新收敛，并将各组件的状态写回status
+	original := logfile.DeepCopy()
+	result, err := r.Run(ctx, logfile)
+	if err != nil {
 		customizelog.Error(err, "failed to Run logfile", "name", req.String())
+		r.Recorder.Event(logfile, corev1.EventTypeWarning, "ReconcileError", err.Error())
+	}
+	if updateErr := r.UpdateStatus(ctx, original, logfile, err); updateErr != nil {
+		customizelog.Error(updateErr, "failed to update logfile status", "name", req.String())
+		if err == nil {
+			err = updateErr
+		}
+	}
+	if err != nil {
 		return ctrl.Result{}, err
 	}
-	return ctrl.Result{}, nil
+	return result, nil
 }
 
-func (r *LogFileReconciler) Run(ctx context.Context, logfile *apiv1.LogFile) error {
+// Run 按阶段依次调谐各组件，依赖的组件尚未就绪时返回WaitResult重新入队，不阻塞worker
+// 各组件是否就绪记录在logfile的status.conditions中，由调用方写回
+func (r *LogFileReconciler) Run(ctx context.Context, logfile *apiv1.LogFile) (ctrl.Result, error) {
 	var err error
 	customizelog := logger.WithValues("func", "Run")
 
-	logfile = logfile.DeepCopy()
 	logfilename := types.NamespacedName{
 		Namespace: logfile.Namespace,
 		Name:      logfile.Name,
 	}
-	owner := []metav1.OwnerReference{
-		{
-			APIVersion:         logfile.APIVersion,
-			Kind:               logfile.Kind,
-			Name:               logfile.Name,
-			Controller:         pointer.BoolPtr(true),
-			BlockOwnerDeletion: pointer.BoolPtr(true),
-			UID:                logfile.UID,
-		},
-	}
 	labels := map[string]string{
 		"logfile-operator": logfile.Name,
 	}
 
-	meta := metav1.ObjectMeta{
-		OwnerReferences: owner,
+	// 所属关系由SetOwnerReference设置，跨namespace部署时不能使用ownerReferences
+	meta := metav1.ObjectMeta{}
+	stacknamespace := StackNamespace(logfile)
+	// 组件名称固定，同一个namespace只能部署一套
+	if other, err := r.CheckStackConflict(ctx, logfile); err != nil || other != nil {
+		if other != nil {
+			err = fmt.Errorf("namespace %s is already used by LogFile %s/%s", stacknamespace, other.Namespace, other.Name)
+		}
+		return ctrl.Result{}, err
+	}
+	// 删除时需要按顺序停止组件并按保留策略处理数据，跨namespace部署的组件也不会被级联删除
+	if !controllerutil.ContainsFinalizer(logfile, CleanupFinalizer) {
+		// 只修补finalizers，patch会用集群中的对象覆盖logfile，需要在补全默认值之前进行
+		patch := client.MergeFrom(logfile.DeepCopy())
+		controllerutil.AddFinalizer(logfile, CleanupFinalizer)
+		if err := r.Patch(ctx, logfile, patch); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	// 兼容升级前创建或未经过webhook的对象，补全未设置的默认值，只在内存中生效
+	logfile.Default()
+	customizelog.Info("logfile operatra 部署模式", "namespace", stacknamespace, "programmenum", logfile.Spec.ProgrammeNum, "elasticsearch", logfile.Spec.ElasticsearchMode(), "kafka", logfile.Spec.KafkaMode(), "logstash", logfile.Spec.LogstashEnabled())
+
+	// 解析各组件使用的密码，写入secret后由各组件引用
+	credentialsmeta := meta.DeepCopy()
+	credentialsmeta.Name = CredentialsSecretName
+	credentialsmeta.Namespace = stacknamespace
+	labels["app"] = credentialsmeta.Name
+	credentialsmeta.Labels = labels
+	desired, err := r.ResolveCredentials(ctx, logfile, *credentialsmeta)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
-	customizelog.Info("logfile operatra 采用方案序号", logfile.Spec.ProgrammeNum)
 
 	// 创建filebeat输出位置configmap
 
 	filebeatmeta := meta.DeepCopy()
 	filebeatmeta.Name = "filebeat-sidecar"
-	filebeatmeta.Namespace = "logfile-operator-system"
+	filebeatmeta.Namespace = stacknamespace
 	labels["app"] = filebeatmeta.Name
 	filebeatmeta.Labels = labels
 	if err = r.FilebeatCreteConfigMap(ctx, logfile, logfilename, *filebeatmeta, labels); err != nil {
-		return err
+		SetComponentCondition(logfile, apiv1.ConditionFilebeatConfigReady, false, "ReconcileError", err.Error())
+		return ctrl.Result{}, err
 	}
+	SetComponentCondition(logfile, apiv1.ConditionFilebeatConfigReady, true, "Configured", "configmap "+filebeatmeta.Name+" is up to date")
 
-	// 创建kafka对应的方案序号
-	switch logfile.Spec.ProgrammeNum {
-	case 5:
+	// 按kafka的部署模式创建
+	switch logfile.Spec.KafkaMode() {
+	case apiv1.ModeSingle:
 		// 定义统一的部署类型名称
 		kafkameta := meta.DeepCopy()
 		kafkameta.Name = "kafka"
-		kafkameta.Namespace = "logfile-operator-system"
+		kafkameta.Namespace = stacknamespace
 		labels["app"] = kafkameta.Name
 		kafkameta.Labels = labels
 		if err = r.KafkaCreteService(ctx, logfile, logfilename, *kafkameta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
 		}
 		if err = r.KafkaCreteStatefulSet(ctx, logfile, logfilename, *kafkameta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
+		}
+		if _, err = r.SetStatefulSetCondition(ctx, logfile, apiv1.ConditionKafkaReady, types.NamespacedName{Namespace: kafkameta.Namespace, Name: kafkameta.Name}); err != nil {
+			return ctrl.Result{}, err
 		}
-	case 6:
+	case apiv1.ModeCluster:
 		// 定义统一的部署类型名称
 		zookeepermeta := meta.DeepCopy()
 		zookeepermeta.Name = "kafka-cluster-zookeeper"
-		zookeepermeta.Namespace = "logfile-operator-system"
+		zookeepermeta.Namespace = stacknamespace
 		labels["app"] = zookeepermeta.Name
 		zookeepermeta.Labels = labels
 		if err = r.ZookerperClusterCreteConfigMap(ctx, logfile, logfilename, *zookeepermeta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
 		}
 		if err = r.ZookerperClusterCreteService(ctx, logfile, logfilename, *zookeepermeta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
 		}
 		if err = r.ZookerperClusterCreteStatefulSet(ctx, logfile, logfilename, *zookeepermeta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
+		}
+		if _, err = r.SetStatefulSetCondition(ctx, logfile, apiv1.ConditionZookeeperReady, types.NamespacedName{Namespace: zookeepermeta.Namespace, Name: zookeepermeta.Name}); err != nil {
+			return ctrl.Result{}, err
 		}
 
 		kafkameta := meta.DeepCopy()
 		kafkameta.Name = "kafka-cluster"
-		kafkameta.Namespace = "logfile-operator-system"
+		kafkameta.Namespace = stacknamespace
 		labels["app"] = kafkameta.Name
 		kafkameta.Labels = labels
 		if err = r.KafkaClusterCreteConfigMap(ctx, logfile, logfilename, *kafkameta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
 		}
 		if err = r.KafkaClusterCreteService(ctx, logfile, logfilename, *kafkameta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
 		}
 		if err = r.KafkaClusterCreteServiceAccount(ctx, logfile, logfilename, *kafkameta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
 		}
 		if err = r.KafkaClusterCreteStatefulSet(ctx, logfile, logfilename, *kafkameta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
+		}
+		if _, err = r.SetStatefulSetCondition(ctx, logfile, apiv1.ConditionKafkaReady, types.NamespacedName{Namespace: kafkameta.Namespace, Name: kafkameta.Name}); err != nil {
+			return ctrl.Result{}, err
 		}
 	}
 
-	// 创建logstash对应的方案序号
-	switch logfile.Spec.ProgrammeNum {
-	case 3, 4, 5, 6:
+	// 启用logstash时创建
+	if logfile.Spec.LogstashEnabled() {
 		// 定义统一的部署类型名称
 		logstashmeta := meta.DeepCopy()
 		logstashmeta.Name = "logstash"
-		logstashmeta.Namespace = "logfile-operator-system"
+		logstashmeta.Namespace = stacknamespace
 		labels["app"] = logstashmeta.Name
 		logstashmeta.Labels = labels
 		if err = r.LogstashCreteConfigMap(ctx, logfile, logfilename, *logstashmeta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
 		}
 		if err = r.LogstashCreteDeployment(ctx, logfile, logfilename, *logstashmeta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
 		}
 		if err = r.LogstashCreteService(ctx, logfile, logfilename, *logstashmeta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
+		}
+		if _, err = r.SetDeploymentCondition(ctx, logfile, apiv1.ConditionLogstashReady, types.NamespacedName{Namespace: logstashmeta.Namespace, Name: logstashmeta.Name}); err != nil {
+			return ctrl.Result{}, err
 		}
 	}
 
-	// 创建elasticsearch对应的方案序号
-	switch logfile.Spec.ProgrammeNum {
-	case 1, 3, 5:
+	// 按elasticsearch的部署模式创建
+	// 记录elasticsearch的元数据、ca证书和已生效的密码，用于设置密码
+	var esmeta metav1.ObjectMeta
+	var escasecret string
+	var applied *Credentials
+	// 集群模式的节点组，以及是否有节点正在迁出分片
+	var nodesets []apiv1.NodeSet
+	var draining bool
+	switch logfile.Spec.ElasticsearchMode() {
+	case apiv1.ModeSingle:
 		// 定义统一的部署类型名称
 		elasticesearchmeta := meta.DeepCopy()
 		elasticesearchmeta.Name = "elasticsearch"
-		elasticesearchmeta.Namespace = "logfile-operator-system"
+		elasticesearchmeta.Namespace = stacknamespace
 		labels["app"] = elasticesearchmeta.Name
 		elasticesearchmeta.Labels = labels
 		if err = r.ElasticsearchCreteConfigMap(ctx, logfile, logfilename, *elasticesearchmeta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
 		}
 		if err = r.ElasticsearchCreteService(ctx, logfile, logfilename, *elasticesearchmeta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
+		}
+		esmeta = *elasticesearchmeta.DeepCopy()
+		if applied, err = r.GetAppliedCredentials(ctx, logfile, esmeta, desired); err != nil {
+			return ctrl.Result{}, err
 		}
 		if err = r.ElasticsearchCreteStatefulSet(ctx, logfile, logfilename, *elasticesearchmeta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
 		}
-		customizelog.Info("等待Elasticsearch创建80秒后再创建设置kibana用户密码Job")
-
-		time.Sleep(time.Duration(80) * time.Second)
-		elasticesearchmeta.Name += "-set-kibana-password"
-		if err = r.ElasticsearchKibanaUserCreteJob(ctx, logfile, logfilename, *elasticesearchmeta, labels); err != nil {
-			return err
+		// 等待Elasticsearch就绪后再设置密码
+		if ready, err := r.ElasticsearchReady(ctx, types.NamespacedName{Namespace: elasticesearchmeta.Namespace, Name: elasticesearchmeta.Name}, "", applied.ElasticPassword); err != nil || !ready {
+			customizelog.Info("等待Elasticsearch就绪", "name", elasticesearchmeta.Name)
+			SetElasticsearchWaiting(logfile, elasticesearchmeta.Name)
+			return WaitResult, err
 		}
+		SetComponentCondition(logfile, apiv1.ConditionElasticsearchReady, true, "Healthy", "elasticsearch "+elasticesearchmeta.Name+" cluster health is green or yellow")
+		// 单节点直接按statefulset的滚动更新重启，就绪后即为新版本
+		logfile.Status.Version = ElasticsearchVersion(logfile)
 
-	case 2, 4, 6:
+	case apiv1.ModeCluster:
 		// 定义统一的部署类型名称
 		elasticesearchmeta := meta.DeepCopy()
 		elasticesearchmeta.Name = "elasticsearch-master"
-		elasticesearchmeta.Namespace = "logfile-operator-system"
+		elasticesearchmeta.Namespace = stacknamespace
 		labels["app"] = elasticesearchmeta.Name
 		elasticesearchmeta.Labels = labels
 
 		if err = r.ElasticsearchClusterCretePodDisruptionBudget(ctx, logfile, logfilename, *elasticesearchmeta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
 		}
-		if err = r.ElasticsearchClusterCreteSecret(ctx, logfile, logfilename, *elasticesearchmeta, labels); err != nil {
-			return err
+		// 证书由cert-manager签发时，等待签发完成后再创建elasticsearch
+		if logfile.Spec.CertManager() != nil {
+			issued, err := r.ElasticsearchClusterCreteCertificate(ctx, logfile, logfilename, *elasticesearchmeta, labels)
+			if err != nil || !issued {
+				customizelog.Info("等待cert-manager签发证书", "name", CertsSecretName(elasticesearchmeta.Name))
+				SetComponentCondition(logfile, apiv1.ConditionElasticsearchReady, false, "CertificatePending", "waiting for cert-manager to issue "+CertsSecretName(elasticesearchmeta.Name))
+				return WaitResult, err
+			}
+		} else if err = r.ElasticsearchClusterCreteSecret(ctx, logfile, logfilename, *elasticesearchmeta, labels); err != nil {
+			return ctrl.Result{}, err
 		}
 		if err = r.ElasticsearchClusterCreteService(ctx, logfile, logfilename, *elasticesearchmeta, labels); err != nil {
-			return err
+			return ctrl.Result{}, err
+		}
+		esmeta = *elasticesearchmeta.DeepCopy()
+		escasecret = CertsSecretName(elasticesearchmeta.Name)
+		if applied, err = r.GetAppliedCredentials(ctx, logfile, esmeta, desired); err != nil {
+			return ctrl.Result{}, err
+		}
+		// 缩容的节点先迁出分片，迁出完成前保持当前副本数
+		nodesets, draining, err = r.ElasticsearchScaleDown(ctx, logfile, types.NamespacedName{Namespace: elasticesearchmeta.Namespace, Name: elasticesearchmeta.Name}, logfile.Spec.ElasticsearchNodeSets(), escasecret, applied.ElasticPassword)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		statefulsets := []string{}
+		for _, nodeset := range nodesets {
+			if err = r.ElasticsearchClusterCreteStatefulSet(ctx, logfile, logfilename, *elasticesearchmeta, labels, nodeset); err != nil {
+				return ctrl.Result{}, err
+			}
+			statefulsets = append(statefulsets, NodeSetStatefulSetName(elasticesearchmeta.Name, nodeset.Name))
 		}
-		if err = r.ElasticsearchClusterCreteStatefulSet(ctx, logfile, logfilename, *elasticesearchmeta, labels); err != nil {
-			return err
+		// 版本或配置变化后逐个重启elasticsearch节点，完成后再更新kibana
+		if upgraded, err := r.ElasticsearchRollingUpgrade(ctx, logfile, types.NamespacedName{Namespace: elasticesearchmeta.Namespace, Name: elasticesearchmeta.Name}, nodesets, escasecret, applied.ElasticPassword); err != nil || !upgraded {
+			// 还没有记录运行的版本时是首次部署，不是升级
+			if logfile.Status.Version == "" {
+				customizelog.Info("等待Elasticsearch集群创建", "name", elasticesearchmeta.Name)
+				SetElasticsearchWaiting(logfile, elasticesearchmeta.Name)
+				return WaitResult, err
+			}
+			customizelog.Info("等待Elasticsearch集群滚动重启", "name", elasticesearchmeta.Name)
+			SetComponentCondition(logfile, apiv1.ConditionElasticsearchReady, false, "Upgrading", UpgradeMessage(logfile, elasticesearchmeta.Name))
+			return WaitResult, err
 		}
-		customizelog.Info("等待Elasticsearch集群创建80秒后再创建设置kibana用户密码Job")
-		time.Sleep(time.Duration(80) * time.Second)
-		elasticesearchmeta.Name += "-set-kibana-password"
-		if err = r.ElasticsearchClusterKibanaUserCreteJob(ctx, logfile, logfilename, *elasticesearchmeta, labels); err != nil {
-			return err
+		// 等待Elasticsearch集群就绪后再设置密码
+		if ready, err := r.ElasticsearchReady(ctx, types.NamespacedName{Namespace: elasticesearchmeta.Namespace, Name: elasticesearchmeta.Name}, escasecret, applied.ElasticPassword, statefulsets...); err != nil || !ready {
+			customizelog.Info("等待Elasticsearch集群就绪", "name", elasticesearchmeta.Name)
+			SetElasticsearchWaiting(logfile, elasticesearchmeta.Name)
+			return WaitResult, err
 		}
+		SetComponentCondition(logfile, apiv1.ConditionElasticsearchReady, true, "Healthy", "elasticsearch "+elasticesearchmeta.Name+" cluster health is green or yellow")
 
 	}
 
-	// 等待KibanaUser创建成功
-	customizelog.Info("等待elasticsearch-set-kibana-password Job设置kibana用户密码后20秒再创建kibana")
-	time.Sleep(time.Duration(20) * time.Second)
+	// 设置kibana_system和日志写入用户的密码，并轮换修改后的elastic密码
+	if err = r.SyncElasticsearchPasswords(ctx, logfile, esmeta, escasecret, applied, desired); err != nil {
+		SetComponentCondition(logfile, apiv1.ConditionElasticsearchReady, false, "PasswordSyncFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+	// 业务namespace中的密码副本与轮换后的密码保持一致
+	if err = r.SyncMirroredSecrets(ctx, logfile); err != nil {
+		return ctrl.Result{}, err
+	}
+	// 创建日志的ILM策略和索引模板
+	if err = r.SyncRetention(ctx, logfile, esmeta, escasecret, applied.ElasticPassword); err != nil {
+		SetComponentCondition(logfile, apiv1.ConditionElasticsearchReady, false, "RetentionSyncFailed", err.Error())
+		return ctrl.Result{}, err
+	}
 	kibanameta := meta.DeepCopy()
 	kibanameta.Name = "kibana"
-	kibanameta.Namespace = "logfile-operator-system"
+	kibanameta.Namespace = stacknamespace
 	labels["app"] = kibanameta.Name
 	kibanameta.Labels = labels
 	if err = r.KibanaCreteConfigMap(ctx, logfile, logfilename, *kibanameta, labels); err != nil {
-		return err
+		return ctrl.Result{}, err
 	}
 	if err = r.KibanaCreteService(ctx, logfile, logfilename, *kibanameta, labels); err != nil {
-		return err
+		return ctrl.Result{}, err
 	}
 	if err = r.KibanaCreteDeployment(ctx, logfile, logfilename, *kibanameta, labels); err != nil {
-		return err
+		return ctrl.Result{}, err
 	}
-
-	// 更新状态
-
-	if !reflect.DeepEqual(logfile.Status, Status) {
-		logfile.Status = Status
-		customizelog.Info("update logfile status", "name", logfilename.String())
-		return r.Client.Status().Update(ctx, logfile)
+	if _, err = r.SetDeploymentCondition(ctx, logfile, apiv1.ConditionKibanaReady, types.NamespacedName{Namespace: kibanameta.Namespace, Name: kibanameta.Name}); err != nil {
+		return ctrl.Result{}, err
 	}
 
-	return nil
+	// elasticsearch节点还在迁出分片时继续检查，迁出完成后再缩容
+	if draining {
+		customizelog.Info("等待Elasticsearch节点迁出分片")
+		return WaitResult, nil
+	}
+	// 还有组件未就绪时继续等待，全部就绪后定期重新检查
+	for _, conditiontype := range componentConditions(logfile) {
+		if !apimeta.IsStatusConditionTrue(logfile.Status.Conditions, conditiontype) {
+			customizelog.Info("等待组件就绪", "condition", conditiontype)
+			return WaitResult, nil
+		}
+	}
+	return ResyncResult, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -296,10 +433,20 @@ func (r *LogFileReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		WithOptions(controller.Options{
 			MaxConcurrentReconciles: 3,
+			// 等待组件就绪时的重新入队从5秒开始指数退避，最长5分钟
+			RateLimiter: workqueue.NewItemExponentialFailureRateLimiter(5*time.Second, 5*time.Minute),
 		}).
-		For(&apiv1.LogFile{}).
-		//下面的单独监听会导致多次触发Reconcile的执行
-		// Owns(&appsv1.Deployment{}).
-		// Owns(&corev1.Service{}).
+		// 只有spec变化时才调谐，忽略自身写回status触发的事件
+		For(&apiv1.LogFile{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// Run已经是幂等的，监听所有创建的资源，被删除或手动修改后重新收敛
+		// 组件可能部署在其他namespace，通过标签找到所属的LogFile
+		Watches(&source.Kind{Type: &appsv1.StatefulSet{}}, handler.EnqueueRequestsFromMapFunc(OwnerLabelsMapFunc)).
+		Watches(&source.Kind{Type: &appsv1.Deployment{}}, handler.EnqueueRequestsFromMapFunc(OwnerLabelsMapFunc)).
+		Watches(&source.Kind{Type: &corev1.Service{}}, handler.EnqueueRequestsFromMapFunc(OwnerLabelsMapFunc)).
+		// configmap和secret只缓存元数据，不把集群中所有secret的内容缓存到operator中，读取时直接访问apiserver
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(OwnerLabelsMapFunc), builder.OnlyMetadata).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.SecretMapFunc), builder.OnlyMetadata).
+		Watches(&source.Kind{Type: &corev1.ServiceAccount{}}, handler.EnqueueRequestsFromMapFunc(OwnerLabelsMapFunc)).
+		Watches(&source.Kind{Type: &policyv1.PodDisruptionBudget{}}, handler.EnqueueRequestsFromMapFunc(OwnerLabelsMapFunc)).
 		Complete(r)
 }
@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// 选择写入日志的pod标签和注解，值为逗号分隔的名称
+// 未设置标签时写入创建pod时的所有标签，未设置注解时不写入注解
+const (
+	MetadataLabelsAnnotation      = "logfile.huisebug.org/kubernetes.labels"
+	MetadataAnnotationsAnnotation = "logfile.huisebug.org/kubernetes.annotations"
+)
+
+// 控制器生成的标签，每次发布都会变化，不写入日志
+var metadataIgnoredLabels = []string{"pod-template-hash", "controller-revision-hash", "pod-template-generation"}
+
+// KubernetesMetadata 写入每条日志的pod信息，pod名称、节点等在创建pod时还不确定，通过Downward API的环境变量传递给filebeat
+type KubernetesMetadata struct {
+	Labels      []string
+	Annotations []string
+}
+
+// parseKubernetesMetadata 解析选择的标签和注解，pod上的注解优先，其次是选择了pod的LogCollector
+func parseKubernetesMetadata(pod *corev1.Pod, collectors []apiv1.LogCollector) (*KubernetesMetadata, field.ErrorList) {
+	var allErrs field.ErrorList
+	metadata := &KubernetesMetadata{}
+	annotationspath := field.NewPath("metadata", "annotations")
+
+	collectorlabels, collectorannotations := []string{}, []string{}
+	for _, collector := range collectors {
+		collectorlabels = append(collectorlabels, collector.Spec.MetadataLabels...)
+		collectorannotations = append(collectorannotations, collector.Spec.MetadataAnnotations...)
+	}
+	if value, ok := pod.Annotations[MetadataLabelsAnnotation]; ok {
+		metadata.Labels = splitMetadataKeys(value)
+	} else if len(collectorlabels) > 0 {
+		metadata.Labels = splitMetadataKeys(strings.Join(collectorlabels, ","))
+	} else {
+		for key := range pod.Labels {
+			if !containsString(metadataIgnoredLabels, key) {
+				metadata.Labels = append(metadata.Labels, key)
+			}
+		}
+	}
+	if value, ok := pod.Annotations[MetadataAnnotationsAnnotation]; ok {
+		metadata.Annotations = splitMetadataKeys(value)
+	} else {
+		metadata.Annotations = splitMetadataKeys(strings.Join(collectorannotations, ","))
+	}
+	// 不合法的名称不写入日志
+	for _, keys := range []struct {
+		annotation string
+		keys       *[]string
+	}{
+		{MetadataLabelsAnnotation, &metadata.Labels},
+		{MetadataAnnotationsAnnotation, &metadata.Annotations},
+	} {
+		valid := []string{}
+		for _, key := range *keys.keys {
+			msgs := validation.IsQualifiedName(key)
+			for _, msg := range msgs {
+				allErrs = append(allErrs, field.Invalid(annotationspath.Key(keys.annotation), key, msg))
+			}
+			if len(msgs) == 0 {
+				valid = append(valid, key)
+			}
+		}
+		*keys.keys = valid
+	}
+	sort.Strings(metadata.Labels)
+	sort.Strings(metadata.Annotations)
+	return metadata, allErrs
+}
+
+// splitMetadataKeys 拆分逗号分隔的名称
+func splitMetadataKeys(value string) []string {
+	keys := []string{}
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" && !containsString(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// dedot 标签名称中的.和/替换为_，避免在elasticsearch中被解析为嵌套字段
+func dedot(key string) string {
+	return strings.NewReplacer(".", "_", "/", "_").Replace(key)
+}
+
+// Env 返回filebeat sidecar的Downward API环境变量
+func (m *KubernetesMetadata) Env() []corev1.EnvVar {
+	fieldref := func(name, path string) corev1.EnvVar {
+		return corev1.EnvVar{
+			Name: name,
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: path},
+			},
+		}
+	}
+	env := []corev1.EnvVar{
+		fieldref("LOGFILE_POD_NAME", "metadata.name"),
+		fieldref("LOGFILE_POD_NAMESPACE", "metadata.namespace"),
+		fieldref("LOGFILE_POD_UID", "metadata.uid"),
+		fieldref("LOGFILE_POD_IP", "status.podIP"),
+		fieldref("LOGFILE_NODE_NAME", "spec.nodeName"),
+	}
+	for i, key := range m.Labels {
+		env = append(env, fieldref(fmt.Sprintf("LOGFILE_LABEL_%d", i), fmt.Sprintf("metadata.labels['%s']", key)))
+	}
+	for i, key := range m.Annotations {
+		env = append(env, fieldref(fmt.Sprintf("LOGFILE_ANNOTATION_%d", i), fmt.Sprintf("metadata.annotations['%s']", key)))
+	}
+	return env
+}
+
+// Processor 返回filebeat的add_fields，将pod信息写入每条日志的kubernetes字段，值由filebeat从环境变量读取
+func (m *KubernetesMetadata) Processor() map[string]interface{} {
+	fields := map[string]interface{}{
+		"namespace": "${LOGFILE_POD_NAMESPACE}",
+		"pod": map[string]interface{}{
+			"name": "${LOGFILE_POD_NAME}",
+			"uid":  "${LOGFILE_POD_UID}",
+			"ip":   "${LOGFILE_POD_IP}",
+		},
+		"node": map[string]interface{}{
+			"name": "${LOGFILE_NODE_NAME}",
+		},
+	}
+	if len(m.Labels) > 0 {
+		labels := map[string]interface{}{}
+		for i, key := range m.Labels {
+			labels[dedot(key)] = fmt.Sprintf("${LOGFILE_LABEL_%d:}", i)
+		}
+		fields["labels"] = labels
+	}
+	if len(m.Annotations) > 0 {
+		annotations := map[string]interface{}{}
+		for i, key := range m.Annotations {
+			annotations[dedot(key)] = fmt.Sprintf("${LOGFILE_ANNOTATION_%d:}", i)
+		}
+		fields["annotations"] = annotations
+	}
+	return map[string]interface{}{
+		"add_fields": map[string]interface{}{
+			"target": "kubernetes",
+			"fields": fields,
+		},
+	}
+}
+
+// RouteContainer 返回写入日志文件的容器，所有路径都在同一个容器已有的挂载点下时为该容器，无法确定时为空
+func RouteContainer(pod *corev1.Pod, paths []string) string {
+	name := ""
+	for _, logpath := range paths {
+		container := MountedContainer(pod, logpath)
+		if container == "" || (name != "" && container != name) {
+			return ""
+		}
+		name = container
+	}
+	return name
+}
+
+// MountedContainer 返回已有挂载点覆盖日志文件路径的容器，没有时为空
+func MountedContainer(pod *corev1.Pod, logpath string) string {
+	for _, container := range pod.Spec.Containers {
+		for _, volumemount := range container.VolumeMounts {
+			if strings.HasPrefix(logpath, strings.TrimSuffix(volumemount.MountPath, "/")+"/") {
+				return container.Name
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseKubernetesMetadata(t *testing.T) {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Labels: map[string]string{"app": "web", "app.kubernetes.io/version": "v2", "pod-template-hash": "5d9c7b"},
+	}}
+	// 未选择标签时写入除控制器生成的标签外的所有标签，不写入注解
+	metadata, errs := parseKubernetesMetadata(pod, nil)
+	if len(errs) > 0 {
+		t.Fatal(errs.ToAggregate())
+	}
+	if !reflect.DeepEqual(metadata.Labels, []string{"app", "app.kubernetes.io/version"}) || len(metadata.Annotations) != 0 {
+		t.Errorf("metadata = %+v, want all labels but pod-template-hash and no annotations", metadata)
+	}
+
+	pod.Annotations = map[string]string{
+		MetadataLabelsAnnotation:      " team, app ,team",
+		MetadataAnnotationsAnnotation: "example.com/owner",
+	}
+	metadata, errs = parseKubernetesMetadata(pod, nil)
+	if len(errs) > 0 {
+		t.Fatal(errs.ToAggregate())
+	}
+	if !reflect.DeepEqual(metadata.Labels, []string{"app", "team"}) || !reflect.DeepEqual(metadata.Annotations, []string{"example.com/owner"}) {
+		t.Errorf("metadata = %+v, want the selected labels and annotations", metadata)
+	}
+
+	pod.Annotations[MetadataLabelsAnnotation] = "app,bad key"
+	if _, errs := parseKubernetesMetadata(pod, nil); len(errs) != 1 || errs[0].Field != "metadata.annotations[logfile.huisebug.org/kubernetes.labels]" {
+		t.Errorf("errors = %v, want one error for the invalid label name", errs)
+	}
+}
+
+func TestKubernetesMetadataFields(t *testing.T) {
+	metadata := &KubernetesMetadata{Labels: []string{"app", "app.kubernetes.io/version"}, Annotations: []string{"example.com/owner"}}
+
+	// 标签和注解按序号通过Downward API传递，名称中的特殊字符不会出现在环境变量名中
+	paths := map[string]string{}
+	for _, env := range metadata.Env() {
+		paths[env.Name] = env.ValueFrom.FieldRef.FieldPath
+	}
+	for name, path := range map[string]string{
+		"LOGFILE_POD_NAME":     "metadata.name",
+		"LOGFILE_NODE_NAME":    "spec.nodeName",
+		"LOGFILE_LABEL_1":      "metadata.labels['app.kubernetes.io/version']",
+		"LOGFILE_ANNOTATION_0": "metadata.annotations['example.com/owner']",
+	} {
+		if paths[name] != path {
+			t.Errorf("env %s = %q, want %q", name, paths[name], path)
+		}
+	}
+
+	fields := metadata.Processor()["add_fields"].(map[string]interface{})["fields"].(map[string]interface{})
+	expected := map[string]interface{}{"app": "${LOGFILE_LABEL_0:}", "app_kubernetes_io_version": "${LOGFILE_LABEL_1:}"}
+	if !reflect.DeepEqual(fields["labels"], expected) {
+		t.Errorf("labels = %v, want %v", fields["labels"], expected)
+	}
+	if annotations := fields["annotations"].(map[string]interface{}); annotations["example_com_owner"] != "${LOGFILE_ANNOTATION_0:}" {
+		t.Errorf("annotations = %v", annotations)
+	}
+	if fields["namespace"] != "${LOGFILE_POD_NAMESPACE}" {
+		t.Errorf("namespace = %v", fields["namespace"])
+	}
+}
@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NodeSetLabel elasticsearch节点组pod上的标签，值为节点组名称
+const NodeSetLabel = "logfile.huisebug.org/nodeset"
+
+// 缩容前迁出分片的集群设置
+const allocationExcludeSetting = "cluster.routing.allocation.exclude._name"
+
+// NodeSetStatefulSetName 返回节点组的statefulset名称，兼容旧版本的默认节点与集群同名
+func NodeSetStatefulSetName(cluster, nodeset string) string {
+	if nodeset == "" {
+		return cluster
+	}
+	return cluster + "-" + nodeset
+}
+
+// InitialMasterNodes 返回集群首次组建时参与选举的master节点，按节点组顺序最多取3个
+// 该配置只在首次组建时生效，master节点扩容时保持不变，避免模板变化触发滚动重启
+func InitialMasterNodes(cluster string, nodesets []apiv1.NodeSet) string {
+	names := ""
+	count := 0
+	for _, nodeset := range nodesets {
+		if !nodeset.MasterEligible() {
+			continue
+		}
+		for i := int32(0); i < nodeset.Replicas && count < 3; i++ {
+			names += fmt.Sprintf("%s-%d,", NodeSetStatefulSetName(cluster, nodeset.Name), i)
+			count++
+		}
+	}
+	return names
+}
+
+// ElasticsearchScaleDown 返回各节点组本次可以设置的副本数
+// 缩容的节点先通过cluster.routing.allocation.exclude._name迁出分片，分片全部迁出后才减少statefulset的副本数，
+// 已从spec中删除的节点组同样先迁出分片再删除statefulset，返回true表示还有节点正在迁出分片
+// 排除的节点每次按spec重新计算，缩容撤销或pod删除后清除
+func (r *LogFileReconciler) ElasticsearchScaleDown(ctx context.Context, logfile *apiv1.LogFile, name types.NamespacedName, nodesets []apiv1.NodeSet, caSecret string, password string) ([]apiv1.NodeSet, bool, error) {
+	customizelog := logger.WithValues("func", "ElasticsearchScaleDown")
+
+	statefulsets := &appsv1.StatefulSetList{}
+	if err := r.List(ctx, statefulsets, client.InNamespace(name.Namespace), client.MatchingLabels{
+		OwnerNameLabel:      logfile.Name,
+		OwnerNamespaceLabel: logfile.Namespace,
+		"app":               name.Name,
+	}); err != nil {
+		return nil, false, err
+	}
+	existing := map[string]*appsv1.StatefulSet{}
+	for i := range statefulsets.Items {
+		existing[statefulsets.Items[i].Name] = &statefulsets.Items[i]
+	}
+
+	result := append([]apiv1.NodeSet{}, nodesets...)
+	removing := []string{}
+	shrinking := []int{}
+	for i, nodeset := range result {
+		statefulsetname := NodeSetStatefulSetName(name.Name, nodeset.Name)
+		statefulset, ok := existing[statefulsetname]
+		delete(existing, statefulsetname)
+		if !ok {
+			continue
+		}
+		current := pointer.Int32Deref(statefulset.Spec.Replicas, 1)
+		if nodeset.Replicas >= current {
+			continue
+		}
+		for ordinal := nodeset.Replicas; ordinal < current; ordinal++ {
+			removing = append(removing, fmt.Sprintf("%s-%d", statefulsetname, ordinal))
+		}
+		// 分片迁出前保持当前副本数
+		result[i].Replicas = current
+		shrinking = append(shrinking, i)
+	}
+	orphans := []*appsv1.StatefulSet{}
+	for _, statefulset := range existing {
+		orphans = append(orphans, statefulset)
+		for ordinal := int32(0); ordinal < pointer.Int32Deref(statefulset.Spec.Replicas, 1); ordinal++ {
+			removing = append(removing, fmt.Sprintf("%s-%d", statefulset.Name, ordinal))
+		}
+	}
+
+	esclient, err := r.NewElasticsearchClient(ctx, name, caSecret, "elastic", password)
+	if err != nil {
+		if len(removing) == 0 {
+			return result, false, nil
+		}
+		return result, true, err
+	}
+	settings := struct {
+		Persistent map[string]string `json:"persistent"`
+	}{}
+	if err := esclient.Do(ctx, http.MethodGet, "/_cluster/settings?flat_settings=true", nil, &settings); err != nil {
+		// 集群不可用时不能确认分片已迁出，保持当前副本数
+		customizelog.Info("get cluster settings failed", "error", err.Error())
+		return result, len(removing) > 0, nil
+	}
+	pods, err := r.ComponentPods(ctx, logfile, name.Name)
+	if err != nil {
+		return result, len(removing) > 0, err
+	}
+	// 每次按当前spec重新计算，缩容撤销或完成后清除，避免同名的pod重新创建后无法分配分片
+	current := settings.Persistent[allocationExcludeSetting]
+	want := AllocationExclude(current, name.Name, nodesets, removing, pods)
+	if current != want {
+		if err := esclient.SetAllocationExclude(ctx, want); err != nil {
+			return result, len(removing) > 0, err
+		}
+		customizelog.Info("update allocation exclude", "nodes", want)
+	}
+	if len(removing) == 0 {
+		return result, false, nil
+	}
+	nodes := strings.Join(removing, ",")
+	if current != want {
+		r.Recorder.Eventf(logfile, corev1.EventTypeNormal, "DrainingNodes", "Moving shards off %s before scaling down", nodes)
+	}
+
+	// 检查待删除的节点上是否还有分片
+	allocation := []struct {
+		Node   string `json:"node"`
+		Shards string `json:"shards"`
+	}{}
+	if err := esclient.Do(ctx, http.MethodGet, "/_cat/allocation?format=json&h=node,shards", nil, &allocation); err != nil {
+		customizelog.Info("get shard allocation failed", "error", err.Error())
+		return result, true, nil
+	}
+	remaining := 0
+	for _, node := range allocation {
+		if !containsString(removing, node.Node) {
+			continue
+		}
+		shards, _ := strconv.Atoi(node.Shards)
+		remaining += shards
+	}
+	if remaining > 0 {
+		customizelog.Info("等待分片迁出", "nodes", nodes, "shards", remaining)
+		return result, true, nil
+	}
+
+	// 分片已全部迁出，减少副本数并删除已移除的节点组
+	for _, i := range shrinking {
+		result[i].Replicas = nodesets[i].Replicas
+	}
+	for _, statefulset := range orphans {
+		if err := r.Delete(ctx, statefulset, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return result, true, err
+		}
+		customizelog.Info("delete nodeset statefulset", "name", statefulset.Namespace+"/"+statefulset.Name)
+	}
+	r.Recorder.Eventf(logfile, corev1.EventTypeNormal, "NodesDrained", "Shards moved off %s, scaling down", nodes)
+	return result, false, nil
+}
+
+// AllocationExclude 返回按当前spec需要排除分片分配的节点
+// 包括等待迁出分片的节点，以及已经缩容但pod还未删除的节点，保留不属于该集群的节点
+func AllocationExclude(current, cluster string, nodesets []apiv1.NodeSet, removing []string, pods []corev1.Pod) string {
+	excluded := []string{}
+	for _, node := range strings.Split(current, ",") {
+		if node != "" && !strings.HasPrefix(node, cluster+"-") {
+			excluded = append(excluded, node)
+		}
+	}
+	for _, node := range removing {
+		if !containsString(excluded, node) {
+			excluded = append(excluded, node)
+		}
+	}
+	for _, pod := range pods {
+		if !desiredNode(cluster, nodesets, pod.Name) && !containsString(excluded, pod.Name) {
+			excluded = append(excluded, pod.Name)
+		}
+	}
+	sort.Strings(excluded)
+	return strings.Join(excluded, ",")
+}
+
+// desiredNode pod是否为spec中节点组需要保留的节点
+func desiredNode(cluster string, nodesets []apiv1.NodeSet, pod string) bool {
+	index := strings.LastIndex(pod, "-")
+	if index < 0 {
+		return false
+	}
+	ordinal := podOrdinal(pod)
+	for _, nodeset := range nodesets {
+		if pod[:index] == NodeSetStatefulSetName(cluster, nodeset.Name) {
+			return ordinal >= 0 && int32(ordinal) < nodeset.Replicas
+		}
+	}
+	return false
+}
+
+// SetAllocationExclude 设置排除分片分配的节点，为空时清除
+func (c *ElasticsearchClient) SetAllocationExclude(ctx context.Context, nodes string) error {
+	var value interface{}
+	if nodes != "" {
+		value = nodes
+	}
+	return c.Do(ctx, http.MethodPut, "/_cluster/settings", map[string]interface{}{
+		"persistent": map[string]interface{}{
+			allocationExcludeSetting: value,
+		},
+	}, nil)
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestAllocationExclude(t *testing.T) {
+	pods := func(names ...string) []corev1.Pod {
+		list := []corev1.Pod{}
+		for _, name := range names {
+			list = append(list, corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name}})
+		}
+		return list
+	}
+	nodesets := []apiv1.NodeSet{{Name: "", Replicas: 3}, {Name: "hot", Replicas: 1}}
+	tests := []struct {
+		name     string
+		current  string
+		removing []string
+		pods     []corev1.Pod
+		want     string
+	}{
+		{
+			name:     "draining scaled down nodes",
+			removing: []string{"es-hot-2", "es-hot-1"},
+			pods:     pods("es-0", "es-1", "es-2", "es-hot-0", "es-hot-1", "es-hot-2"),
+			want:     "es-hot-1,es-hot-2",
+		},
+		{
+			name:    "scale down reverted",
+			current: "es-hot-1,es-hot-2",
+			pods:    pods("es-0", "es-1", "es-2", "es-hot-0"),
+			want:    "",
+		},
+		{
+			name:    "statefulset shrunk, pod still terminating",
+			current: "es-hot-1,es-hot-2",
+			pods:    pods("es-0", "es-1", "es-2", "es-hot-0", "es-hot-2"),
+			want:    "es-hot-2",
+		},
+		{
+			name:    "scale down finished",
+			current: "es-hot-2",
+			pods:    pods("es-0", "es-1", "es-2", "es-hot-0"),
+			want:    "",
+		},
+		{
+			name:    "nodes excluded by the user are kept",
+			current: "other-node,es-hot-1",
+			pods:    pods("es-0", "es-1", "es-2", "es-hot-0"),
+			want:    "other-node",
+		},
+		{
+			name:     "removed nodeset",
+			removing: []string{"es-warm-0"},
+			pods:     pods("es-0", "es-1", "es-2", "es-hot-0", "es-warm-0"),
+			want:     "es-warm-0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AllocationExclude(tt.current, "es", nodesets, tt.removing, tt.pods); got != tt.want {
+				t.Errorf("AllocationExclude() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollingUpgradeRestartsMastersLast(t *testing.T) {
+	serveElasticsearch(t, &fakeElasticsearch{health: "green"})
+
+	statefulset := func(name string, replicas int32) *appsv1.StatefulSet {
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "logging", UID: types.UID(name), Generation: 1},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+			Status:     appsv1.StatefulSetStatus{ObservedGeneration: 1, UpdateRevision: "rev-2"},
+		}
+	}
+	master := statefulset("elasticsearch-master", 1)
+	hot := statefulset("elasticsearch-hot", 2)
+	r := newTestReconciler(t, master, hot,
+		elasticsearchPod(master, "elasticsearch-master-0", "rev-1"),
+		elasticsearchPod(hot, "elasticsearch-hot-0", "rev-1"),
+		elasticsearchPod(hot, "elasticsearch-hot-1", "rev-2"),
+	)
+	logfile := &apiv1.LogFile{ObjectMeta: metav1.ObjectMeta{Name: "logfile", Namespace: "logging"}, Spec: apiv1.LogFileSpec{Version: "8.6.0"}}
+	// master节点组在spec中排在前面，仍然最后重启
+	nodesets := []apiv1.NodeSet{{Name: "master", Replicas: 1, Roles: []string{"master"}}, {Name: "hot", Replicas: 2, Roles: []string{"data_hot"}}}
+
+	if _, err := r.ElasticsearchRollingUpgrade(context.Background(), logfile, types.NamespacedName{Namespace: "logging", Name: "elasticsearch"}, nodesets, "", "changeme"); err != nil {
+		t.Fatal(err)
+	}
+	if logfile.Status.Upgrade == nil || logfile.Status.Upgrade.Pod != "elasticsearch-hot-0" {
+		t.Fatalf("Status.Upgrade = %+v, want elasticsearch-hot-0 restarted first", logfile.Status.Upgrade)
+	}
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// 记录组件所属的LogFile，组件部署在其他namespace时无法使用ownerReferences
+const (
+	OwnerNameLabel      = "logfile.huisebug.org/owner-name"
+	OwnerNamespaceLabel = "logfile.huisebug.org/owner-namespace"
+)
+
+// StackNamespace 返回组件部署的namespace，未设置targetNamespace时部署在LogFile所在的namespace
+func StackNamespace(logfile *apiv1.LogFile) string {
+	if logfile.Spec.TargetNamespace != "" {
+		return logfile.Spec.TargetNamespace
+	}
+	return logfile.Namespace
+}
+
+// SetOwnerReference 记录组件所属的LogFile
+// 与LogFile在同一namespace时设置ownerReferences用于级联删除，跨namespace时只记录标签，由finalizer负责清理
+func SetOwnerReference(logfile *apiv1.LogFile, object client.Object, scheme *runtime.Scheme) error {
+	// 复制一份，各组件的labels同时用作selector，不能修改原有的map
+	labels := map[string]string{}
+	for k, v := range object.GetLabels() {
+		labels[k] = v
+	}
+	labels[OwnerNameLabel] = logfile.Name
+	labels[OwnerNamespaceLabel] = logfile.Namespace
+	object.SetLabels(labels)
+
+	if object.GetNamespace() != logfile.Namespace {
+		return nil
+	}
+	return controllerutil.SetControllerReference(logfile, object, scheme)
+}
+
+// OwnerLabelsMapFunc 根据标签找到组件所属的LogFile并入队
+func OwnerLabelsMapFunc(object client.Object) []reconcile.Request {
+	labels := object.GetLabels()
+	name, namespace := labels[OwnerNameLabel], labels[OwnerNamespaceLabel]
+	if name == "" || namespace == "" {
+		return nil
+	}
+	return []reconcile.Request{
+		{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}},
+	}
+}
+
+// SecretMapFunc 除组件的secret外，LogFile引用的密码secret变化时也重新调谐
+func (r *LogFileReconciler) SecretMapFunc(object client.Object) []reconcile.Request {
+	requests := OwnerLabelsMapFunc(object)
+	logfiles := &apiv1.LogFileList{}
+	if err := r.List(context.Background(), logfiles, client.InNamespace(object.GetNamespace())); err != nil {
+		logger.Error(err, "list logfiles failed", "namespace", object.GetNamespace())
+		return requests
+	}
+	for _, logfile := range logfiles.Items {
+		for _, ref := range []*corev1.SecretKeySelector{logfile.Spec.ElasticPasswordSecretRef, logfile.Spec.KibanaPasswordSecretRef} {
+			if ref != nil && ref.Name == object.GetName() {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{Namespace: logfile.Namespace, Name: logfile.Name},
+				})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// CheckStackConflict 同一个namespace中只能部署一套组件，组件名称是固定的
+// 多个LogFile指向同一个namespace时，只有最早创建的生效
+func (r *LogFileReconciler) CheckStackConflict(ctx context.Context, logfile *apiv1.LogFile) (*apiv1.LogFile, error) {
+	logfiles := &apiv1.LogFileList{}
+	if err := r.List(ctx, logfiles); err != nil {
+		return nil, err
+	}
+	for i := range logfiles.Items {
+		other := &logfiles.Items[i]
+		if other.UID == logfile.UID || other.DeletionTimestamp != nil || StackNamespace(other) != StackNamespace(logfile) {
+			continue
+		}
+		if other.CreationTimestamp.Before(&logfile.CreationTimestamp) ||
+			(other.CreationTimestamp.Equal(&logfile.CreationTimestamp) && other.Namespace+"/"+other.Name < logfile.Namespace+"/"+logfile.Name) {
+			return other, nil
+		}
+	}
+	return nil, nil
+}
+
+// CleanupFinalizer 删除LogFile前按顺序停止组件，并按保留策略处理数据
+const CleanupFinalizer = "logfile.huisebug.org/cleanup"
+
+// DeleteComponents 删除组件namespace中属于该LogFile的资源，apps为空时删除全部组件
+func (r *LogFileReconciler) DeleteComponents(ctx context.Context, logfile *apiv1.LogFile, apps ...string) error {
+	customizelog := logger.WithValues("func", "DeleteComponents")
+
+	lists := []client.ObjectList{
+		&appsv1.DeploymentList{},
+		&appsv1.StatefulSetList{},
+		&policyv1.PodDisruptionBudgetList{},
+		&corev1.ServiceList{},
+		&corev1.ServiceAccountList{},
+		&corev1.ConfigMapList{},
+		&corev1.SecretList{},
+	}
+	selector := client.MatchingLabels{
+		OwnerNameLabel:      logfile.Name,
+		OwnerNamespaceLabel: logfile.Namespace,
+	}
+	for _, list := range lists {
+		if err := r.List(ctx, list, client.InNamespace(StackNamespace(logfile)), selector); err != nil {
+			return err
+		}
+		objects, err := apimeta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			object := object.(client.Object)
+			if len(apps) > 0 && !containsString(apps, object.GetLabels()["app"]) {
+				continue
+			}
+			if err := r.Delete(ctx, object, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			customizelog.Info("delete object", "kind", reflect.TypeOf(object).Elem().Name(), "name", object.GetNamespace()+"/"+object.GetName())
+		}
+	}
+	if len(apps) > 0 {
+		return nil
+	}
+	return r.DeleteCertificate(ctx, StackNamespace(logfile), CertsSecretName("elasticsearch-master"))
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSetOwnerReference(t *testing.T) {
+	r := newTestReconciler(t)
+	logfile := &apiv1.LogFile{ObjectMeta: metav1.ObjectMeta{Name: "logfile", Namespace: "team-a", UID: "logfile-uid"}}
+
+	// 同一namespace中设置ownerReferences，组件的labels同时用作selector，不能被修改
+	labels := map[string]string{"app": "kibana"}
+	local := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "kibana", Namespace: "team-a", Labels: labels}}
+	if err := SetOwnerReference(logfile, local, r.Scheme); err != nil {
+		t.Fatal(err)
+	}
+	if len(local.OwnerReferences) != 1 || local.OwnerReferences[0].UID != logfile.UID {
+		t.Errorf("OwnerReferences = %v, want the LogFile as controller", local.OwnerReferences)
+	}
+	if len(labels) != 1 {
+		t.Errorf("selector labels modified: %v", labels)
+	}
+
+	// 跨namespace时只记录标签，通过标签找到所属的LogFile
+	logfile.Spec.TargetNamespace = "logging"
+	remote := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "kibana", Namespace: StackNamespace(logfile), Labels: labels}}
+	if err := SetOwnerReference(logfile, remote, r.Scheme); err != nil {
+		t.Fatal(err)
+	}
+	if len(remote.OwnerReferences) != 0 {
+		t.Errorf("OwnerReferences = %v, want none across namespaces", remote.OwnerReferences)
+	}
+	requests := OwnerLabelsMapFunc(remote)
+	if len(requests) != 1 || requests[0].NamespacedName != (types.NamespacedName{Namespace: "team-a", Name: "logfile"}) {
+		t.Errorf("OwnerLabelsMapFunc() = %v, want team-a/logfile", requests)
+	}
+	if requests := OwnerLabelsMapFunc(&corev1.Service{}); requests != nil {
+		t.Errorf("OwnerLabelsMapFunc() = %v for an unlabeled object", requests)
+	}
+}
+
+func TestCheckStackConflict(t *testing.T) {
+	created := func(minutes int) metav1.Time {
+		return metav1.Date(2022, 11, 1, 0, minutes, 0, 0, metav1.Now().Location())
+	}
+	first := &apiv1.LogFile{
+		ObjectMeta: metav1.ObjectMeta{Name: "first", Namespace: "team-a", UID: "first", CreationTimestamp: created(0)},
+		Spec:       apiv1.LogFileSpec{TargetNamespace: "logging"},
+	}
+	second := &apiv1.LogFile{
+		ObjectMeta: metav1.ObjectMeta{Name: "second", Namespace: "team-b", UID: "second", CreationTimestamp: created(5)},
+		Spec:       apiv1.LogFileSpec{TargetNamespace: "logging"},
+	}
+	local := &apiv1.LogFile{ObjectMeta: metav1.ObjectMeta{Name: "local", Namespace: "team-c", UID: "local", CreationTimestamp: created(1)}}
+	r := newTestReconciler(t, first, second, local)
+	ctx := context.Background()
+
+	// 指向同一namespace的LogFile只有最早创建的生效
+	if conflict, err := r.CheckStackConflict(ctx, second); err != nil || conflict == nil || conflict.Name != "first" {
+		t.Errorf("CheckStackConflict(second) = %v, %v, want first", conflict, err)
+	}
+	for _, logfile := range []*apiv1.LogFile{first, local} {
+		if conflict, err := r.CheckStackConflict(ctx, logfile); err != nil || conflict != nil {
+			t.Errorf("CheckStackConflict(%s) = %v, %v, want no conflict", logfile.Name, conflict, err)
+		}
+	}
+}
+
+func TestStackAllowsNamespace(t *testing.T) {
+	teama := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "team-a"}}}
+	teamb := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"team": "team-b"}}}
+	selector := &corev1.ConfigMap{Data: map[string]string{AllowedNamespacesKey: "team=team-a"}}
+	legacy := &corev1.ConfigMap{Data: map[string]string{}}
+
+	// 其他团队的组件设置了allowedNamespaces时只允许匹配的namespace
+	if !StackAllowsNamespace(selector, teama, "logging-team-a") {
+		t.Errorf("team-a is rejected by its own stack")
+	}
+	if StackAllowsNamespace(selector, teamb, "logging-team-a") {
+		t.Errorf("team-b is allowed to use the team-a stack")
+	}
+	// 未设置allowedNamespaces时只有默认组件允许所有namespace
+	if StackAllowsNamespace(legacy, teamb, "logging-team-a") {
+		t.Errorf("team-b is allowed to use a stack without allowedNamespaces")
+	}
+	if !StackAllowsNamespace(legacy, teamb, DefaultStackNamespace) {
+		t.Errorf("team-b is rejected by the default stack")
+	}
+	if !StackAllowsNamespace(legacy, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "logging-team-a"}}, "logging-team-a") {
+		t.Errorf("stack namespace is rejected by its own stack")
+	}
+}
@@ -18,21 +18,22 @@ package controllers
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
 
-	"github.com/sirupsen/logrus"
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
 	"gopkg.in/yaml.v2"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -40,29 +41,78 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
-const annotationRegExpString = "logfile\\.huisebug\\.org\\/[a-zA-Z\\.]+"
+const annotationRegExpString = "logfile\\.huisebug\\.org\\/[a-zA-Z0-9\\.\\-_]+"
 
 type LogFileAnnotation struct {
 	annotationRegExp *regexp.Regexp
 }
 
 type AutoGenerated struct {
-	Filebeatinputs []Filebeatinputs `yaml:"filebeat.inputs"`
+	Filebeatinputs []Filebeatinputs         `yaml:"filebeat.inputs"`
+	Processors     []map[string]interface{} `yaml:"processors,omitempty"`
 }
 type Filebeatinputs struct {
-	Type  string   `yaml:"type"`
-	Paths []string `yaml:"paths"`
+	Type   string            `yaml:"type"`
+	Paths  []string          `yaml:"paths"`
+	Fields map[string]string `yaml:"fields,omitempty"`
+	// 多行日志，不匹配起始行正则的行追加到上一行之后
+	MultilinePattern string `yaml:"multiline.pattern,omitempty"`
+	MultilineNegate  bool   `yaml:"multiline.negate,omitempty"`
+	MultilineMatch   string `yaml:"multiline.match,omitempty"`
+	// 按json解析每一行，字段放在事件的顶层
+	JSONKeysUnderRoot bool                     `yaml:"json.keys_under_root,omitempty"`
+	JSONAddErrorKey   bool                     `yaml:"json.add_error_key,omitempty"`
+	IncludeLines      []string                 `yaml:"include_lines,omitempty"`
+	ExcludeLines      []string                 `yaml:"exclude_lines,omitempty"`
+	Processors        []map[string]interface{} `yaml:"processors,omitempty"`
 }
 
-func FilebeatfileGen(logfilepaths []string) string {
+func FilebeatfileGen(routes []LogRoute, metadata *KubernetesMetadata) string {
 
-	t := AutoGenerated{
-		Filebeatinputs: []Filebeatinputs{
-			Filebeatinputs{
-				Type:  "log",
-				Paths: logfilepaths,
-			},
-		},
+	t := AutoGenerated{}
+	if metadata != nil {
+		t.Processors = append(t.Processors, metadata.Processor())
+	}
+	for _, route := range routes {
+		input := Filebeatinputs{
+			Type:  "log",
+			Paths: route.Paths,
+		}
+		if route.Name != "" {
+			input.Fields = map[string]string{RouteField: route.Name}
+			if route.Index != "" {
+				input.Fields[RouteIndexField] = RoutePrefix + route.Index
+			}
+			if route.Topic != "" {
+				input.Fields[RouteTopicField] = RoutePrefix + route.Topic
+			}
+		}
+		if route.Multiline != "" {
+			input.MultilinePattern = route.Multiline
+			input.MultilineNegate = route.MultilineNegate == nil || *route.MultilineNegate
+			input.MultilineMatch = route.MultilineMatch
+			if input.MultilineMatch == "" {
+				input.MultilineMatch = "after"
+			}
+		}
+		if route.JSON {
+			input.JSONKeysUnderRoot = true
+			input.JSONAddErrorKey = true
+		}
+		input.IncludeLines = route.IncludeLines
+		input.ExcludeLines = route.ExcludeLines
+		if route.Container != "" {
+			input.Processors = append(input.Processors, map[string]interface{}{
+				"add_fields": map[string]interface{}{
+					"target": "kubernetes",
+					"fields": map[string]interface{}{
+						"container": map[string]interface{}{"name": route.Container},
+					},
+				},
+			})
+		}
+		input.Processors = append(input.Processors, route.Processors...)
+		t.Filebeatinputs = append(t.Filebeatinputs, input)
 	}
 
 	d, err := yaml.Marshal(&t)
@@ -92,48 +142,33 @@ func (l *LogFileAnnotation) filterAnnotations(annotations map[string]string) map
 const annotationDomainSeparator = "/"
 const annotationSubDomainSeparator = "."
 
-func parseMetrics(annotations map[string]string, podName string) []string {
-
+// parseMetrics 返回所有路由的日志文件路径
+func parseMetrics(routes []LogRoute) []string {
 	var metrics []string
-	// 循环注解从正则过滤后的注解
-	for metricKey, metricValue := range annotations {
-		// 以/为分隔符来拆分，判断key名是否长度为2,如果不是则不符合要求
-		keys := strings.Split(metricKey, annotationDomainSeparator)
-		if len(keys) != 2 {
-			logrus.Errorf("Metric annotation for %v  is invalid: %v", podName, metricKey)
-			return metrics
-		}
-		// 以.为分隔符来拆分索引0的域名，判断域名是否长度小于2,如果小于则不符合域名规范
-		metricSubDomains := strings.Split(keys[0], annotationSubDomainSeparator)
-		if len(metricSubDomains) < 2 {
-			logrus.Errorf("Metric annotation for  %v is invalid: %v", podName, metricKey)
-			return metrics
-		}
-		// 对域名的主机位进行判断，是否是想要的主机位进行开头
-		switch metricSubDomains[0] {
-		case "logfile":
-			// 以,为分隔符拆分多个日志文件路径
-			metricValueslice := strings.Split(metricValue, ",")
-			metrics = append(metrics, metricValueslice...)
-		}
-
+	for _, route := range routes {
+		metrics = append(metrics, route.Paths...)
 	}
-
 	return metrics
 }
 
 // PodSideCarMutate mutate Pods
 type PodSidecarMutate struct {
-	Client  client.Client
-	decoder *admission.Decoder
+	Client client.Client
+	Images ImageOptions
+	Policy InjectionPolicy
+	// 集群是否支持原生sidecar，启动时检测
+	NativeSidecarSupported bool
+	decoder                *admission.Decoder
 }
 
 //+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=api.huisebug.org,resources=logcollectors,verbs=get;list;watch
 
-func NewPodSideCarMutate(c client.Client) admission.Handler {
-	return &PodSidecarMutate{Client: c}
+func NewPodSideCarMutate(c client.Client, images ImageOptions, policy InjectionPolicy, nativeSidecarSupported bool) admission.Handler {
+	return &PodSidecarMutate{Client: c, Images: images, Policy: policy, NativeSidecarSupported: nativeSidecarSupported}
 }
 
 func Createk8sClientSet() *kubernetes.Clientset {
@@ -164,14 +199,214 @@ func Createk8sDiscoveryClient() *discovery.DiscoveryClient {
 	return discoveryClient
 }
 
-func Formatbase64string(secretdatavalue []byte) string {
-	// k8s存放的是2次base64编码后的，所以要转2次,第二次中存在了=号，要进行特别解码
-	one := base64.StdEncoding.EncodeToString(secretdatavalue)
-	// fmt.Println("one", one)
+// FilebeatCredentialsSecretName filebeat直接写入elasticsearch时，在pod所在namespace中使用的密码secret
+const FilebeatCredentialsSecretName = "logfile-filebeat-credentials"
+
+// MirrorFilebeatCredentials 将日志写入用户的密码复制到pod所在的namespace，secretKeyRef只能引用同namespace的secret
+// labels记录所属的LogFile，密码轮换后由调谐更新，删除LogFile时一并删除
+func MirrorFilebeatCredentials(ctx context.Context, clientset *kubernetes.Clientset, stacknamespace, namespace string, labels map[string]string) error {
+	source, err := clientset.CoreV1().Secrets(stacknamespace).Get(ctx, CredentialsSecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	return mirrorSecret(ctx, clientset, source, FilebeatCredentialsSecretName, namespace, labels)
+}
+
+// mirrorSecret 在namespace中创建或更新source的副本，不修改不是由operator创建的同名secret
+func mirrorSecret(ctx context.Context, clientset *kubernetes.Clientset, source *corev1.Secret, name, namespace string, labels map[string]string) error {
+	secrets := clientset.CoreV1().Secrets(namespace)
+	labels = MirrorLabels(labels, source.Name)
+	existing, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = secrets.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    labels,
+			},
+			Type: source.Type,
+			Data: MirrorData(source),
+		}, metav1.CreateOptions{})
+		if errors.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Labels["app.kubernetes.io/managed-by"] != "logfile-operator" {
+		return nil
+	}
+	// 旧版本创建的副本没有记录所属的LogFile，一并补上标签
+	updated := existing.DeepCopy()
+	for k, v := range labels {
+		updated.Labels[k] = v
+	}
+	updated.Data = MirrorData(source)
+	if reflect.DeepEqual(existing.Labels, updated.Labels) && reflect.DeepEqual(existing.Data, updated.Data) {
+		return nil
+	}
+	_, err = secrets.Update(ctx, updated, metav1.UpdateOptions{})
+	return err
+}
+
+// MirrorImagePullSecrets 将组件namespace中拉取镜像的secret复制到业务pod所在的namespace
+// 业务namespace中已存在同名且不是由operator创建的secret时不覆盖
+func MirrorImagePullSecrets(ctx context.Context, clientset *kubernetes.Clientset, stacknamespace, namespace string, names []string, labels map[string]string) error {
+	if stacknamespace == namespace {
+		return nil
+	}
+	for _, name := range names {
+		source, err := clientset.CoreV1().Secrets(stacknamespace).Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			// 组件namespace中没有时，由用户自行在业务namespace中创建
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := mirrorSecret(ctx, clientset, source, name, namespace, labels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StackLabel namespace上的标签或注解，值为日志组件所在的namespace，用于选择pod的日志写入哪一套组件
+const StackLabel = "logfile.huisebug.org/stack"
+
+// DefaultStackNamespace 兼容旧版本，未指定时使用的组件namespace
+const DefaultStackNamespace = "logfile-operator-system"
+
+// FindStackNamespace 查找pod的日志应写入的组件所在namespace
+// 优先使用namespace上的logfile.huisebug.org/stack标签或注解，其次是pod所在namespace中部署的组件，最后兼容旧版本的logfile-operator-system
+func FindStackNamespace(ctx context.Context, clientset *kubernetes.Clientset, ns *corev1.Namespace) (string, error) {
+	namespace := ns.Name
+	if stack := ns.Labels[StackLabel]; stack != "" {
+		return stack, nil
+	}
+	if stack := ns.Annotations[StackLabel]; stack != "" {
+		return stack, nil
+	}
+	_, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, "filebeat-sidecar", metav1.GetOptions{})
+	if err == nil {
+		return namespace, nil
+	}
+	if !errors.IsNotFound(err) {
+		return "", err
+	}
+	return DefaultStackNamespace, nil
+}
+
+// AllowedNamespacesKey filebeat-sidecar configmap中允许写入该组件的namespace标签选择器，由LogFile的allowedNamespaces生成
+const AllowedNamespacesKey = "allowed.namespaces"
 
-	two, _ := base64.RawStdEncoding.DecodeString(one)
-	// fmt.Println(string(two))
-	return string(two)
+// StackAllowsNamespace 组件是否允许namespace中的pod写入日志
+// 组件所在namespace始终允许；设置了allowedNamespaces时按标签选择器匹配；未设置时只有兼容旧版本的logfile-operator-system允许所有namespace，
+// 避免namespace通过logfile.huisebug.org/stack选择其他团队的组件后复制其密码和证书
+func StackAllowsNamespace(configmap *corev1.ConfigMap, ns *corev1.Namespace, stacknamespace string) bool {
+	if ns.Name == stacknamespace {
+		return true
+	}
+	value, ok := configmap.Data[AllowedNamespacesKey]
+	if !ok {
+		return stacknamespace == DefaultStackNamespace
+	}
+	selector, err := labels.Parse(value)
+	if err != nil {
+		logger.Info("invalid allowed namespaces selector", "namespace", stacknamespace, "selector", value, "error", err.Error())
+		return false
+	}
+	return selector.Matches(labels.Set(ns.Labels))
+}
+
+// MountLogDirs 将各路由日志文件所在的目录挂载到写入日志的容器和filebeat sidecar
+// 容器中已有覆盖该目录的挂载时sidecar挂载同一个卷，否则创建EmptyDir，不挂载到其他容器
+func MountLogDirs(pod *corev1.Pod, sidecar *corev1.Container, routes []LogRoute) {
+	// 日志文件所在的文件目录去重，记录每个目录对应的容器
+	// 未声明容器的路由按每个路径已有的挂载点确定容器，无法确定时与旧版本一样挂载到所有容器
+	dirs := []string{}
+	dircontainers := map[string][]string{}
+	for _, route := range routes {
+		for _, logpath := range route.Paths {
+			dir, _ := filepath.Split(logpath)
+			if _, ok := dircontainers[dir]; !ok {
+				dirs = append(dirs, dir)
+			}
+			containers := []string{route.Container}
+			if route.Container == "" {
+				containers = []string{MountedContainer(pod, logpath)}
+				if containers[0] == "" {
+					containers = []string{}
+					for _, container := range pod.Spec.Containers {
+						containers = append(containers, container.Name)
+					}
+				}
+			}
+			for _, container := range containers {
+				if !containsString(dircontainers[dir], container) {
+					dircontainers[dir] = append(dircontainers[dir], container)
+				}
+			}
+		}
+	}
+
+	for index, dir := range dirs {
+		mount := findVolumeMount(sidecar.VolumeMounts, dir)
+		if mount == nil {
+			// 判断写入日志的容器中此路径或上级路径是否已经进行挂载
+			for i := range pod.Spec.Containers {
+				if containsString(dircontainers[dir], pod.Spec.Containers[i].Name) {
+					if mount = findVolumeMount(pod.Spec.Containers[i].VolumeMounts, dir); mount != nil {
+						break
+					}
+				}
+			}
+		}
+		if mount == nil {
+			EmptyDir := corev1.Volume{
+				Name: "logfile-operator-" + strconv.Itoa(index),
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{},
+				},
+			}
+			pod.Spec.Volumes = append(pod.Spec.Volumes, EmptyDir)
+			mount = &corev1.VolumeMount{
+				Name:      EmptyDir.Name,
+				MountPath: dir,
+			}
+		} else {
+			mount = &corev1.VolumeMount{
+				Name:        mount.Name,
+				MountPath:   mount.MountPath,
+				SubPath:     mount.SubPath,
+				SubPathExpr: mount.SubPathExpr,
+			}
+		}
+
+		// 往写入日志的容器进行卷挂载，多个容器写入同一目录时共用一个卷
+		for i := range pod.Spec.Containers {
+			container := &pod.Spec.Containers[i]
+			if containsString(dircontainers[dir], container.Name) && findVolumeMount(container.VolumeMounts, dir) == nil {
+				container.VolumeMounts = append(container.VolumeMounts, *mount)
+			}
+		}
+		// 往sidecar容器进行卷挂载
+		if findVolumeMount(sidecar.VolumeMounts, dir) == nil {
+			sidecar.VolumeMounts = append(sidecar.VolumeMounts, *mount)
+		}
+	}
+}
+
+// findVolumeMount 返回覆盖目录的挂载点
+func findVolumeMount(mounts []corev1.VolumeMount, dir string) *corev1.VolumeMount {
+	for i := range mounts {
+		if strings.HasPrefix(dir, strings.TrimSuffix(mounts[i].MountPath, "/")+"/") {
+			return &mounts[i]
+		}
+	}
+	return nil
 }
 
 // PodSideCarMutate admits a pod if a specific annotation exists.
@@ -184,10 +419,39 @@ func (v *PodSidecarMutate) Handle(ctx context.Context, req admission.Request) ad
 	if err != nil {
 		return admission.Errored(http.StatusBadRequest, err)
 	}
-	// fmt.Printf("pod: %+v\n", pod)
-	fmt.Printf("pod元数据名称: %+v\n", pod.ObjectMeta.GenerateName)
-	fmt.Printf("pod的所有标签信息: %+v\n", pod.Labels)
-	fmt.Printf("pod的所有注释信息: %+v\n", pod.Annotations)
+	// 注入前的pod，用于生成只包含注入修改字段的patch
+	original := pod.DeepCopy()
+	// 只在创建pod时注入，更新请求不修改pod
+	if req.Operation != admissionv1.Create {
+		return admission.Allowed("只在创建pod时注入sidecar")
+	}
+	// webhook重复调用或已注入的pod不再修改
+	if Injected(pod) {
+		log.Printf("Namespace: %s; Pod: %s; 已注入sidecar; 跳过注入sidecar\n", req.Namespace, pod.ObjectMeta.GenerateName+pod.ObjectMeta.Name)
+		return admission.Allowed("已注入sidecar")
+	}
+	// 先检查注入策略，不注入的pod不再查询LogCollector和组件配置
+	if reason := v.Policy.Denied(req.Namespace); reason != "" {
+		log.Printf("Namespace: %s; %s; 跳过注入sidecar\n", req.Namespace, reason)
+		return admission.Allowed(reason)
+	}
+	ns := &corev1.Namespace{}
+	if err := v.Client.Get(ctx, client.ObjectKey{Name: req.Namespace}, ns); err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	// namespace的注入方式，开启注入时没有声明日志路径的pod使用默认路径
+	injection := NamespaceInjection(ns)
+	switch {
+	case injection == "":
+		log.Printf("Namespace: %s; 未设置Label: %s; 跳过注入sidecar\n", req.Namespace, InjectionLabel)
+		return admission.Allowed("namespace未开启注入")
+	case injection == InjectionDisabled:
+		log.Printf("Namespace: %s; 存在Label: %s: %s; 跳过注入sidecar\n", req.Namespace, InjectionLabel, InjectionDisabled)
+		return admission.Allowed("namespace关闭了注入")
+	case injection != InjectionForce && pod.Labels[InjectionLabel] == "false":
+		log.Printf("Namespace: %s; Pod: %s; 存在Label: %s: \"false\"; 跳过注入sidecar\n", req.Namespace, pod.ObjectMeta.GenerateName+pod.ObjectMeta.Name, InjectionLabel)
+		return admission.Allowed("pod关闭了注入")
+	}
 
 	lfa := new(LogFileAnnotation)
 	lfa = lfa.NewLogFileAnnotation()
@@ -195,20 +459,44 @@ func (v *PodSidecarMutate) Handle(ctx context.Context, req admission.Request) ad
 	// 获取过滤后的注解map
 	Annotations := lfa.filterAnnotations(pod.Annotations)
 	// 获取符合域名规则的注解
-	logfilepaths := parseMetrics(Annotations, pod.Name)
+	routes, routeerrs := parseRoutes(Annotations, req.Namespace, pod.ObjectMeta.GenerateName+pod.ObjectMeta.Name)
+	// 合并选择了pod的LogCollector中的采集规则
+	collectors := &apiv1.LogCollectorList{}
+	if err := v.Client.List(ctx, collectors, client.InNamespace(req.Namespace)); err != nil {
+		log.Printf("Namespace: %s; 查询LogCollector失败: %v; 只使用pod注解中的采集规则\n", req.Namespace, err)
+	}
+	matchedcollectors := MatchingCollectors(collectors.Items, pod)
+	routes = MergeCollectorRoutes(routes, matchedcollectors)
+	// 写入每条日志的pod信息
+	metadata, metadataerrs := parseKubernetesMetadata(pod, matchedcollectors)
+	routeerrs = append(routeerrs, metadataerrs...)
+	routes, containererrs := validateRouteContainers(routes, pod)
+	routeerrs = append(routeerrs, containererrs...)
+	sidecarmode, sidecarmodeerrs := v.Policy.SidecarMode(pod)
+	routeerrs = append(routeerrs, sidecarmodeerrs...)
+
+	// 注解写错时不使用默认路径
+	if len(routes) == 0 && len(routeerrs) == 0 {
+		routes = v.Policy.DefaultRoutes(ns)
+	}
+	logfilepaths := parseMetrics(routes)
 
 	// 使用ClientSet
 	clientset := Createk8sClientSet()
+	// 查找pod所在namespace对应的日志组件
+	stacknamespace, err := FindStackNamespace(ctx, clientset, ns)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
 	// 获取configmap
-	configmap, configmaperr := clientset.CoreV1().ConfigMaps("logfile-operator-system").Get(context.TODO(), "filebeat-sidecar", metav1.GetOptions{})
-	// fmt.Printf("configmap: %+v\n", configmap)
+	configmap, configmaperr := clientset.CoreV1().ConfigMaps(stacknamespace).Get(context.TODO(), "filebeat-sidecar", metav1.GetOptions{})
 
 	configmapstatus := func(configmap *corev1.ConfigMap, configmaperr error) bool {
 		if errors.IsNotFound(configmaperr) {
 			return false
 		}
 		if _, ok := configmap.Data["filebeat.yml"]; ok {
-			if _, ok := configmap.Data["programmenumber"]; ok {
+			if _, ok := configmap.Data["elasticsearch.mode"]; ok {
 				return true
 			}
 		}
@@ -216,226 +504,169 @@ func (v *PodSidecarMutate) Handle(ctx context.Context, req admission.Request) ad
 
 	}(configmap, configmaperr)
 
+	// 注解写错的路由已跳过，通过警告提示创建pod的用户
+	warnings := []string{}
+	for _, routeerr := range routeerrs {
+		warnings = append(warnings, "logfile-operator: 跳过不合法的注解: "+routeerr.Error())
+	}
+	// native模式注入的filebeat init容器名称
+	nativesidecar := ""
+	// 是否注入了sidecar，没有注入时不修改pod
+	injected := false
 	switch {
-	case pod.Labels["pod-admission-webhook-injection"] == "false":
-		Tips := fmt.Sprintf("Namespace: %s; Pod: %s; 存在Label: pod-admission-webhook-injection: \"false\"; 跳过注入sidecar", pod.Namespace, pod.ObjectMeta.Name)
-		log.Println(Tips)
+	case len(routeerrs) > 0 && v.Policy.RejectInvalidAnnotations:
+		// 注解写错时拒绝创建pod，避免日志静默丢失
+		return admission.Denied(routeerrs.ToAggregate().Error())
 	case len(logfilepaths) == 0:
-		Tips := fmt.Sprintf("Namespace: %s; Pod: %s; 未在注释中声明: logfile.huisebug.org字段: \"容器日志文件路径1,容器日志文件路径2\"; 跳过注入sidecar", pod.Namespace, pod.ObjectMeta.Name)
+		Tips := fmt.Sprintf("Namespace: %s; Pod: %s; 未在注释中声明: logfile.huisebug.org字段: \"容器日志文件路径1,容器日志文件路径2\", 也没有选择该pod的LogCollector; 跳过注入sidecar", req.Namespace, pod.ObjectMeta.GenerateName+pod.ObjectMeta.Name)
 		log.Println(Tips)
 	case !configmapstatus:
-		log.Println("未查询到: logfile-operator-system; configmap: filebeat-sidecar 中键值为:filebeat.yml和programmenumber的数据; 跳过注入sidecar")
+		log.Printf("未查询到: %s; configmap: filebeat-sidecar 中键值为:filebeat.yml和elasticsearch.mode的数据; 跳过注入sidecar\n", stacknamespace)
+	case !StackAllowsNamespace(configmap, ns, stacknamespace):
+		// 组件没有允许该namespace写入时不复制组件的密码、拉取镜像的secret和ca证书
+		log.Printf("Namespace: %s; 组件namespace %s 的allowedNamespaces不包含该namespace; 跳过注入sidecar\n", req.Namespace, stacknamespace)
+		warnings = append(warnings, fmt.Sprintf("logfile-operator: 日志组件namespace %s 不允许namespace %s 写入日志, 未注入sidecar", stacknamespace, req.Namespace))
 	default:
 
-		confdir := corev1.Volume{
-			Name: "confdir",
-			VolumeSource: corev1.VolumeSource{
-				EmptyDir: &corev1.EmptyDirVolumeSource{},
-			},
+		// sidecar镜像由组件的configmap传递，旧版本的configmap中没有时使用operator的镜像配置
+		filebeatimage := configmap.Data["filebeat.image"]
+		if filebeatimage == "" {
+			filebeatimage = v.Images.Image(nil, apiv1.ComponentFilebeat)
 		}
-		pod.Spec.Volumes = append(pod.Spec.Volumes, confdir)
-		// 获取日志文件所在的文件目录
-		logfilepath_dirs := func(logfilepaths []string) []string {
-			tmplist := []string{}
-			for _, logfilepath := range logfilepaths {
-				logfilepath_dir, _ := filepath.Split(logfilepath)
-				tmplist = append(tmplist, logfilepath_dir)
-			}
-			return tmplist
-		}(logfilepaths)
-
-		// 日志文件所在的文件目录去重
-		logfilepath_dirs = func(slc []string) []string {
-			result := []string{}
-			tempMap := map[string]byte{} // 存放不重复主键
-			for _, e := range slc {
-				l := len(tempMap)
-				tempMap[e] = 0
-				if len(tempMap) != l { // 加入map后，map长度变化，则元素不重复
-					result = append(result, e)
+		pullsecrets, ok := configmap.Data["image.pullsecrets"]
+		if !ok {
+			pullsecrets = strings.Join(v.Images.PullSecrets, ",")
+		}
+		// 拉取镜像的secret需要在pod所在的namespace中
+		if names := ParseImagePullSecrets(pullsecrets); len(names) > 0 {
+			if req.DryRun == nil || !*req.DryRun {
+				if err := MirrorImagePullSecrets(ctx, clientset, stacknamespace, req.Namespace, names, configmap.Labels); err != nil {
+					return admission.Errored(http.StatusInternalServerError, err)
 				}
 			}
-			return result
-		}(logfilepath_dirs)
-
-		// 临时给需要注入的服务也增加filebeat的配置文件目录
-		pod.Spec.Containers[0].VolumeMounts = append(pod.Spec.Containers[0].VolumeMounts,
-			corev1.VolumeMount{
-				Name:      "confdir",
-				MountPath: "/etc/filebeat/",
-			},
-		)
-
-		// 生成filebeat配置文件所需的执行命令,配置由日志文件路径和configmap中配置输出位置组成
-		commandline := fmt.Sprintf(`
-echo '
-%s
-%s
-' > /etc/filebeat/filebeat.yml
-`, FilebeatfileGen(logfilepaths), configmap.Data["filebeat.yml"])
-
-		// 利用initcontainer生成filebeat的配置文件
-		sidecarinitcontainer := corev1.Container{
-			Name:            "genfilebeatyml",
-			Image:           "registry.cn-hangzhou.aliyuncs.com/huisebug/logfile-operator:filebeat-8.5.0",
-			ImagePullPolicy: corev1.PullIfNotPresent,
-			VolumeMounts: []corev1.VolumeMount{
-				{
-					Name:      "confdir",
-					MountPath: "/etc/filebeat/",
-				},
-			},
-			Command: []string{
-				"/bin/bash",
-				"-c",
-			},
-			Args: []string{
-				commandline,
+			for _, name := range names {
+				pod.Spec.ImagePullSecrets = appendPullSecret(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: name})
+			}
+		}
+
+		// 未声明容器的路由按注入前的挂载点确定日志所属的容器，用于写入日志的容器信息
+		for i := range routes {
+			if routes[i].Container == "" {
+				routes[i].Container = RouteContainer(pod, routes[i].Paths)
+			}
+		}
+
+		// filebeat配置由日志文件路径和configmap中配置输出位置组成，写入pod注解后通过Downward API挂载，不经过shell
+		filebeatconfig := FilebeatfileGen(routes, metadata) + "\n" + configmap.Data["filebeat.yml"]
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[SidecarConfigAnnotation] = filebeatconfig
+		confdir := AnnotationVolume("confdir", SidecarConfigAnnotation, "filebeat.yml")
+		// filebeat使用只读根文件系统，registry写入EmptyDir
+		filebeatdata := corev1.Volume{
+			Name: "filebeat-data",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
 			},
 		}
+		pod.Spec.Volumes = append(pod.Spec.Volumes, confdir, filebeatdata)
+		// sidecar的安全配置，namespace注解优先，其次是LogFile中的配置
+		securityprofile, runasuser := SidecarSecurityProfile(ns, configmap)
 
 		// sidecar注入容器的信息
-		Privileged := true
 		sidecarcontainer := corev1.Container{
-			SecurityContext: &corev1.SecurityContext{
-				Privileged: &Privileged,
-			},
+			SecurityContext: SidecarSecurityContext(securityprofile, runasuser),
 			Name:            "filebeat",
-			Image:           "registry.cn-hangzhou.aliyuncs.com/huisebug/logfile-operator:filebeat-8.5.0",
+			Image:           filebeatimage,
 			ImagePullPolicy: corev1.PullIfNotPresent,
 			VolumeMounts: []corev1.VolumeMount{
 				{
 					Name:      "confdir",
 					MountPath: "/etc/filebeat/",
+					ReadOnly:  true,
+				},
+				{
+					Name:      "filebeat-data",
+					MountPath: FilebeatDataPath,
 				},
 			},
 			Args: []string{"-e", "-c", "/etc/filebeat/filebeat.yml"},
+			// pod名称、节点等通过Downward API传递，由filebeat写入每条日志
+			Env: metadata.Env(),
 		}
 
-		// 循环所有的日志文件目录，将文件目录都创建EmptyDir卷声明和挂载到将要sidecar注入的filebeat容器中
-		for index, logfilepath_dir := range logfilepath_dirs {
-
-			// 判断此路径是否已经进行挂载,如果已经挂载就返回其挂载信息
-			Duplicate_mount_point := func(l string, pod *corev1.Pod) []corev1.VolumeMount {
-				VolumeMounts := []corev1.VolumeMount{}
-				for _, container := range pod.Spec.Containers {
-
-					for _, VolumeMount := range container.VolumeMounts {
-						// 判断此路径是否已经是挂载路径
-						// 判断是否以 / 结尾
-						if strings.HasSuffix(VolumeMount.MountPath, "/") {
-							if l == VolumeMount.MountPath {
-								VolumeMounts = append(VolumeMounts, VolumeMount)
-							}
-						} else {
-							if l == VolumeMount.MountPath+"/" {
-								VolumeMounts = append(VolumeMounts, VolumeMount)
-							}
-						}
-
-					}
-
-				}
-				return VolumeMounts
+		// 日志文件目录只挂载到写入日志的容器和sidecar
+		MountLogDirs(pod, &sidecarcontainer, routes)
 
-			}(logfilepath_dir, pod)
-
-			log.Printf("日志文件目录：%s; 重复挂载点列表Duplicate_mount_point: %+v\n", logfilepath_dir, Duplicate_mount_point)
-
-			// 如果挂载重复挂载点列表长度为0，说明没有重复挂载点，就需要创建EmptyDir
-			if len(Duplicate_mount_point) == 0 {
-				EmptyDir := corev1.Volume{
-					Name: "logfile-operator-" + strconv.Itoa(index),
-					VolumeSource: corev1.VolumeSource{
-						EmptyDir: &corev1.EmptyDirVolumeSource{},
-					},
-				}
-				pod.Spec.Volumes = append(pod.Spec.Volumes, EmptyDir)
-				VolumeMount := corev1.VolumeMount{
-					Name:      "logfile-operator-" + strconv.Itoa(index),
-					MountPath: logfilepath_dir,
+		// filebeat直接写入elasticsearch时，通过同namespace的secret获取日志写入用户的密码
+		if configmap.Data["kafka.mode"] == apiv1.ModeNone && configmap.Data["logstash.enabled"] == "false" {
+			// dryRun请求不能产生副作用，不创建secret
+			if req.DryRun == nil || !*req.DryRun {
+				if err := MirrorFilebeatCredentials(ctx, clientset, stacknamespace, req.Namespace, configmap.Labels); err != nil {
+					return admission.Errored(http.StatusInternalServerError, err)
 				}
-				// 往将要创建pod中默认的容器列表都进行卷挂载
-				for c_index, _ := range pod.Spec.Containers {
-					pod.Spec.Containers[c_index].VolumeMounts = append(pod.Spec.Containers[c_index].VolumeMounts, VolumeMount)
-				}
-				// 往sidecar容器进行卷挂载
-				sidecarcontainer.VolumeMounts = append(sidecarcontainer.VolumeMounts, VolumeMount)
-			} else {
-				// 往sidecar容器进行卷挂载
-				sidecarcontainer.VolumeMounts = append(sidecarcontainer.VolumeMounts, Duplicate_mount_point...)
 			}
-
+			sidecarcontainer.Env = append(sidecarcontainer.Env, corev1.EnvVar{
+				Name:      "LOGFILE_ES_PASSWORD",
+				ValueFrom: SecretKeyRef(FilebeatCredentialsSecretName, WriterPasswordKey),
+			})
 		}
 
-		// 获取方案的序号
-		programmenumber := configmap.Data["programmenumber"]
-		if programmenumber == "2" {
-			secret, _ := clientset.CoreV1().Secrets("logfile-operator-system").Get(context.TODO(), "elasticsearch-master-certs", metav1.GetOptions{})
-			// fmt.Printf("secret: %+v\n", secret)
-
-			// 生成方案2时，filebeat需要使用es8集群的https证书
-			elasticsearchcerts := corev1.Volume{
-				Name: "elasticsearch-master-certs",
-				VolumeSource: corev1.VolumeSource{
-					EmptyDir: &corev1.EmptyDirVolumeSource{},
-				},
+		// filebeat直接写入集群模式的elasticsearch时，需要使用es8集群的https证书
+		if configmap.Data["elasticsearch.mode"] == apiv1.ModeCluster && configmap.Data["kafka.mode"] == apiv1.ModeNone && configmap.Data["logstash.enabled"] == "false" {
+			secret, err := clientset.CoreV1().Secrets(stacknamespace).Get(ctx, CertsSecretName("elasticsearch-master"), metav1.GetOptions{})
+			if err != nil {
+				return admission.Errored(http.StatusInternalServerError, err)
 			}
-			pod.Spec.Volumes = append(pod.Spec.Volumes, elasticsearchcerts)
 
-			commandline := fmt.Sprintf(`
-echo '
-%s
-' > /usr/share/elasticsearch/config/certs/tls.crt \
-&& echo '
-%s
-' > /usr/share/elasticsearch/config/certs/tls.key \
-&& echo '
-%s
-' > /usr/share/elasticsearch/config/certs/ca.crt
-`, Formatbase64string(secret.Data["tls.crt"]), Formatbase64string(secret.Data["tls.key"]), Formatbase64string(secret.Data["ca.crt"]))
-
-			// fmt.Println(Formatbase64string(secret.Data["ca.crt"]))
-
-			// 利用initcontainer生成filebeat的配置文件
-			eshttpscrtinitcontainer := corev1.Container{
-				Name:            "genesclusterhttps",
-				Image:           "debian:stretch-slim",
-				ImagePullPolicy: corev1.PullIfNotPresent,
-				VolumeMounts: []corev1.VolumeMount{
-					{
-						Name:      "elasticsearch-master-certs",
-						MountPath: "/usr/share/elasticsearch/config/certs",
-					},
-				},
-				Command: []string{
-					"/bin/bash",
-					"-c",
-				},
-				Args: []string{
-					commandline,
-				},
-			}
-			// 将新增的容器加入到pod中
-			pod.Spec.InitContainers = append(pod.Spec.InitContainers, eshttpscrtinitcontainer)
+			// filebeat只需要校验服务端证书，不下发elasticsearch节点的私钥
+			pod.Annotations[ElasticsearchCAAnnotation] = string(secret.Data["ca.crt"])
+			elasticsearchcerts := AnnotationVolume("elasticsearch-master-certs", ElasticsearchCAAnnotation, "ca.crt")
+			pod.Spec.Volumes = append(pod.Spec.Volumes, elasticsearchcerts)
 			// 给filebeat容器挂载上elasticsearch的https证书
 			sidecarcontainer.VolumeMounts = append(sidecarcontainer.VolumeMounts, corev1.VolumeMount{
 				Name:      "elasticsearch-master-certs",
 				MountPath: "/usr/share/elasticsearch/config/certs",
+				ReadOnly:  true,
 			})
 		}
 
 		// 将新增的容器加入到pod中
-		pod.Spec.InitContainers = append(pod.Spec.InitContainers, sidecarinitcontainer)
-		pod.Spec.Containers = append(pod.Spec.Containers, sidecarcontainer)
+		switch {
+		case sidecarmode == SidecarModeNative && v.NativeSidecarSupported:
+			// filebeat在已有的init容器之后启动，业务容器都退出后由kubelet停止
+			NativeSidecar(pod, &sidecarcontainer, false)
+			pod.Spec.InitContainers = append(pod.Spec.InitContainers, sidecarcontainer)
+			nativesidecar = sidecarcontainer.Name
+		case sidecarmode == SidecarModeNative:
+			log.Printf("Namespace: %s; Pod: %s; 集群不支持原生sidecar; 业务容器都退出后停止filebeat\n", req.Namespace, pod.ObjectMeta.GenerateName+pod.ObjectMeta.Name)
+			NativeSidecar(pod, &sidecarcontainer, true)
+			pod.Spec.Containers = append(pod.Spec.Containers, sidecarcontainer)
+		default:
+			pod.Spec.Containers = append(pod.Spec.Containers, sidecarcontainer)
+		}
+
+		// 记录注入的配置，webhook重复调用时不再注入
+		pod.Annotations[InjectedAnnotation] = SidecarConfigHash(filebeatconfig)
+		injected = true
+	}
+	if !injected {
+		return admission.Allowed("未注入sidecar").WithWarnings(warnings...)
 	}
 
-	marshaledPod, err := json.Marshal(pod)
+	patches, err := PodPatch(original, pod, nativesidecar)
 	if err != nil {
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
-
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledPod)
+	patchtype := admissionv1.PatchTypeJSONPatch
+	return admission.Response{
+		Patches: patches,
+		AdmissionResponse: admissionv1.AdmissionResponse{
+			Allowed:   true,
+			PatchType: &patchtype,
+		},
+	}.WithWarnings(warnings...)
 }
 
 // PodSideCarMutate 实现 admission.DecoderInjector。
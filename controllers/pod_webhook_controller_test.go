@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestMountLogDirs(t *testing.T) {
+	data := corev1.VolumeMount{Name: "data", MountPath: "/data"}
+	nginxlogs := corev1.VolumeMount{Name: "nginx-logs", MountPath: "/var/log/nginx"}
+	tests := []struct {
+		name    string
+		mounts  map[string][]corev1.VolumeMount
+		routes  []LogRoute
+		volumes int
+		// 挂载后各容器的挂载点，filebeat为sidecar
+		expected map[string][]corev1.VolumeMount
+	}{
+		{
+			name:    "container route",
+			routes:  []LogRoute{{Paths: []string{"/var/log/nginx/access.log"}, Container: "nginx"}},
+			volumes: 1,
+			expected: map[string][]corev1.VolumeMount{
+				"app":      nil,
+				"nginx":    {{Name: "logfile-operator-0", MountPath: "/var/log/nginx/"}},
+				"filebeat": {{Name: "logfile-operator-0", MountPath: "/var/log/nginx/"}},
+			},
+		},
+		{
+			name:    "existing mount",
+			mounts:  map[string][]corev1.VolumeMount{"app": {data}},
+			routes:  []LogRoute{{Paths: []string{"/data/logs/app.log"}, Container: "app"}},
+			volumes: 0,
+			expected: map[string][]corev1.VolumeMount{
+				"app":      {data},
+				"nginx":    nil,
+				"filebeat": {data},
+			},
+		},
+		{
+			name:    "mounted paths of two containers",
+			mounts:  map[string][]corev1.VolumeMount{"app": {data}, "nginx": {nginxlogs}},
+			routes:  []LogRoute{{Paths: []string{"/data/logs/app.log", "/var/log/nginx/access.log"}}},
+			volumes: 0,
+			expected: map[string][]corev1.VolumeMount{
+				"app":      {data},
+				"nginx":    {nginxlogs},
+				"filebeat": {data, nginxlogs},
+			},
+		},
+		{
+			name:    "unannotated paths of two containers",
+			mounts:  map[string][]corev1.VolumeMount{"app": {data}},
+			routes:  []LogRoute{{Paths: []string{"/data/logs/app.log", "/var/log/nginx/access.log"}}},
+			volumes: 1,
+			expected: map[string][]corev1.VolumeMount{
+				"app":      {data, {Name: "logfile-operator-1", MountPath: "/var/log/nginx/"}},
+				"nginx":    {{Name: "logfile-operator-1", MountPath: "/var/log/nginx/"}},
+				"filebeat": {data, {Name: "logfile-operator-1", MountPath: "/var/log/nginx/"}},
+			},
+		},
+		{
+			name: "shared directory",
+			routes: []LogRoute{
+				{Paths: []string{"/var/log/shared/app.log"}, Container: "app"},
+				{Paths: []string{"/var/log/shared/nginx.log"}, Container: "nginx"},
+			},
+			volumes: 1,
+			expected: map[string][]corev1.VolumeMount{
+				"app":      {{Name: "logfile-operator-0", MountPath: "/var/log/shared/"}},
+				"nginx":    {{Name: "logfile-operator-0", MountPath: "/var/log/shared/"}},
+				"filebeat": {{Name: "logfile-operator-0", MountPath: "/var/log/shared/"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "app", VolumeMounts: tt.mounts["app"]},
+				{Name: "nginx", VolumeMounts: tt.mounts["nginx"]},
+			}}}
+			sidecar := &corev1.Container{Name: "filebeat"}
+			MountLogDirs(pod, sidecar, tt.routes)
+			if len(pod.Spec.Volumes) != tt.volumes {
+				t.Errorf("volumes = %+v, want %d", pod.Spec.Volumes, tt.volumes)
+			}
+			for _, container := range append(pod.Spec.Containers, *sidecar) {
+				if !reflect.DeepEqual(container.VolumeMounts, tt.expected[container.Name]) {
+					t.Errorf("%s mounts = %+v, want %+v", container.Name, container.VolumeMounts, tt.expected[container.Name])
+				}
+			}
+		})
+	}
+}
+
+func TestRouteContainer(t *testing.T) {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "app"},
+		{Name: "nginx", VolumeMounts: []corev1.VolumeMount{{Name: "logs", MountPath: "/var/log/nginx"}}},
+	}}}
+	tests := []struct {
+		name      string
+		paths     []string
+		container string
+	}{
+		{name: "mounted by container", paths: []string{"/var/log/nginx/access.log"}, container: "nginx"},
+		{name: "not mounted", paths: []string{"/var/log/app/app.log"}},
+		{name: "mount path prefix only", paths: []string{"/var/log/nginx-extra/a.log"}},
+		{name: "partly mounted", paths: []string{"/var/log/nginx/access.log", "/var/log/app/app.log"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if container := RouteContainer(pod, tt.paths); container != tt.container {
+				t.Errorf("RouteContainer() = %s, want %s", container, tt.container)
+			}
+		})
+	}
+}
+
+func TestFilebeatfileGenRoutes(t *testing.T) {
+	routes := []LogRoute{
+		{Paths: []string{"/var/log/app.log"}},
+		{Name: "audit", Paths: []string{"/var/log/audit/*.log"}, Index: "team-a-audit", Topic: "team-a-audit"},
+		{Name: "access", Paths: []string{"/var/log/nginx/access.log"}},
+	}
+	config := struct {
+		Inputs []struct {
+			Paths  []string          `yaml:"paths"`
+			Fields map[string]string `yaml:"fields"`
+		} `yaml:"filebeat.inputs"`
+	}{}
+	if err := yaml.Unmarshal([]byte(FilebeatfileGen(routes, nil)), &config); err != nil {
+		t.Fatal(err)
+	}
+	expected := []map[string]string{
+		nil,
+		{RouteField: "audit", RouteIndexField: RoutePrefix + "team-a-audit", RouteTopicField: RoutePrefix + "team-a-audit"},
+		{RouteField: "access"},
+	}
+	if len(config.Inputs) != len(routes) {
+		t.Fatalf("inputs = %+v, want %d", config.Inputs, len(routes))
+	}
+	for i, input := range config.Inputs {
+		if !reflect.DeepEqual(input.Paths, routes[i].Paths) {
+			t.Errorf("input %d paths = %v, want %v", i, input.Paths, routes[i].Paths)
+		}
+		if len(input.Fields) > 0 || expected[i] != nil {
+			if !reflect.DeepEqual(input.Fields, expected[i]) {
+				t.Errorf("input %d fields = %v, want %v", i, input.Fields, expected[i])
+			}
+		}
+	}
+}
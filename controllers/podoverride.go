@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ApplyPodOverride 将LogFile中组件的pod配置合并到生成的pod模板上
+// container为需要覆盖资源和环境变量的主容器名称
+func ApplyPodOverride(template *corev1.PodTemplateSpec, override *apiv1.PodOverride, container string) {
+	if override == nil {
+		return
+	}
+	podspec := &template.Spec
+
+	if override.NodeSelector != nil {
+		podspec.NodeSelector = mergeStringMap(podspec.NodeSelector, override.NodeSelector)
+	}
+	podspec.Tolerations = append(podspec.Tolerations, override.Tolerations...)
+	if override.Affinity != nil {
+		if podspec.Affinity == nil {
+			podspec.Affinity = &corev1.Affinity{}
+		}
+		if override.Affinity.NodeAffinity != nil {
+			podspec.Affinity.NodeAffinity = override.Affinity.NodeAffinity
+		}
+		if override.Affinity.PodAffinity != nil {
+			podspec.Affinity.PodAffinity = override.Affinity.PodAffinity
+		}
+		if override.Affinity.PodAntiAffinity != nil {
+			podspec.Affinity.PodAntiAffinity = override.Affinity.PodAntiAffinity
+		}
+	}
+	if override.PriorityClassName != "" {
+		podspec.PriorityClassName = override.PriorityClassName
+	}
+
+	// 模板的labels与selector是同一个map，需要复制后再修改
+	if len(override.Labels) > 0 {
+		labels := mergeStringMap(nil, template.Labels)
+		for k, v := range override.Labels {
+			if containsString(apiv1.PodSelectorLabels, k) {
+				continue
+			}
+			labels[k] = v
+		}
+		template.Labels = labels
+	}
+	if len(override.Annotations) > 0 {
+		template.Annotations = mergeStringMap(mergeStringMap(nil, template.Annotations), override.Annotations)
+	}
+
+	for i := range podspec.Containers {
+		c := &podspec.Containers[i]
+		if c.Name != container {
+			continue
+		}
+		if override.Resources != nil {
+			c.Resources = *override.Resources.DeepCopy()
+		}
+		for _, env := range override.Env {
+			c.Env = setEnvVar(c.Env, env)
+		}
+	}
+}
+
+// ApplyContainerOverride 只覆盖容器的资源和环境变量，用于与其他组件共用pod的容器
+func ApplyContainerOverride(template *corev1.PodTemplateSpec, override *apiv1.PodOverride, container string) {
+	if override == nil {
+		return
+	}
+	ApplyPodOverride(template, &apiv1.PodOverride{Resources: override.Resources, Env: override.Env}, container)
+}
+
+// setEnvVar 按名称替换环境变量，不存在时追加
+func setEnvVar(envs []corev1.EnvVar, env corev1.EnvVar) []corev1.EnvVar {
+	for i := range envs {
+		if envs[i].Name == env.Name {
+			envs[i] = env
+			return envs
+		}
+	}
+	return append(envs, env)
+}
+
+func mergeStringMap(dst, src map[string]string) map[string]string {
+	if dst == nil {
+		dst = map[string]string{}
+	}
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
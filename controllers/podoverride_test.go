@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestApplyPodOverride(t *testing.T) {
+	selector := map[string]string{"app": "elasticsearch", "logfile-operator": "logfile"}
+	defaultAntiAffinity := &corev1.PodAntiAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{Weight: 100}}}
+	template := &corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: selector},
+		Spec: corev1.PodSpec{
+			NodeSelector: map[string]string{"kubernetes.io/os": "linux"},
+			Tolerations:  []corev1.Toleration{{Key: "default"}},
+			Affinity:     &corev1.Affinity{PodAntiAffinity: defaultAntiAffinity},
+			Containers: []corev1.Container{
+				{Name: "elasticsearch", Env: []corev1.EnvVar{{Name: "ES_JAVA_OPTS", Value: "-Xmx1g -Xms1g"}, {Name: "cluster.name", Value: "logfile"}}},
+				{Name: "exporter"},
+			},
+		},
+	}
+	resources := &corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("8Gi")}}
+	nodeaffinity := &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{}}
+
+	ApplyPodOverride(template, &apiv1.PodOverride{
+		Resources:         resources,
+		Env:               []corev1.EnvVar{{Name: "ES_JAVA_OPTS", Value: "-Xmx4g -Xms4g"}, {Name: "TZ", Value: "Asia/Shanghai"}},
+		NodeSelector:      map[string]string{"node-role.kubernetes.io/logging": ""},
+		Tolerations:       []corev1.Toleration{{Key: "dedicated"}},
+		Affinity:          &corev1.Affinity{NodeAffinity: nodeaffinity},
+		PriorityClassName: "logging-critical",
+		Labels:            map[string]string{"app": "other", "team": "sre"},
+		Annotations:       map[string]string{"prometheus.io/scrape": "true"},
+	}, "elasticsearch")
+
+	spec := template.Spec
+	if !reflect.DeepEqual(spec.NodeSelector, map[string]string{"kubernetes.io/os": "linux", "node-role.kubernetes.io/logging": ""}) {
+		t.Errorf("nodeSelector = %v, want merged with the default", spec.NodeSelector)
+	}
+	if len(spec.Tolerations) != 2 || spec.Tolerations[1].Key != "dedicated" {
+		t.Errorf("tolerations = %v, want appended after the default", spec.Tolerations)
+	}
+	// 只设置nodeAffinity时保留默认的podAntiAffinity
+	if spec.Affinity.NodeAffinity != nodeaffinity || spec.Affinity.PodAntiAffinity != defaultAntiAffinity {
+		t.Errorf("affinity = %+v", spec.Affinity)
+	}
+	if spec.PriorityClassName != "logging-critical" {
+		t.Errorf("priorityClassName = %s", spec.PriorityClassName)
+	}
+	// 用作selector的标签不能被覆盖，selector本身不被修改
+	if template.Labels["app"] != "elasticsearch" || template.Labels["team"] != "sre" || selector["team"] != "" {
+		t.Errorf("labels = %v, selector = %v", template.Labels, selector)
+	}
+	if template.Annotations["prometheus.io/scrape"] != "true" {
+		t.Errorf("annotations = %v", template.Annotations)
+	}
+
+	main, exporter := spec.Containers[0], spec.Containers[1]
+	if !reflect.DeepEqual(main.Resources, *resources) {
+		t.Errorf("resources = %+v", main.Resources)
+	}
+	expectedenv := []corev1.EnvVar{{Name: "ES_JAVA_OPTS", Value: "-Xmx4g -Xms4g"}, {Name: "cluster.name", Value: "logfile"}, {Name: "TZ", Value: "Asia/Shanghai"}}
+	if !reflect.DeepEqual(main.Env, expectedenv) {
+		t.Errorf("env = %v, want %v", main.Env, expectedenv)
+	}
+	if len(exporter.Env) != 0 || len(exporter.Resources.Limits) != 0 {
+		t.Errorf("override applied to the exporter container: %+v", exporter)
+	}
+}
+
+func TestApplyContainerOverride(t *testing.T) {
+	template := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "zookeeper"}}}}
+	ApplyContainerOverride(template, &apiv1.PodOverride{
+		Env:          []corev1.EnvVar{{Name: "JVMFLAGS", Value: "-Xmx512m"}},
+		NodeSelector: map[string]string{"disk": "ssd"},
+	}, "zookeeper")
+	// 共用pod的容器只覆盖资源和环境变量
+	if len(template.Spec.NodeSelector) != 0 {
+		t.Errorf("nodeSelector = %v, want unchanged", template.Spec.NodeSelector)
+	}
+	if len(template.Spec.Containers[0].Env) != 1 {
+		t.Errorf("env = %v", template.Spec.Containers[0].Env)
+	}
+	ApplyContainerOverride(template, nil, "zookeeper")
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// StatefulSetReady 判断statefulset的所有副本是否都已更新到最新版本并就绪
+func (r *LogFileReconciler) StatefulSetReady(ctx context.Context, name types.NamespacedName) (bool, error) {
+	statefulset := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, name, statefulset); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	replicas := int32(1)
+	if statefulset.Spec.Replicas != nil {
+		replicas = *statefulset.Spec.Replicas
+	}
+	return statefulset.Status.ObservedGeneration >= statefulset.Generation &&
+		statefulset.Status.ReadyReplicas == replicas &&
+		statefulset.Status.UpdatedReplicas == replicas, nil
+}
+
+// DeploymentReady 判断deployment的所有副本是否都已更新到最新版本并可用
+func (r *LogFileReconciler) DeploymentReady(ctx context.Context, name types.NamespacedName) (bool, error) {
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, name, deployment); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.AvailableReplicas == replicas &&
+		deployment.Status.UpdatedReplicas == replicas, nil
+}
+
+// ElasticsearchReady 判断elasticsearch的statefulset已就绪，并且集群健康状态为green或yellow
+// statefulsets为集群各节点组的statefulset名称，为空时statefulset与service同名
+func (r *LogFileReconciler) ElasticsearchReady(ctx context.Context, name types.NamespacedName, caSecret string, password string, statefulsets ...string) (bool, error) {
+	customizelog := logger.WithValues("func", "ElasticsearchReady")
+
+	if len(statefulsets) == 0 {
+		statefulsets = []string{name.Name}
+	}
+	for _, statefulset := range statefulsets {
+		ready, err := r.StatefulSetReady(ctx, types.NamespacedName{Namespace: name.Namespace, Name: statefulset})
+		if err != nil || !ready {
+			return false, err
+		}
+	}
+
+	esclient, err := r.NewElasticsearchClient(ctx, name, caSecret, "elastic", password)
+	if err != nil {
+		return false, err
+	}
+	health, err := esclient.ClusterHealth(ctx)
+	if err != nil {
+		// 集群刚启动时接口可能暂时不可用，等待下一次调谐
+		customizelog.Info("elasticsearch cluster health unavailable", "name", name.String(), "error", err.Error())
+		return false, nil
+	}
+	customizelog.Info("elasticsearch cluster health", "name", name.String(), "status", health.Status)
+	return health.Status == "green" || health.Status == "yellow", nil
+}
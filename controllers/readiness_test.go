@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestStatefulSetReady(t *testing.T) {
+	replicas := int32(3)
+	statefulset := func(status appsv1.StatefulSetStatus) *appsv1.StatefulSet {
+		return &appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "elasticsearch-master", Namespace: "logging", Generation: 2},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+			Status:     status,
+		}
+	}
+	name := types.NamespacedName{Namespace: "logging", Name: "elasticsearch-master"}
+
+	// 未创建时不算就绪，也不返回错误，等待下一次调谐
+	ready, err := newTestReconciler(t).StatefulSetReady(context.Background(), name)
+	if err != nil || ready {
+		t.Errorf("missing statefulset: ready = %v, err = %v", ready, err)
+	}
+
+	for _, tt := range []struct {
+		status appsv1.StatefulSetStatus
+		ready  bool
+	}{
+		{status: appsv1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 3, UpdatedReplicas: 3}, ready: true},
+		// 控制器还没有处理最新的spec
+		{status: appsv1.StatefulSetStatus{ObservedGeneration: 1, ReadyReplicas: 3, UpdatedReplicas: 3}},
+		// 滚动更新中，旧版本的pod仍然就绪
+		{status: appsv1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 3, UpdatedReplicas: 2}},
+		{status: appsv1.StatefulSetStatus{ObservedGeneration: 2, ReadyReplicas: 2, UpdatedReplicas: 3}},
+	} {
+		ready, err := newTestReconciler(t, statefulset(tt.status)).StatefulSetReady(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ready != tt.ready {
+			t.Errorf("status %+v: ready = %v, want %v", tt.status, ready, tt.ready)
+		}
+	}
+}
+
+func TestDeploymentReady(t *testing.T) {
+	deployment := testDeployment(nil)
+	deployment.Generation = 1
+	deployment.Status = appsv1.DeploymentStatus{ObservedGeneration: 1, ReadyReplicas: 1, UpdatedReplicas: 1}
+	name := types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name}
+
+	// deployment按可用副本判断，就绪但未达到minReadySeconds的副本不算
+	ready, err := newTestReconciler(t, deployment.DeepCopy()).DeploymentReady(context.Background(), name)
+	if err != nil || ready {
+		t.Errorf("ready = %v, err = %v, want not ready without available replicas", ready, err)
+	}
+	deployment.Status.AvailableReplicas = 1
+	ready, err = newTestReconciler(t, deployment.DeepCopy()).DeploymentReady(context.Background(), name)
+	if err != nil || !ready {
+		t.Errorf("ready = %v, err = %v, want ready", ready, err)
+	}
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RetentionPolicyName operator创建的ILM策略和索引模板的名称
+const RetentionPolicyName = "logfile-operator"
+
+// RetentionIndexPatterns 设置保留策略后filebeat和logstash写入的data stream，包括日志路由的索引
+var RetentionIndexPatterns = []string{RoutePrefix + "*"}
+
+// IndexName 返回filebeat、logstash写入的索引，设置了保留策略时写入同名的data stream，由ILM按大小或时间滚动
+// 未设置时按天创建索引
+func IndexName(logfile *apiv1.LogFile, name string) string {
+	if logfile.Spec.Retention != nil {
+		return name
+	}
+	return name + "-%{+yyyy.MM.dd}"
+}
+
+// RouteIndex 返回filebeat写入的索引，设置了路由索引的日志写入各自的索引
+func RouteIndex(index string) string {
+	return "%{[fields." + RouteIndexField + "]:" + index + "}"
+}
+
+// RetentionPolicy 返回ILM策略，warm、cold阶段由elasticsearch自动将索引迁移到对应的数据层节点
+func RetentionPolicy(retention *apiv1.RetentionSpec) map[string]interface{} {
+	phases := map[string]interface{}{
+		"hot": map[string]interface{}{
+			"actions": map[string]interface{}{
+				"rollover": map[string]interface{}{
+					"max_primary_shard_size": retention.RolloverSize,
+					"max_age":                retention.RolloverAge,
+				},
+				"set_priority": map[string]interface{}{"priority": 100},
+			},
+		},
+		"delete": map[string]interface{}{
+			"min_age": fmt.Sprintf("%dd", retention.DeleteAfterDays),
+			"actions": map[string]interface{}{
+				"delete": map[string]interface{}{},
+			},
+		},
+	}
+	if retention.WarmAfterDays > 0 {
+		phases["warm"] = map[string]interface{}{
+			"min_age": fmt.Sprintf("%dd", retention.WarmAfterDays),
+			"actions": map[string]interface{}{
+				"set_priority": map[string]interface{}{"priority": 50},
+			},
+		}
+	}
+	if retention.ColdAfterDays > 0 {
+		phases["cold"] = map[string]interface{}{
+			"min_age": fmt.Sprintf("%dd", retention.ColdAfterDays),
+			"actions": map[string]interface{}{
+				"set_priority": map[string]interface{}{"priority": 0},
+			},
+		}
+	}
+	return map[string]interface{}{"phases": phases}
+}
+
+// RetentionIndexTemplate 返回data stream的索引模板，新建的索引使用RetentionPolicyName策略
+func RetentionIndexTemplate() map[string]interface{} {
+	return map[string]interface{}{
+		"index_patterns": RetentionIndexPatterns,
+		"data_stream":    map[string]interface{}{},
+		// 高于filebeat、logstash自带模板的优先级
+		"priority": 200,
+		"template": map[string]interface{}{
+			"settings": map[string]interface{}{
+				"index.lifecycle.name": RetentionPolicyName,
+			},
+		},
+	}
+}
+
+// RetentionHash 返回ILM策略和索引模板的hash，map按键排序编码，相同的配置hash不变
+func RetentionHash(policy, template map[string]interface{}) (string, error) {
+	data, err := json.Marshal([]interface{}{policy, template})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// SyncRetention 通过elasticsearch接口创建或更新ILM策略和索引模板
+// 配置的hash记录在ILM策略的_meta中，没有变化时不再更新，避免每次调谐都增加策略的版本
+// 取消保留策略时不删除已有的策略，已创建的data stream继续按原策略删除过期数据
+func (r *LogFileReconciler) SyncRetention(ctx context.Context, logfile *apiv1.LogFile, meta metav1.ObjectMeta, caSecret string, password string) error {
+	customizelog := logger.WithValues("func", "SyncRetention")
+	if logfile.Spec.Retention == nil {
+		return nil
+	}
+	elasticsearch := types.NamespacedName{Namespace: meta.Namespace, Name: meta.Name}
+
+	policy := RetentionPolicy(logfile.Spec.Retention)
+	template := RetentionIndexTemplate()
+	hash, err := RetentionHash(policy, template)
+	if err != nil {
+		return err
+	}
+
+	esclient, err := r.NewElasticsearchClient(ctx, elasticsearch, caSecret, "elastic", password)
+	if err != nil {
+		return err
+	}
+	policies := map[string]struct {
+		Policy struct {
+			Meta map[string]string `json:"_meta"`
+		} `json:"policy"`
+	}{}
+	if err := esclient.Do(ctx, http.MethodGet, "/_ilm/policy", nil, &policies); err != nil {
+		return err
+	}
+	if current, ok := policies[RetentionPolicyName]; ok && current.Policy.Meta["hash"] == hash {
+		return nil
+	}
+
+	// 先更新模板，最后写入带hash的策略，中途失败时下次调谐会重新设置
+	template["_meta"] = map[string]string{"managed-by": "logfile-operator"}
+	if err := esclient.Do(ctx, http.MethodPut, "/_index_template/"+RetentionPolicyName, template, nil); err != nil {
+		return err
+	}
+	policy["_meta"] = map[string]string{"managed-by": "logfile-operator", "hash": hash}
+	if err := esclient.Do(ctx, http.MethodPut, "/_ilm/policy/"+RetentionPolicyName, map[string]interface{}{"policy": policy}, nil); err != nil {
+		return err
+	}
+	customizelog.Info("reconcile retention policy success", "name", elasticsearch.String())
+	return nil
+}
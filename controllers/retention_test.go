@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"testing"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+)
+
+func TestRetentionHash(t *testing.T) {
+	base := apiv1.RetentionSpec{RolloverSize: "50gb", RolloverAge: "1d", WarmAfterDays: 3, ColdAfterDays: 7, DeleteAfterDays: 30}
+	hash := func(t *testing.T, retention apiv1.RetentionSpec) string {
+		t.Helper()
+		value, err := RetentionHash(RetentionPolicy(&retention), RetentionIndexTemplate())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	tests := []struct {
+		name    string
+		mutate  func(*apiv1.RetentionSpec)
+		changed bool
+	}{
+		{name: "unchanged", mutate: func(r *apiv1.RetentionSpec) {}},
+		{name: "delete after days", mutate: func(r *apiv1.RetentionSpec) { r.DeleteAfterDays = 60 }, changed: true},
+		{name: "rollover size", mutate: func(r *apiv1.RetentionSpec) { r.RolloverSize = "20gb" }, changed: true},
+		{name: "warm tier removed", mutate: func(r *apiv1.RetentionSpec) { r.WarmAfterDays = 0 }, changed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 重复计算多次，map的遍历顺序不影响hash
+			want := hash(t, base)
+			for i := 0; i < 10; i++ {
+				if got := hash(t, base); got != want {
+					t.Fatalf("hash of the same retention changed: %s != %s", got, want)
+				}
+			}
+			retention := base
+			tt.mutate(&retention)
+			if changed := hash(t, retention) != want; changed != tt.changed {
+				t.Errorf("hash changed = %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
+
+func TestRetentionPolicyPhases(t *testing.T) {
+	tests := []struct {
+		name      string
+		retention apiv1.RetentionSpec
+		phases    map[string]string
+	}{
+		{
+			name:      "hot and delete",
+			retention: apiv1.RetentionSpec{RolloverSize: "50gb", RolloverAge: "1d", DeleteAfterDays: 7},
+			phases:    map[string]string{"hot": "", "delete": "7d"},
+		},
+		{
+			name:      "all tiers",
+			retention: apiv1.RetentionSpec{RolloverSize: "50gb", RolloverAge: "1d", WarmAfterDays: 2, ColdAfterDays: 10, DeleteAfterDays: 30},
+			phases:    map[string]string{"hot": "", "warm": "2d", "cold": "10d", "delete": "30d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			phases := RetentionPolicy(&tt.retention)["phases"].(map[string]interface{})
+			if len(phases) != len(tt.phases) {
+				t.Errorf("phases = %v, want %v", phases, tt.phases)
+			}
+			for name, minage := range tt.phases {
+				phase, ok := phases[name].(map[string]interface{})
+				if !ok {
+					t.Errorf("phase %s missing", name)
+					continue
+				}
+				if got, _ := phase["min_age"].(string); got != minage {
+					t.Errorf("phase %s min_age = %q, want %q", name, got, minage)
+				}
+			}
+		})
+	}
+}
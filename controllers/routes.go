@@ -0,0 +1,402 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// 日志路由写入filebeat事件的字段，filebeat和logstash按字段选择索引和topic
+const (
+	RouteField      = "logfile_route"
+	RouteIndexField = "logfile_index"
+	RouteTopicField = "logfile_topic"
+)
+
+// RoutePrefix 路由的索引和topic名称前缀，日志写入用户只有logfile-operator-*索引的权限
+const RoutePrefix = "logfile-operator-"
+
+// LogRoute 一组日志文件的采集配置，每个路由生成一个filebeat input
+type LogRoute struct {
+	// 路由名称，旧版本的 logfile.huisebug.org/<名称> 注解都属于名称为空的默认路由
+	Name  string
+	Paths []string
+	// 写入的索引，不含RoutePrefix和日期，为空时写入默认索引
+	Index string
+	// 写入的kafka topic，不含RoutePrefix，为空时写入kafka_log
+	Topic string
+	// 多行日志起始行的正则，negate默认true，match默认after
+	Multiline       string
+	MultilineNegate *bool
+	MultilineMatch  string
+	// 按json解析每一行
+	JSON bool
+	// 只采集或不采集匹配正则的行
+	IncludeLines []string
+	ExcludeLines []string
+	// filebeat的processors
+	Processors []map[string]interface{}
+	// 写入日志文件的容器名称
+	Container string
+}
+
+// 不属于日志路由的注解
+// 选择写入日志的标签和注解由parseKubernetesMetadata解析，sidecar的注入方式由SidecarMode解析，其余由webhook注入时写入
+var reservedAnnotations = []string{
+	MetadataLabelsAnnotation,
+	MetadataAnnotationsAnnotation,
+	SidecarModeAnnotation,
+	InjectedAnnotation,
+	SidecarConfigAnnotation,
+	ElasticsearchCAAnnotation,
+}
+
+// 路由注解支持的属性，按长度从长到短匹配，例如 multiline.pattern 优先于 multiline
+var routeAttributes = []string{
+	"json.keys_under_root",
+	"multiline.pattern",
+	"multiline.negate",
+	"multiline.match",
+	"include_lines",
+	"exclude_lines",
+	"processors",
+	"multiline",
+	"paths",
+	"index",
+	"topic",
+}
+
+// ContainerAnnotationPrefix logfile.huisebug.org/container.<容器名称>: 路径1,路径2
+// 声明容器写入的日志文件，日志目录只挂载到该容器和filebeat sidecar，写入默认索引
+const ContainerAnnotationPrefix = "container."
+
+// 路由的索引和topic名称只能使用小写字母、数字、-和_
+var routeNameRegExp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// parseRoutes 解析并校验日志路由注解，有错误的路由不返回，错误由webhook返回警告或拒绝创建pod
+// logfile.huisebug.org/<路由>.paths、.index、.topic 设置路由的日志路径、索引和topic
+// .multiline(.pattern)、.multiline.negate、.multiline.match、.json.keys_under_root、.include_lines、.exclude_lines、.processors 设置日志的解析方式
+// logfile.huisebug.org/container.<容器名称>: 路径1,路径2 声明容器写入的日志文件，日志目录只挂载到该容器
+// 兼容旧版本的 logfile.huisebug.org/<名称>: 路径1,路径2，都加入默认路由
+func parseRoutes(annotations map[string]string, namespace, podName string) ([]LogRoute, field.ErrorList) {
+	var allErrs field.ErrorList
+	annotationspath := field.NewPath("metadata", "annotations")
+	routes := map[string]*LogRoute{}
+	// 有注解写错的路由
+	invalid := map[string]bool{}
+	route := func(name string) *LogRoute {
+		if _, ok := routes[name]; !ok {
+			routes[name] = &LogRoute{Name: name}
+		}
+		return routes[name]
+	}
+	// 按注解名称排序，保证错误信息和生成的配置不变
+	keys := []string{}
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, metricKey := range keys {
+		if containsString(reservedAnnotations, metricKey) {
+			continue
+		}
+		metricValue := annotations[metricKey]
+		path := annotationspath.Key(metricKey)
+		// 以/为分隔符来拆分，判断key名是否长度为2,如果不是则不符合要求
+		domain := strings.Split(metricKey, annotationDomainSeparator)
+		if len(domain) != 2 {
+			allErrs = append(allErrs, field.Invalid(path, metricKey, "注解名称不符合要求"))
+			continue
+		}
+		// 以.为分隔符来拆分索引0的域名，判断域名是否长度小于2,如果小于则不符合域名规范
+		metricSubDomains := strings.Split(domain[0], annotationSubDomainSeparator)
+		if len(metricSubDomains) < 2 || metricSubDomains[0] != "logfile" {
+			allErrs = append(allErrs, field.Invalid(path, metricKey, "注解名称不符合要求"))
+			continue
+		}
+		name, attribute := routeAttribute(domain[1])
+		if container, ok := containerRoute(domain[1]); ok {
+			name, attribute = domain[1], "paths"
+			route(name).Container = container
+		}
+		value := strings.TrimSpace(metricValue)
+		// 旧版本的 logfile.huisebug.org/<名称> 注解写错时只跳过该路径，不影响默认路由中的其他路径
+		legacy := attribute == "paths" && name == "" && domain[1] != "paths"
+		// 注解的值写入filebeat配置，不允许通过${}变量读取sidecar的环境变量
+		if errs := apiv1.ValidateNoFilebeatVariables(path, value); len(errs) > 0 {
+			allErrs = append(allErrs, errs...)
+			invalid[name] = invalid[name] || !legacy
+			continue
+		}
+		switch attribute {
+		case "paths":
+			// 以,为分隔符拆分多个日志文件路径
+			for _, logpath := range strings.Split(value, ",") {
+				if logpath = strings.TrimSpace(logpath); logpath == "" {
+					continue
+				}
+				// 未知的属性按旧版本的日志路径处理，不是绝对路径时通常是属性名称写错
+				if !strings.HasPrefix(logpath, "/") {
+					allErrs = append(allErrs, field.Invalid(path, metricValue, "日志文件路径需要为绝对路径，或注解属性不是"+strings.Join(routeAttributes, "、")+"之一"))
+					invalid[name] = invalid[name] || !legacy
+					continue
+				}
+				if errs := apiv1.ValidateLogPath(path, logpath); len(errs) > 0 {
+					allErrs = append(allErrs, errs...)
+					invalid[name] = invalid[name] || !legacy
+					continue
+				}
+				route(name).Paths = append(route(name).Paths, logpath)
+			}
+		case "index", "topic":
+			if !routeNameRegExp.MatchString(value) {
+				allErrs = append(allErrs, field.Invalid(path, metricValue, "只能使用小写字母、数字、-和_，并以字母或数字开头"))
+				invalid[name] = true
+				continue
+			}
+			if attribute == "index" {
+				route(name).Index = value
+			} else {
+				route(name).Topic = value
+			}
+		case "multiline", "multiline.pattern":
+			if _, err := regexp.Compile(value); err != nil || value == "" {
+				allErrs = append(allErrs, field.Invalid(path, metricValue, "不是有效的正则表达式"))
+				invalid[name] = true
+				continue
+			}
+			route(name).Multiline = value
+		case "multiline.negate":
+			negate, err := strconv.ParseBool(value)
+			if err != nil {
+				allErrs = append(allErrs, field.Invalid(path, metricValue, "只能为true或false"))
+				invalid[name] = true
+				continue
+			}
+			route(name).MultilineNegate = &negate
+		case "multiline.match":
+			if value != "after" && value != "before" {
+				allErrs = append(allErrs, field.NotSupported(path, metricValue, []string{"after", "before"}))
+				invalid[name] = true
+				continue
+			}
+			route(name).MultilineMatch = value
+		case "json.keys_under_root":
+			keysunderroot, err := strconv.ParseBool(value)
+			if err != nil {
+				allErrs = append(allErrs, field.Invalid(path, metricValue, "只能为true或false"))
+				invalid[name] = true
+				continue
+			}
+			route(name).JSON = keysunderroot
+		case "include_lines", "exclude_lines":
+			lines, errs := parseLineRegExps(path, value)
+			if len(errs) > 0 {
+				allErrs = append(allErrs, errs...)
+				invalid[name] = true
+			}
+			if attribute == "include_lines" {
+				route(name).IncludeLines = lines
+			} else {
+				route(name).ExcludeLines = lines
+			}
+		case "processors":
+			processors, errs := parseProcessors(path, value)
+			if len(errs) > 0 {
+				allErrs = append(allErrs, errs...)
+				invalid[name] = true
+			}
+			route(name).Processors = processors
+		}
+	}
+
+	// 按名称排序，默认路由在最前，保证生成的配置不变
+	names := []string{}
+	for name := range routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := []LogRoute{}
+	for _, name := range names {
+		if _, ok := containerRoute(name); ok {
+			// 容器的日志写入默认路由
+			routes[name].Name = ""
+			if len(routes[name].Paths) == 0 {
+				allErrs = append(allErrs, field.Required(annotationspath.Key("logfile.huisebug.org/"+name), fmt.Sprintf("容器%s没有设置日志文件路径", routes[name].Container)))
+				continue
+			}
+		}
+		if len(routes[name].Paths) == 0 {
+			allErrs = append(allErrs, field.Required(annotationspath.Key(DomainAnnotation(name, "paths")), fmt.Sprintf("路由%s没有设置日志文件路径", name)))
+			continue
+		}
+		if (routes[name].MultilineNegate != nil || routes[name].MultilineMatch != "") && routes[name].Multiline == "" {
+			allErrs = append(allErrs, field.Required(annotationspath.Key(DomainAnnotation(name, "multiline.pattern")), "设置multiline.negate或multiline.match时需要设置multiline.pattern"))
+			continue
+		}
+		if invalid[name] {
+			continue
+		}
+		sort.Strings(routes[name].Paths)
+		result = append(result, *routes[name])
+	}
+	if len(allErrs) > 0 {
+		logger.Info("invalid log route annotations", "namespace", namespace, "pod", podName, "error", allErrs.ToAggregate().Error())
+	}
+	return result, allErrs
+}
+
+// routeAttribute 拆分注解名称中的路由名称和属性，没有已知属性时为旧版本的默认路由日志路径
+func routeAttribute(key string) (string, string) {
+	for _, attribute := range routeAttributes {
+		if key == attribute {
+			return "", attribute
+		}
+		if strings.HasSuffix(key, annotationSubDomainSeparator+attribute) {
+			return strings.TrimSuffix(key, annotationSubDomainSeparator+attribute), attribute
+		}
+	}
+	return "", "paths"
+}
+
+// containerRoute 返回 container.<容器名称> 注解中的容器名称
+func containerRoute(key string) (string, bool) {
+	container := strings.TrimPrefix(key, ContainerAnnotationPrefix)
+	if container == key || container == "" || strings.Contains(container, annotationSubDomainSeparator) {
+		return "", false
+	}
+	return container, true
+}
+
+// validateRouteContainers 校验注解中声明的容器都在pod中，返回容器存在的路由
+func validateRouteContainers(routes []LogRoute, pod *corev1.Pod) ([]LogRoute, field.ErrorList) {
+	var allErrs field.ErrorList
+	containers := []string{}
+	for _, container := range pod.Spec.Containers {
+		containers = append(containers, container.Name)
+	}
+	result := []LogRoute{}
+	for _, route := range routes {
+		if route.Container != "" && !containsString(containers, route.Container) {
+			allErrs = append(allErrs, field.NotFound(field.NewPath("metadata", "annotations").Key("logfile.huisebug.org/"+ContainerAnnotationPrefix+route.Container), route.Container))
+			continue
+		}
+		result = append(result, route)
+	}
+	return result, allErrs
+}
+
+// DomainAnnotation 返回路由属性的注解名称
+func DomainAnnotation(route, attribute string) string {
+	if route == "" {
+		return "logfile.huisebug.org/" + attribute
+	}
+	return "logfile.huisebug.org/" + route + annotationSubDomainSeparator + attribute
+}
+
+// parseLineRegExps 解析include_lines、exclude_lines，值为yaml列表或单个正则
+func parseLineRegExps(path *field.Path, value string) ([]string, field.ErrorList) {
+	var allErrs field.ErrorList
+	lines := []string{value}
+	if strings.HasPrefix(value, "[") || strings.HasPrefix(value, "-") {
+		if err := yaml.Unmarshal([]byte(value), &lines); err != nil {
+			return nil, append(allErrs, field.Invalid(path, value, "需要为yaml列表或单个正则: "+err.Error()))
+		}
+	}
+	for i, line := range lines {
+		if _, err := regexp.Compile(line); err != nil || line == "" {
+			allErrs = append(allErrs, field.Invalid(path.Index(i), line, "不是有效的正则表达式"))
+		}
+	}
+	return lines, allErrs
+}
+
+// parseProcessors 解析processors，值为yaml列表，每一项只能包含一个允许的processor
+func parseProcessors(path *field.Path, value string) ([]map[string]interface{}, field.ErrorList) {
+	var allErrs field.ErrorList
+	processors := []map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(value), &processors); err != nil {
+		return nil, append(allErrs, field.Invalid(path, value, "需要为yaml列表: "+err.Error()))
+	}
+	for i, processor := range processors {
+		if len(processor) != 1 {
+			allErrs = append(allErrs, field.Invalid(path.Index(i), processor, "每一项只能包含一个processor"))
+			continue
+		}
+		for name := range processor {
+			if !containsString(apiv1.FilebeatProcessors, name) {
+				allErrs = append(allErrs, field.NotSupported(path.Index(i), name, apiv1.FilebeatProcessors))
+			}
+		}
+	}
+	return processors, allErrs
+}
+
+// MatchingCollectors 返回选择了pod的LogCollector，按名称排序
+func MatchingCollectors(collectors []apiv1.LogCollector, pod *corev1.Pod) []apiv1.LogCollector {
+	matched := []apiv1.LogCollector{}
+	for _, collector := range collectors {
+		selector, err := metav1.LabelSelectorAsSelector(&collector.Spec.Selector)
+		if err != nil {
+			logger.Info("invalid logcollector selector", "name", collector.Namespace+"/"+collector.Name, "error", err.Error())
+			continue
+		}
+		if selector.Matches(labels.Set(pod.Labels)) {
+			matched = append(matched, collector)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Name < matched[j].Name })
+	return matched
+}
+
+// MergeCollectorRoutes 合并LogCollector中的路由，pod注解中已有同名路由时以注解为准，多个LogCollector中同名的路由以名称排序靠前的为准
+func MergeCollectorRoutes(routes []LogRoute, collectors []apiv1.LogCollector) []LogRoute {
+	names := map[string]bool{}
+	for _, route := range routes {
+		names[route.Name] = true
+	}
+	for _, collector := range collectors {
+		for _, collectorroute := range collector.Spec.Routes {
+			if names[collectorroute.Name] {
+				continue
+			}
+			names[collectorroute.Name] = true
+			route := LogRoute{
+				Name:         collectorroute.Name,
+				Paths:        append([]string{}, collectorroute.Paths...),
+				Index:        collectorroute.Index,
+				Topic:        collectorroute.Topic,
+				JSON:         collectorroute.JSONKeysUnderRoot,
+				IncludeLines: collectorroute.IncludeLines,
+				ExcludeLines: collectorroute.ExcludeLines,
+			}
+			if collectorroute.Multiline != nil {
+				route.Multiline = collectorroute.Multiline.Pattern
+				route.MultilineNegate = collectorroute.Multiline.Negate
+				route.MultilineMatch = collectorroute.Multiline.Match
+			}
+			for _, raw := range collectorroute.Processors {
+				processor := map[string]interface{}{}
+				if err := json.Unmarshal(raw.Raw, &processor); err != nil {
+					logger.Info("invalid logcollector processor", "name", collector.Namespace+"/"+collector.Name, "error", err.Error())
+					continue
+				}
+				route.Processors = append(route.Processors, processor)
+			}
+			sort.Strings(route.Paths)
+			routes = append(routes, route)
+		}
+	}
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].Name < routes[j].Name })
+	return routes
+}
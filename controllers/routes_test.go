@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseRoutes(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		routes      []LogRoute
+		errs        int
+	}{
+		{
+			name:        "legacy paths",
+			annotations: map[string]string{"logfile.huisebug.org/log1": "/var/log/b.log", "logfile.huisebug.org/log2": "/var/log/a.log, /var/log/c.log"},
+			routes:      []LogRoute{{Paths: []string{"/var/log/a.log", "/var/log/b.log", "/var/log/c.log"}}},
+		},
+		{
+			name: "named route",
+			annotations: map[string]string{
+				"logfile.huisebug.org/app.paths":             "/var/log/app/*.log",
+				"logfile.huisebug.org/app.index":             "team-a",
+				"logfile.huisebug.org/app.multiline.pattern": `^\d{4}-`,
+			},
+			routes: []LogRoute{{Name: "app", Paths: []string{"/var/log/app/*.log"}, Index: "team-a", Multiline: `^\d{4}-`}},
+		},
+		{
+			name:        "container route",
+			annotations: map[string]string{"logfile.huisebug.org/container.nginx": "/var/log/nginx/*.log"},
+			routes:      []LogRoute{{Paths: []string{"/var/log/nginx/*.log"}, Container: "nginx"}},
+		},
+		{
+			name: "invalid route skipped",
+			annotations: map[string]string{
+				"logfile.huisebug.org/app.paths": "/var/log/app/*.log",
+				"logfile.huisebug.org/app.index": "Team A",
+				"logfile.huisebug.org/web.paths": "/var/log/web/*.log",
+			},
+			routes: []LogRoute{{Name: "web", Paths: []string{"/var/log/web/*.log"}}},
+			errs:   1,
+		},
+		{
+			name: "invalid legacy path skipped",
+			annotations: map[string]string{
+				"logfile.huisebug.org/log1": "/var/log/app.log",
+				"logfile.huisebug.org/log2": `/var/log/"nginx".log, /var/log/nginx/error.log`,
+				"logfile.huisebug.org/app":  "logs/app.log",
+			},
+			routes: []LogRoute{{Paths: []string{"/var/log/app.log", "/var/log/nginx/error.log"}}},
+			errs:   2,
+		},
+		{
+			name: "invalid default route path",
+			annotations: map[string]string{
+				"logfile.huisebug.org/log1":  "/var/log/app.log",
+				"logfile.huisebug.org/paths": "/var/log/app log",
+			},
+			routes: []LogRoute{},
+			errs:   1,
+		},
+		{
+			name:        "invalid container path",
+			annotations: map[string]string{"logfile.huisebug.org/container.nginx": "/var/log/nginx/*.log,/var/log/'x'.log"},
+			routes:      []LogRoute{},
+			errs:        1,
+		},
+		{
+			name:        "route without paths",
+			annotations: map[string]string{"logfile.huisebug.org/app.topic": "app"},
+			routes:      []LogRoute{},
+			errs:        1,
+		},
+		{
+			name:        "multiline options without pattern",
+			annotations: map[string]string{"logfile.huisebug.org/app.paths": "/var/log/app.log", "logfile.huisebug.org/app.multiline.match": "before"},
+			routes:      []LogRoute{},
+			errs:        1,
+		},
+		{
+			name: "filebeat variable in processors",
+			annotations: map[string]string{
+				"logfile.huisebug.org/app.paths":      "/var/log/app.log",
+				"logfile.huisebug.org/app.processors": `[{"add_fields": {"fields": {"password": "${LOGFILE_ES_PASSWORD}"}}}]`,
+				"logfile.huisebug.org/log1":           "/var/log/legacy.log",
+			},
+			routes: []LogRoute{{Paths: []string{"/var/log/legacy.log"}}},
+			errs:   1,
+		},
+		{
+			name:        "misspelled attribute",
+			annotations: map[string]string{"logfile.huisebug.org/app.indx": "team-a"},
+			routes:      []LogRoute{},
+			errs:        1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes, errs := parseRoutes(tt.annotations, "default", "app-")
+			if len(errs) != tt.errs {
+				t.Errorf("errors = %v, want %d", errs, tt.errs)
+			}
+			if !reflect.DeepEqual(routes, tt.routes) {
+				t.Errorf("routes = %+v, want %+v", routes, tt.routes)
+			}
+		})
+	}
+}
+
+func TestValidateRouteContainers(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "app"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}, {Name: "nginx"}}},
+	}
+	routes, errs := validateRouteContainers([]LogRoute{
+		{Paths: []string{"/var/log/app.log"}},
+		{Paths: []string{"/var/log/nginx/*.log"}, Container: "nginx"},
+		{Paths: []string{"/var/log/php/*.log"}, Container: "php"},
+	}, pod)
+	if len(errs) != 1 {
+		t.Errorf("errors = %v, want 1", errs)
+	}
+	expected := []LogRoute{
+		{Paths: []string{"/var/log/app.log"}},
+		{Paths: []string{"/var/log/nginx/*.log"}, Container: "nginx"},
+	}
+	if !reflect.DeepEqual(routes, expected) {
+		t.Errorf("routes = %+v, want %+v", routes, expected)
+	}
+}
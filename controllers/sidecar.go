@@ -0,0 +1,269 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	"gomodules.xyz/jsonpatch/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/discovery"
+)
+
+// SidecarModeAnnotation pod上的注解，选择filebeat sidecar的注入方式，未设置时使用operator的默认方式
+const SidecarModeAnnotation = "logfile.huisebug.org/sidecar-mode"
+
+// filebeat sidecar的注入方式
+const (
+	// SidecarModeAuto Job创建的pod使用native，其他pod使用container
+	SidecarModeAuto = "auto"
+	// SidecarModeNative 集群支持时注入restartPolicy为Always的init容器，业务容器退出后pod可以结束
+	// 不支持时注入普通容器，业务容器都退出后filebeat发送完日志自行退出
+	SidecarModeNative = "native"
+	// SidecarModeContainer 注入普通容器
+	SidecarModeContainer = "container"
+)
+
+// SidecarModes 支持的注入方式
+var SidecarModes = []string{SidecarModeAuto, SidecarModeNative, SidecarModeContainer}
+
+// FilebeatShutdownTimeout 停止filebeat时等待已采集的日志发送完成的时间，需要小于pod的terminationGracePeriodSeconds
+const FilebeatShutdownTimeout = "20s"
+
+// filebeatWrapper native模式的filebeat启动脚本
+// 收到SIGTERM时先停止filebeat，再以--once方式读取到文件末尾，等待日志发送完成后退出，避免丢失业务容器退出前写入的日志
+// LOGFILE_WAIT_CONTAINERS为true时pod共享进程命名空间，其他容器的进程都退出后给自己发送SIGTERM
+const filebeatWrapper = `
+filebeat -e -c /etc/filebeat/filebeat.yml &
+pid=$!
+drain() {
+  trap - TERM
+  kill -TERM "$pid" 2>/dev/null
+  wait "$pid"
+  exec filebeat -e -c /etc/filebeat/filebeat.yml --once -E filebeat.shutdown_timeout=${LOGFILE_SHUTDOWN_TIMEOUT}
+}
+trap drain TERM
+if [ "$LOGFILE_WAIT_CONTAINERS" = "true" ]; then
+  self=$(readlink /proc/self/ns/mnt)
+  (
+    while sleep 5; do
+      running=false
+      for ns in /proc/[0-9]*/ns/mnt; do
+        p=${ns#/proc/}
+        p=${p%%/*}
+        # 跳过pause进程和本容器的进程，无权限读取的进程按其他容器的进程处理
+        [ "$p" = 1 ] && continue
+        [ "$(readlink "$ns" 2>/dev/null)" = "$self" ] && continue
+        running=true
+        break
+      done
+      if [ "$running" = false ]; then
+        kill -TERM $$
+        break
+      fi
+    done
+  ) &
+fi
+wait "$pid"
+`
+
+// ValidSidecarMode 是否为支持的注入方式
+func ValidSidecarMode(mode string) bool {
+	return containsString(SidecarModes, mode)
+}
+
+// SidecarMode 返回pod使用的注入方式，pod注解优先，其次是operator的默认方式
+func (p InjectionPolicy) SidecarMode(pod *corev1.Pod) (string, field.ErrorList) {
+	var allErrs field.ErrorList
+	mode := p.DefaultSidecarMode
+	if value, ok := pod.Annotations[SidecarModeAnnotation]; ok {
+		// 注解写错时使用默认的注入方式
+		if ValidSidecarMode(value) {
+			mode = value
+		} else {
+			allErrs = append(allErrs, field.NotSupported(field.NewPath("metadata", "annotations").Key(SidecarModeAnnotation), value, SidecarModes))
+		}
+	}
+	if mode == "" || mode == SidecarModeAuto {
+		mode = SidecarModeContainer
+		for _, owner := range pod.OwnerReferences {
+			if owner.Kind == "Job" {
+				mode = SidecarModeNative
+			}
+		}
+	}
+	return mode, allErrs
+}
+
+// SupportsNativeSidecar 集群是否支持原生sidecar，1.29起默认开启SidecarContainers
+// operator启动时检测一次，集群升级后需要重启operator
+func SupportsNativeSidecar(discoveryClient discovery.ServerVersionInterface) bool {
+	info, err := discoveryClient.ServerVersion()
+	if err != nil {
+		logger.Info("get server version failed, native sidecar disabled", "error", err.Error())
+		return false
+	}
+	major, _ := strconv.Atoi(strings.TrimSuffix(info.Major, "+"))
+	minor, _ := strconv.Atoi(strings.TrimSuffix(info.Minor, "+"))
+	return major > 1 || (major == 1 && minor >= 29)
+}
+
+// NativeSidecar 将filebeat容器改为native模式，使用启动脚本在退出前发送完日志
+// waitContainers为true时集群不支持原生sidecar，由启动脚本在业务容器都退出后停止filebeat
+func NativeSidecar(pod *corev1.Pod, sidecar *corev1.Container, waitContainers bool) {
+	sidecar.Command = []string{"/bin/bash", "-c"}
+	sidecar.Args = []string{filebeatWrapper}
+	sidecar.Env = append(sidecar.Env,
+		corev1.EnvVar{Name: "LOGFILE_SHUTDOWN_TIMEOUT", Value: FilebeatShutdownTimeout},
+		corev1.EnvVar{Name: "LOGFILE_WAIT_CONTAINERS", Value: strconv.FormatBool(waitContainers)},
+	)
+	if waitContainers {
+		shareProcessNamespace := true
+		pod.Spec.ShareProcessNamespace = &shareProcessNamespace
+	}
+}
+
+// PodPatch 比较注入前后解码的pod生成json patch，patch只包含注入修改的字段
+// 当前版本的k8s.io/api解码pod时会丢失新版本的字段，例如init容器的restartPolicy，不能用重新编码的pod替换原始请求
+// native模式的filebeat在patch中设置restartPolicy: Always
+func PodPatch(original, mutated *corev1.Pod, sidecar string) ([]jsonpatch.JsonPatchOperation, error) {
+	originaljson, err := json.Marshal(original)
+	if err != nil {
+		return nil, err
+	}
+	mutatedjson, err := json.Marshal(mutated)
+	if err != nil {
+		return nil, err
+	}
+	patches, err := jsonpatch.CreatePatch(originaljson, mutatedjson)
+	if err != nil {
+		return nil, err
+	}
+	if sidecar == "" {
+		return patches, nil
+	}
+	for _, patch := range patches {
+		if patch.Operation != "add" || !strings.HasPrefix(patch.Path, "/spec/initContainers") {
+			continue
+		}
+		// 原来没有init容器时整个列表一起添加
+		containers, ok := patch.Value.([]interface{})
+		if !ok {
+			containers = []interface{}{patch.Value}
+		}
+		for _, item := range containers {
+			if container, ok := item.(map[string]interface{}); ok && container["name"] == sidecar {
+				container["restartPolicy"] = string(corev1.RestartPolicyAlways)
+			}
+		}
+	}
+	return patches, nil
+}
+
+// SidecarSecurityProfileAnnotation namespace上的注解，覆盖LogFile中sidecar的安全配置，例如PodSecurity为restricted的namespace
+const SidecarSecurityProfileAnnotation = "logfile.huisebug.org/sidecar-security-profile"
+
+// FilebeatDataPath filebeat的registry目录，只读根文件系统时挂载EmptyDir
+const FilebeatDataPath = "/usr/share/filebeat/data"
+
+// SidecarSecurityProfile 返回sidecar的安全配置和restricted时运行的用户
+// namespace上的注解优先，其次是组件configmap中LogFile的配置，旧版本的configmap中没有时使用restricted
+func SidecarSecurityProfile(ns *corev1.Namespace, configmap *corev1.ConfigMap) (string, int64) {
+	profile := configmap.Data["sidecar.securityprofile"]
+	if value, ok := ns.Annotations[SidecarSecurityProfileAnnotation]; ok {
+		if value == apiv1.SecurityProfileRestricted || value == apiv1.SecurityProfileBaseline || value == apiv1.SecurityProfilePrivileged {
+			profile = value
+		} else {
+			logger.Info("invalid sidecar security profile annotation, ignored", "namespace", ns.Name, "value", value)
+		}
+	}
+	if profile == "" {
+		profile = apiv1.SecurityProfileRestricted
+	}
+	runAsUser, err := strconv.ParseInt(configmap.Data["sidecar.runasuser"], 10, 64)
+	if err != nil || runAsUser <= 0 {
+		runAsUser = apiv1.DefaultSidecarRunAsUser
+	}
+	return profile, runAsUser
+}
+
+// SidecarSecurityContext 返回sidecar和初始化容器的securityContext
+func SidecarSecurityContext(profile string, runAsUser int64) *corev1.SecurityContext {
+	if profile == apiv1.SecurityProfilePrivileged {
+		privileged := true
+		return &corev1.SecurityContext{Privileged: &privileged}
+	}
+	allowPrivilegeEscalation, readOnlyRootFilesystem := false, true
+	securitycontext := &corev1.SecurityContext{
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+		ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+	if profile == apiv1.SecurityProfileBaseline {
+		// root用户读取其他用户只有属主可读的日志文件
+		root := int64(0)
+		securitycontext.RunAsUser = &root
+		securitycontext.Capabilities.Add = []corev1.Capability{"DAC_OVERRIDE"}
+		return securitycontext
+	}
+	runAsNonRoot := true
+	securitycontext.RunAsNonRoot = &runAsNonRoot
+	securitycontext.RunAsUser = &runAsUser
+	return securitycontext
+}
+
+// InjectedAnnotation 注入sidecar后写入pod的注解，值为filebeat配置的hash，存在时不再注入
+const InjectedAnnotation = "logfile.huisebug.org/injected"
+
+// Injected pod是否已经注入了sidecar，没有注解时按旧版本生成配置的初始化容器判断
+func Injected(pod *corev1.Pod) bool {
+	if _, ok := pod.Annotations[InjectedAnnotation]; ok {
+		return true
+	}
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name == "genfilebeatyml" {
+			return true
+		}
+	}
+	return false
+}
+
+// SidecarConfigHash 返回filebeat配置的hash，写入InjectedAnnotation
+func SidecarConfigHash(config string) string {
+	sum := sha256.Sum256([]byte(config))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// SidecarConfigAnnotation 注入时写入pod的filebeat配置，通过Downward API挂载为配置文件，不经过shell生成
+const SidecarConfigAnnotation = "logfile.huisebug.org/filebeat-config"
+
+// ElasticsearchCAAnnotation filebeat直接写入集群模式的elasticsearch时校验服务端证书的ca，通过Downward API挂载
+const ElasticsearchCAAnnotation = "logfile.huisebug.org/elasticsearch-ca"
+
+// AnnotationVolume 返回将pod注解挂载为文件的Downward API卷
+func AnnotationVolume(name string, annotation string, path string) corev1.Volume {
+	return corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			DownwardAPI: &corev1.DownwardAPIVolumeSource{
+				Items: []corev1.DownwardAPIVolumeFile{
+					{
+						Path: path,
+						FieldRef: &corev1.ObjectFieldSelector{
+							APIVersion: "v1",
+							FieldPath:  fmt.Sprintf("metadata.annotations['%s']", annotation),
+						},
+					},
+				},
+			},
+		},
+	}
+}
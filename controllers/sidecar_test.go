@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestSupportsNativeSidecar(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   *version.Info
+		supported bool
+	}{
+		{name: "1.28", version: &version.Info{Major: "1", Minor: "28"}, supported: false},
+		{name: "1.29", version: &version.Info{Major: "1", Minor: "29"}, supported: true},
+		{name: "provider suffix", version: &version.Info{Major: "1", Minor: "30+"}, supported: true},
+		{name: "unknown version", version: &version.Info{}, supported: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discoveryClient := &fakediscovery.FakeDiscovery{Fake: &k8stesting.Fake{}, FakedServerVersion: tt.version}
+			if supported := SupportsNativeSidecar(discoveryClient); supported != tt.supported {
+				t.Errorf("SupportsNativeSidecar = %v, want %v", supported, tt.supported)
+			}
+		})
+	}
+}
+
+func TestPodPatch(t *testing.T) {
+	// schedulingGates和init容器的restartPolicy是当前k8s.io/api中没有的字段
+	raw := []byte(`{
+		"metadata": {"name": "app"},
+		"spec": {
+			"schedulingGates": [{"name": "example.com/quota"}],
+			"initContainers": [{"name": "proxy", "image": "envoy", "restartPolicy": "Always"}],
+			"containers": [{"name": "app", "image": "app"}]
+		}
+	}`)
+	original := &corev1.Pod{}
+	if err := json.Unmarshal(raw, original); err != nil {
+		t.Fatal(err)
+	}
+	pod := original.DeepCopy()
+	pod.Annotations = map[string]string{InjectedAnnotation: "hash"}
+	pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{Name: "filebeat", Image: "filebeat"})
+
+	patches, err := PodPatch(original, pod, "filebeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sidecar := false
+	for _, patch := range patches {
+		// 注入只能新增字段，替换或删除原有的init容器会丢失解码时没有的字段
+		if patch.Operation != "add" || patch.Path == "/spec" || patch.Path == "/spec/initContainers" {
+			t.Errorf("patch %s %s overwrites the original pod", patch.Operation, patch.Path)
+		}
+		if patch.Path == "/spec/initContainers/1" {
+			container := patch.Value.(map[string]interface{})
+			sidecar = container["name"] == "filebeat" && container["restartPolicy"] == "Always"
+		}
+	}
+	if !sidecar {
+		t.Errorf("patches = %+v, want filebeat init container with restartPolicy Always", patches)
+	}
+}
+
+func TestSidecarSecurityProfile(t *testing.T) {
+	configmap := &corev1.ConfigMap{Data: map[string]string{"sidecar.securityprofile": apiv1.SecurityProfileBaseline, "sidecar.runasuser": "2000"}}
+	namespace := func(profile string) *corev1.Namespace {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}}
+		if profile != "" {
+			ns.Annotations = map[string]string{SidecarSecurityProfileAnnotation: profile}
+		}
+		return ns
+	}
+
+	if profile, uid := SidecarSecurityProfile(namespace(""), configmap); profile != apiv1.SecurityProfileBaseline || uid != 2000 {
+		t.Errorf("LogFile profile = %s/%d, want baseline/2000", profile, uid)
+	}
+	// namespace上的注解优先，写错时忽略
+	if profile, _ := SidecarSecurityProfile(namespace(apiv1.SecurityProfileRestricted), configmap); profile != apiv1.SecurityProfileRestricted {
+		t.Errorf("namespace profile = %s, want restricted", profile)
+	}
+	if profile, _ := SidecarSecurityProfile(namespace("strict"), configmap); profile != apiv1.SecurityProfileBaseline {
+		t.Errorf("invalid namespace profile = %s, want baseline from the LogFile", profile)
+	}
+	// 旧版本的configmap中没有安全配置
+	if profile, uid := SidecarSecurityProfile(namespace(""), &corev1.ConfigMap{}); profile != apiv1.SecurityProfileRestricted || uid != apiv1.DefaultSidecarRunAsUser {
+		t.Errorf("legacy profile = %s/%d, want restricted/%d", profile, uid, apiv1.DefaultSidecarRunAsUser)
+	}
+}
+
+func TestSidecarSecurityContextRestricted(t *testing.T) {
+	securitycontext := SidecarSecurityContext(apiv1.SecurityProfileRestricted, 1000)
+
+	// PodSecurity restricted要求的字段
+	if securitycontext.RunAsNonRoot == nil || !*securitycontext.RunAsNonRoot {
+		t.Error("runAsNonRoot is not true")
+	}
+	if securitycontext.RunAsUser == nil || *securitycontext.RunAsUser != 1000 {
+		t.Errorf("runAsUser = %v, want 1000", securitycontext.RunAsUser)
+	}
+	if securitycontext.AllowPrivilegeEscalation == nil || *securitycontext.AllowPrivilegeEscalation {
+		t.Error("allowPrivilegeEscalation is not false")
+	}
+	if securitycontext.Capabilities == nil || len(securitycontext.Capabilities.Add) > 0 ||
+		len(securitycontext.Capabilities.Drop) != 1 || securitycontext.Capabilities.Drop[0] != "ALL" {
+		t.Errorf("capabilities = %+v, want only ALL dropped", securitycontext.Capabilities)
+	}
+	if securitycontext.SeccompProfile == nil || securitycontext.SeccompProfile.Type != corev1.SeccompProfileTypeRuntimeDefault {
+		t.Errorf("seccompProfile = %+v, want RuntimeDefault", securitycontext.SeccompProfile)
+	}
+	if securitycontext.ReadOnlyRootFilesystem == nil || !*securitycontext.ReadOnlyRootFilesystem {
+		t.Error("readOnlyRootFilesystem is not true")
+	}
+
+	// baseline以root运行并只添加DAC_OVERRIDE，privileged不做限制
+	baseline := SidecarSecurityContext(apiv1.SecurityProfileBaseline, 1000)
+	if baseline.RunAsNonRoot != nil || *baseline.RunAsUser != 0 || len(baseline.Capabilities.Add) != 1 || baseline.Capabilities.Add[0] != "DAC_OVERRIDE" {
+		t.Errorf("baseline securityContext = %+v", baseline)
+	}
+	if privileged := SidecarSecurityContext(apiv1.SecurityProfilePrivileged, 1000); privileged.Privileged == nil || !*privileged.Privileged {
+		t.Errorf("privileged securityContext = %+v", privileged)
+	}
+}
+
+func TestInjected(t *testing.T) {
+	for _, pod := range []corev1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{InjectedAnnotation: SidecarConfigHash("filebeat.inputs: []")}}},
+		// 旧版本注入的pod没有注解
+		{Spec: corev1.PodSpec{InitContainers: []corev1.Container{{Name: "genfilebeatyml"}}}},
+	} {
+		if !Injected(&pod) {
+			t.Errorf("Injected(%+v) = false", pod)
+		}
+	}
+	if Injected(&corev1.Pod{Spec: corev1.PodSpec{InitContainers: []corev1.Container{{Name: "init-db"}}}}) {
+		t.Error("pod without sidecar reported as injected")
+	}
+	if SidecarConfigHash("a") == SidecarConfigHash("b") || len(SidecarConfigHash("a")) != 16 {
+		t.Error("SidecarConfigHash() is not a short hash of the config")
+	}
+}
+
+func TestHandleSkipsInjectedAndUpdatedPods(t *testing.T) {
+	handler := &PodSidecarMutate{}
+	decoder, err := admission.NewDecoder(runtime.NewScheme())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := handler.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(operation admissionv1.Operation, pod *corev1.Pod) admission.Request {
+		raw, err := json.Marshal(pod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: operation,
+			Namespace: "team-a",
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+	}
+	injected := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Annotations: map[string]string{InjectedAnnotation: "0123456789abcdef"}}}
+	plain := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web"}, Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "web"}}}}
+
+	// 重复调用webhook或更新pod时直接放行，不再查询集群也不修改pod
+	for name, req := range map[string]admission.Request{
+		"reinvocation": request(admissionv1.Create, injected),
+		"update":       request(admissionv1.Update, plain),
+	} {
+		response := handler.Handle(context.Background(), req)
+		if !response.Allowed || len(response.Patches) > 0 || response.PatchType != nil {
+			t.Errorf("%s: response = %+v, want allowed without patches", name, response)
+		}
+	}
+}
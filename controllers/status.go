@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// ResyncResult 所有组件就绪后定期重新检查，及时发现组件崩溃或被误删
+var ResyncResult = ctrl.Result{RequeueAfter: 5 * time.Minute}
+
+// 全部组件的condition类型
+var allComponentConditions = []string{
+	apiv1.ConditionFilebeatConfigReady,
+	apiv1.ConditionKafkaReady,
+	apiv1.ConditionZookeeperReady,
+	apiv1.ConditionLogstashReady,
+	apiv1.ConditionElasticsearchReady,
+	apiv1.ConditionKibanaReady,
+}
+
+// componentConditions 返回当前部署的组件condition类型
+func componentConditions(logfile *apiv1.LogFile) []string {
+	conditions := []string{apiv1.ConditionFilebeatConfigReady}
+	switch logfile.Spec.KafkaMode() {
+	case apiv1.ModeSingle:
+		conditions = append(conditions, apiv1.ConditionKafkaReady)
+	case apiv1.ModeCluster:
+		conditions = append(conditions, apiv1.ConditionZookeeperReady, apiv1.ConditionKafkaReady)
+	}
+	if logfile.Spec.LogstashEnabled() {
+		conditions = append(conditions, apiv1.ConditionLogstashReady)
+	}
+	return append(conditions, apiv1.ConditionElasticsearchReady, apiv1.ConditionKibanaReady)
+}
+
+// SetComponentCondition 记录组件是否就绪
+func SetComponentCondition(logfile *apiv1.LogFile, conditiontype string, ready bool, reason, message string) {
+	status := metav1.ConditionFalse
+	if ready {
+		status = metav1.ConditionTrue
+	}
+	apimeta.SetStatusCondition(&logfile.Status.Conditions, metav1.Condition{
+		Type:               conditiontype,
+		Status:             status,
+		ObservedGeneration: logfile.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// SetStatefulSetCondition 根据statefulset是否就绪设置组件condition
+func (r *LogFileReconciler) SetStatefulSetCondition(ctx context.Context, logfile *apiv1.LogFile, conditiontype string, name types.NamespacedName) (bool, error) {
+	ready, err := r.StatefulSetReady(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if ready {
+		SetComponentCondition(logfile, conditiontype, true, "Ready", "statefulset "+name.Name+" is ready")
+	} else {
+		SetComponentCondition(logfile, conditiontype, false, "WaitingForReplicas", "statefulset "+name.Name+" is not ready")
+	}
+	return ready, nil
+}
+
+// SetDeploymentCondition 根据deployment是否可用设置组件condition
+func (r *LogFileReconciler) SetDeploymentCondition(ctx context.Context, logfile *apiv1.LogFile, conditiontype string, name types.NamespacedName) (bool, error) {
+	ready, err := r.DeploymentReady(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if ready {
+		SetComponentCondition(logfile, conditiontype, true, "Ready", "deployment "+name.Name+" is available")
+	} else {
+		SetComponentCondition(logfile, conditiontype, false, "WaitingForReplicas", "deployment "+name.Name+" is not available")
+	}
+	return ready, nil
+}
+
+// SetElasticsearchWaiting 记录elasticsearch尚未就绪，kibana依赖elasticsearch也一并标记为等待
+func SetElasticsearchWaiting(logfile *apiv1.LogFile, name string) {
+	SetComponentCondition(logfile, apiv1.ConditionElasticsearchReady, false, "WaitingForHealth", "elasticsearch "+name+" is not ready or cluster health is red")
+	SetComponentCondition(logfile, apiv1.ConditionKibanaReady, false, "WaitingForElasticsearch", "waiting for elasticsearch "+name)
+}
+
+// UpdateStatus 根据各组件condition汇总Ready condition和整体阶段，状态发生变化时写回
+func (r *LogFileReconciler) UpdateStatus(ctx context.Context, original, logfile *apiv1.LogFile, runErr error) error {
+	customizelog := logger.WithValues("func", "UpdateStatus")
+
+	expected := componentConditions(logfile)
+	// 移除未部署的组件
+	for _, conditiontype := range allComponentConditions {
+		if !containsString(expected, conditiontype) {
+			apimeta.RemoveStatusCondition(&logfile.Status.Conditions, conditiontype)
+		}
+	}
+
+	notready := []string{}
+	for _, conditiontype := range expected {
+		if !apimeta.IsStatusConditionTrue(logfile.Status.Conditions, conditiontype) {
+			notready = append(notready, conditiontype)
+		}
+	}
+	switch {
+	case runErr != nil:
+		SetComponentCondition(logfile, apiv1.ConditionReady, false, "ReconcileError", runErr.Error())
+	case len(notready) > 0:
+		SetComponentCondition(logfile, apiv1.ConditionReady, false, "ComponentsNotReady", "not ready: "+strings.Join(notready, ", "))
+	default:
+		SetComponentCondition(logfile, apiv1.ConditionReady, true, "AllComponentsReady", "all components are ready")
+	}
+
+	switch {
+	case apimeta.IsStatusConditionTrue(logfile.Status.Conditions, apiv1.ConditionReady):
+		logfile.Status.Phase = apiv1.PhaseReady
+	case (original.Status.Phase == apiv1.PhaseReady || original.Status.Phase == apiv1.PhaseDegraded) &&
+		original.Status.ObservedGeneration == logfile.Generation:
+		// spec未变化时从就绪变为不就绪，说明有组件出现了故障
+		logfile.Status.Phase = apiv1.PhaseDegraded
+	default:
+		logfile.Status.Phase = apiv1.PhaseProvisioning
+	}
+	if runErr == nil {
+		logfile.Status.ObservedGeneration = logfile.Generation
+	}
+
+	if equality.Semantic.DeepEqual(original.Status, logfile.Status) {
+		return nil
+	}
+	customizelog.Info("update logfile status", "name", logfile.Name, "phase", logfile.Status.Phase)
+	return r.Status().Update(ctx, logfile)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TestUpdateStatusLifecycle 按部署、就绪、故障、修改spec、调谐出错的顺序检查阶段和Ready condition
+func TestUpdateStatusLifecycle(t *testing.T) {
+	ctx := context.Background()
+	enabled := true
+	logfile := &apiv1.LogFile{
+		ObjectMeta: metav1.ObjectMeta{Name: "logfile", Namespace: "logging", Generation: 1},
+		Spec: apiv1.LogFileSpec{
+			Elasticsearch: &apiv1.ElasticsearchSpec{Mode: apiv1.ModeSingle},
+			Kafka:         &apiv1.KafkaSpec{Mode: apiv1.ModeCluster},
+			Logstash:      &apiv1.LogstashSpec{Enabled: &enabled},
+		},
+	}
+	r := newTestReconciler(t, logfile)
+	update := func(runErr error) {
+		t.Helper()
+		original := logfile.DeepCopy()
+		if err := r.UpdateStatus(ctx, original, logfile, runErr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := func(step, phase, reason string, observedGeneration int64) {
+		t.Helper()
+		ready := apimeta.FindStatusCondition(logfile.Status.Conditions, apiv1.ConditionReady)
+		if logfile.Status.Phase != phase || ready == nil || ready.Reason != reason || logfile.Status.ObservedGeneration != observedGeneration {
+			t.Errorf("%s: phase = %s, ready = %+v, observedGeneration = %d; want %s, %s, %d",
+				step, logfile.Status.Phase, ready, logfile.Status.ObservedGeneration, phase, reason, observedGeneration)
+		}
+	}
+	components := []string{
+		apiv1.ConditionFilebeatConfigReady, apiv1.ConditionZookeeperReady, apiv1.ConditionKafkaReady,
+		apiv1.ConditionLogstashReady, apiv1.ConditionElasticsearchReady, apiv1.ConditionKibanaReady,
+	}
+
+	update(nil)
+	expect("first reconcile", apiv1.PhaseProvisioning, "ComponentsNotReady", 1)
+
+	for _, conditiontype := range components {
+		SetComponentCondition(logfile, conditiontype, true, "Ready", "")
+	}
+	update(nil)
+	expect("all components ready", apiv1.PhaseReady, "AllComponentsReady", 1)
+
+	// spec未变化时组件不就绪为故障
+	SetElasticsearchWaiting(logfile, "elasticsearch")
+	update(nil)
+	expect("elasticsearch unhealthy", apiv1.PhaseDegraded, "ComponentsNotReady", 1)
+	if message := apimeta.FindStatusCondition(logfile.Status.Conditions, apiv1.ConditionReady).Message; message != "not ready: ElasticsearchReady, KibanaReady" {
+		t.Errorf("ready message = %q", message)
+	}
+
+	// 修改spec后等待组件更新为部署中，不再使用kafka时移除kafka和zookeeper的condition
+	logfile.Generation = 2
+	logfile.Spec.Kafka.Mode = apiv1.ModeNone
+	update(nil)
+	expect("spec changed", apiv1.PhaseProvisioning, "ComponentsNotReady", 2)
+	for _, conditiontype := range []string{apiv1.ConditionKafkaReady, apiv1.ConditionZookeeperReady} {
+		if apimeta.FindStatusCondition(logfile.Status.Conditions, conditiontype) != nil {
+			t.Errorf("condition %s is kept after kafka was removed", conditiontype)
+		}
+	}
+
+	// 调谐出错时不更新observedGeneration
+	logfile.Generation = 3
+	update(errors.New("connection refused"))
+	expect("reconcile error", apiv1.PhaseProvisioning, "ReconcileError", 2)
+
+	stored := &apiv1.LogFile{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(logfile), stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status.Phase != logfile.Status.Phase {
+		t.Errorf("stored phase = %s, want %s", stored.Status.Phase, logfile.Status.Phase)
+	}
+}
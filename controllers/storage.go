@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ExpandStatefulSetStorage 处理statefulset的存储扩容
+// volumeClaimTemplates创建后不可变更，因此先直接修改已存在的pvc，再以Orphan方式删除statefulset，
+// 下一次调谐按新模板重新创建statefulset并接管原有的pod，数据不受影响
+// 返回true表示statefulset正在重建，调用方本次不需要再创建或更新
+func (r *LogFileReconciler) ExpandStatefulSetStorage(ctx context.Context, desired *appsv1.StatefulSet) (bool, error) {
+	customizelog := logger.WithValues("func", "ExpandStatefulSetStorage")
+
+	existing := &appsv1.StatefulSet{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(desired), existing); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	// 等待Orphan删除完成后再重新创建
+	if existing.DeletionTimestamp != nil {
+		return true, nil
+	}
+
+	expand := false
+	for _, template := range desired.Spec.VolumeClaimTemplates {
+		want := template.Spec.Resources.Requests[corev1.ResourceStorage]
+		for _, current := range existing.Spec.VolumeClaimTemplates {
+			if current.Name != template.Name {
+				continue
+			}
+			have := current.Spec.Resources.Requests[corev1.ResourceStorage]
+			if want.Cmp(have) <= 0 {
+				continue
+			}
+			expand = true
+			// pvc名称为<模板名>-<statefulset名>-<序号>
+			for i := int32(0); i < pointer.Int32Deref(existing.Spec.Replicas, 1); i++ {
+				pvc := &corev1.PersistentVolumeClaim{}
+				name := types.NamespacedName{Namespace: existing.Namespace, Name: fmt.Sprintf("%s-%s-%d", template.Name, existing.Name, i)}
+				if err := r.Get(ctx, name, pvc); err != nil {
+					if errors.IsNotFound(err) {
+						continue
+					}
+					return false, err
+				}
+				size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+				if want.Cmp(size) <= 0 {
+					continue
+				}
+				patch := client.MergeFrom(pvc.DeepCopy())
+				pvc.Spec.Resources.Requests[corev1.ResourceStorage] = want
+				if err := r.Patch(ctx, pvc, patch); err != nil {
+					return false, fmt.Errorf("expand pvc %s to %s: %w", name.String(), want.String(), err)
+				}
+				customizelog.Info("expand pvc", "name", name.String(), "from", size.String(), "to", want.String())
+			}
+		}
+	}
+	if !expand {
+		return false, nil
+	}
+
+	customizelog.Info("recreate statefulset with new volumeClaimTemplates", "name", existing.Name)
+	if err := r.Delete(ctx, existing, client.PropagationPolicy(metav1.DeletePropagationOrphan)); err != nil && !errors.IsNotFound(err) {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 排空logstash的等待时间
+const (
+	// logstash接收的事件数在该时间内不再变化，认为kafka中的日志已经消费完
+	DrainQuietPeriod = 30 * time.Second
+	// 超过该时间仍未排空时不再等待，避免LogFile一直无法删除
+	DrainTimeout = 10 * time.Minute
+)
+
+// TeardownResult 删除过程中等待组件停止时的重新入队间隔
+var TeardownResult = ctrl.Result{RequeueAfter: 5 * time.Second}
+
+// 各步骤删除的组件，与Run中设置的app标签一致
+var pipelineApps = []string{"logstash", "kafka", "kafka-cluster", "kafka-cluster-zookeeper"}
+
+// Teardown 按顺序删除组件: 先停止日志采集，再排空并删除kafka和logstash，最后删除elasticsearch
+// 返回true表示已经全部完成，可以移除finalizer
+func (r *LogFileReconciler) Teardown(ctx context.Context, logfile *apiv1.LogFile) (ctrl.Result, bool, error) {
+	customizelog := logger.WithValues("func", "Teardown")
+	namespace := StackNamespace(logfile)
+	now := metav1.Now()
+
+	if logfile.Status.Teardown == nil {
+		logfile.Status.Teardown = &apiv1.TeardownStatus{
+			Step:      apiv1.TeardownStopShippers,
+			StartTime: now,
+		}
+		r.Recorder.Eventf(logfile, corev1.EventTypeNormal, "Teardown", "Tearing down logging stack in namespace %s, retainPolicy %s", namespace, logfile.Spec.RetainPolicy)
+	}
+	logfile.Status.Phase = apiv1.PhaseTerminating
+	teardown := logfile.Status.Teardown
+
+	switch teardown.Step {
+	case apiv1.TeardownStopShippers:
+		// 删除filebeat-sidecar配置后webhook不再注入sidecar
+		configmap := &corev1.ConfigMap{}
+		configmap.Name = "filebeat-sidecar"
+		configmap.Namespace = namespace
+		if err := r.Delete(ctx, configmap); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, false, err
+		}
+		customizelog.Info("stop shippers", "namespace", namespace)
+		teardown.Step = apiv1.TeardownDrainPipeline
+		fallthrough
+
+	case apiv1.TeardownDrainPipeline:
+		drained, err := r.LogstashDrained(ctx, logfile, now)
+		if err != nil {
+			customizelog.Error(err, "get logstash events failed")
+		}
+		if !drained {
+			if now.Sub(teardown.StartTime.Time) < DrainTimeout {
+				customizelog.Info("等待logstash排空", "eventsIn", teardown.EventsIn)
+				return TeardownResult, false, nil
+			}
+			r.Recorder.Eventf(logfile, corev1.EventTypeWarning, "DrainTimeout", "Logstash was not drained within %s, continue teardown", DrainTimeout)
+		}
+		teardown.Step = apiv1.TeardownDeletePipeline
+		fallthrough
+
+	case apiv1.TeardownDeletePipeline:
+		if err := r.DeleteComponents(ctx, logfile, pipelineApps...); err != nil {
+			return ctrl.Result{}, false, err
+		}
+		// 等待kafka和logstash的pod全部退出后再删除elasticsearch，保证已消费的日志写入完成
+		running, err := r.ComponentPodsRunning(ctx, logfile, pipelineApps...)
+		if err != nil {
+			return ctrl.Result{}, false, err
+		}
+		if running {
+			customizelog.Info("等待logstash和kafka停止")
+			return TeardownResult, false, nil
+		}
+		teardown.Step = apiv1.TeardownDeleteElasticsearch
+		fallthrough
+
+	case apiv1.TeardownDeleteElasticsearch:
+		if logfile.Spec.RetainPolicy == apiv1.RetainPolicyRetain {
+			// elasticsearch只在首次初始化时读取密码，保留数据时同时保留密码，重新创建后继续使用
+			for _, name := range []string{CredentialsSecretName, AppliedCredentialsSecretName("elasticsearch"), AppliedCredentialsSecretName("elasticsearch-master")} {
+				if err := r.ReleaseObject(ctx, &corev1.Secret{}, types.NamespacedName{Namespace: namespace, Name: name}); err != nil {
+					return ctrl.Result{}, false, err
+				}
+			}
+		}
+		if err := r.DeleteComponents(ctx, logfile); err != nil {
+			return ctrl.Result{}, false, err
+		}
+		if err := r.DeleteMirroredSecrets(ctx, logfile); err != nil {
+			return ctrl.Result{}, false, err
+		}
+		if err := r.ApplyRetainPolicy(ctx, logfile); err != nil {
+			return ctrl.Result{}, false, err
+		}
+	}
+	return ctrl.Result{}, true, nil
+}
+
+// LogstashDrained 判断logstash是否已经排空，未部署logstash时直接返回true
+// 所有pod接收的事件都已输出，并且接收的事件数在DrainQuietPeriod内不再变化
+func (r *LogFileReconciler) LogstashDrained(ctx context.Context, logfile *apiv1.LogFile, now metav1.Time) (bool, error) {
+	pods, err := r.ComponentPods(ctx, logfile, "logstash")
+	if err != nil || len(pods) == 0 {
+		return err == nil, err
+	}
+	httpclient := &http.Client{Timeout: 5 * time.Second}
+	var in, out int64
+	for _, pod := range pods {
+		if pod.Status.PodIP == "" || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		stats := struct {
+			Events struct {
+				In  int64 `json:"in"`
+				Out int64 `json:"out"`
+			} `json:"events"`
+		}{}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s:9600/_node/stats/events", pod.Status.PodIP), nil)
+		if err != nil {
+			return false, err
+		}
+		resp, err := httpclient.Do(req)
+		if err != nil {
+			return false, err
+		}
+		err = json.NewDecoder(resp.Body).Decode(&stats)
+		resp.Body.Close()
+		if err != nil {
+			return false, err
+		}
+		in += stats.Events.In
+		out += stats.Events.Out
+	}
+
+	teardown := logfile.Status.Teardown
+	if in != teardown.EventsIn || teardown.EventsInChangeTime == nil {
+		teardown.EventsIn = in
+		teardown.EventsInChangeTime = &now
+		return false, nil
+	}
+	return in == out && now.Sub(teardown.EventsInChangeTime.Time) >= DrainQuietPeriod, nil
+}
+
+// ComponentPods 返回组件的pod
+func (r *LogFileReconciler) ComponentPods(ctx context.Context, logfile *apiv1.LogFile, app string) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(StackNamespace(logfile)), client.MatchingLabels{
+		"logfile-operator": logfile.Name,
+		"app":              app,
+	}); err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+// ComponentPodsRunning 判断组件是否还有pod存在
+func (r *LogFileReconciler) ComponentPodsRunning(ctx context.Context, logfile *apiv1.LogFile, apps ...string) (bool, error) {
+	for _, app := range apps {
+		pods, err := r.ComponentPods(ctx, logfile, app)
+		if err != nil {
+			return false, err
+		}
+		if len(pods) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// DeleteMirroredSecrets 删除复制到业务namespace的密码和拉取镜像的secret
+func (r *LogFileReconciler) DeleteMirroredSecrets(ctx context.Context, logfile *apiv1.LogFile) error {
+	customizelog := logger.WithValues("func", "DeleteMirroredSecrets")
+
+	mirrors, err := r.ListMirroredSecrets(ctx, logfile)
+	if err != nil {
+		return err
+	}
+	for i := range mirrors {
+		if err := r.Delete(ctx, &mirrors[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		customizelog.Info("delete mirrored secret", "name", mirrors[i].Namespace+"/"+mirrors[i].Name)
+	}
+	return nil
+}
+
+// ApplyRetainPolicy 按保留策略处理statefulset创建的pvc
+// pvc由statefulset控制器创建，带有pod的标签，没有ownerReferences，不会被级联删除
+func (r *LogFileReconciler) ApplyRetainPolicy(ctx context.Context, logfile *apiv1.LogFile) error {
+	customizelog := logger.WithValues("func", "ApplyRetainPolicy")
+
+	pvcs := &corev1.PersistentVolumeClaimList{}
+	if err := r.List(ctx, pvcs, client.InNamespace(StackNamespace(logfile)), client.MatchingLabels{"logfile-operator": logfile.Name}); err != nil {
+		return err
+	}
+	for i := range pvcs.Items {
+		pvc := &pvcs.Items[i]
+		if logfile.Spec.RetainPolicy == apiv1.RetainPolicyRetain {
+			customizelog.Info("retain pvc", "name", pvc.Namespace+"/"+pvc.Name)
+			continue
+		}
+		if err := r.Delete(ctx, pvc); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		customizelog.Info("delete pvc", "name", pvc.Namespace+"/"+pvc.Name)
+	}
+	if logfile.Spec.RetainPolicy == apiv1.RetainPolicyRetain && len(pvcs.Items) > 0 {
+		r.Recorder.Eventf(logfile, corev1.EventTypeNormal, "DataRetained", "Retained %d PersistentVolumeClaims in namespace %s, a new LogFile will reuse them", len(pvcs.Items), StackNamespace(logfile))
+	}
+	return nil
+}
+
+// ReleaseObject 去掉对象上LogFile的ownerReferences和标签，使其在LogFile删除后保留
+func (r *LogFileReconciler) ReleaseObject(ctx context.Context, object client.Object, name types.NamespacedName) error {
+	if err := r.Get(ctx, name, object); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	patch := client.MergeFrom(object.DeepCopyObject().(client.Object))
+	object.SetOwnerReferences(nil)
+	labels := object.GetLabels()
+	delete(labels, OwnerNameLabel)
+	delete(labels, OwnerNamespaceLabel)
+	object.SetLabels(labels)
+	return r.Patch(ctx, object, patch)
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// teardownObjects 返回部署在logging namespace中的组件，LogFile在default namespace，由finalizer清理
+func teardownObjects(logfile *apiv1.LogFile) []client.Object {
+	owner := func(name, app string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: "logging", Labels: map[string]string{
+			OwnerNameLabel: logfile.Name, OwnerNamespaceLabel: logfile.Namespace, "app": app,
+		}}
+	}
+	pod := func(name, app string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "logging", Labels: map[string]string{"logfile-operator": logfile.Name, "app": app}}}
+	}
+	return []client.Object{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "filebeat-sidecar", Namespace: "logging"}},
+		&appsv1.StatefulSet{ObjectMeta: owner("kafka", "kafka")},
+		&appsv1.StatefulSet{ObjectMeta: owner("elasticsearch", "elasticsearch")},
+		&appsv1.Deployment{ObjectMeta: owner("kibana", "kibana")},
+		&corev1.Secret{ObjectMeta: owner(CredentialsSecretName, "")},
+		pod("kafka-0", "kafka"),
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data-elasticsearch-0", Namespace: "logging", Labels: map[string]string{"logfile-operator": logfile.Name}}},
+	}
+}
+
+func TestTeardown(t *testing.T) {
+	for _, policy := range []string{apiv1.RetainPolicyDelete, apiv1.RetainPolicyRetain} {
+		t.Run(policy, func(t *testing.T) {
+			ctx := context.Background()
+			logfile := &apiv1.LogFile{
+				ObjectMeta: metav1.ObjectMeta{Name: "logfile", Namespace: "default"},
+				Spec:       apiv1.LogFileSpec{TargetNamespace: "logging", RetainPolicy: policy},
+			}
+			r := newTestReconciler(t, logfile)
+			for _, object := range teardownObjects(logfile) {
+				if err := r.Create(ctx, object); err != nil {
+					t.Fatal(err)
+				}
+			}
+			exists := func(object client.Object, name string) bool {
+				t.Helper()
+				err := r.Get(ctx, types.NamespacedName{Namespace: "logging", Name: name}, object)
+				if err != nil && !apierrors.IsNotFound(err) {
+					t.Fatal(err)
+				}
+				return err == nil
+			}
+
+			// kafka的pod退出前不删除elasticsearch
+			_, done, err := r.Teardown(ctx, logfile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if done || logfile.Status.Teardown.Step != apiv1.TeardownDeletePipeline || logfile.Status.Phase != apiv1.PhaseTerminating {
+				t.Fatalf("done = %v, teardown = %+v, want waiting for the pipeline pods", done, logfile.Status.Teardown)
+			}
+			if exists(&corev1.ConfigMap{}, "filebeat-sidecar") {
+				t.Errorf("filebeat-sidecar configmap is kept, sidecars would still be injected")
+			}
+			if exists(&appsv1.StatefulSet{}, "kafka") {
+				t.Errorf("kafka is not deleted")
+			}
+			if !exists(&appsv1.StatefulSet{}, "elasticsearch") || !exists(&appsv1.Deployment{}, "kibana") {
+				t.Errorf("elasticsearch or kibana deleted before kafka stopped")
+			}
+
+			if err := r.Delete(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "kafka-0", Namespace: "logging"}}); err != nil {
+				t.Fatal(err)
+			}
+			_, done, err = r.Teardown(ctx, logfile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !done {
+				t.Fatalf("teardown = %+v, want done", logfile.Status.Teardown)
+			}
+			if exists(&appsv1.StatefulSet{}, "elasticsearch") || exists(&appsv1.Deployment{}, "kibana") {
+				t.Errorf("elasticsearch or kibana is kept")
+			}
+
+			// 保留数据时pvc和elasticsearch的密码一起保留
+			retained := policy == apiv1.RetainPolicyRetain
+			if exists(&corev1.PersistentVolumeClaim{}, "data-elasticsearch-0") != retained {
+				t.Errorf("pvc retained = %v, want %v", !retained, retained)
+			}
+			secret := &corev1.Secret{}
+			if exists(secret, CredentialsSecretName) != retained {
+				t.Errorf("credentials retained = %v, want %v", !retained, retained)
+			}
+			if retained && secret.Labels[OwnerNameLabel] != "" {
+				t.Errorf("retained credentials still belong to the LogFile: %v", secret.Labels)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+)
+
+// ElasticsearchURL 返回elasticsearch的访问地址，集群模式使用https
+func ElasticsearchURL(logfile *apiv1.LogFile) string {
+	if logfile.Spec.ElasticsearchMode() == apiv1.ModeCluster {
+		return "https://elasticsearch-master." + StackNamespace(logfile) + ":9200"
+	}
+	return "http://elasticsearch." + StackNamespace(logfile) + ":9200"
+}
+
+// KafkaBootstrapServers 返回kafka的访问地址
+func KafkaBootstrapServers(logfile *apiv1.LogFile) string {
+	if logfile.Spec.KafkaMode() == apiv1.ModeCluster {
+		return "kafka-cluster-headless." + StackNamespace(logfile) + ":9092"
+	}
+	return "kafka." + StackNamespace(logfile) + ":9092"
+}
+
+// LogstashHosts 返回logstash的beats输入地址
+func LogstashHosts(logfile *apiv1.LogFile) string {
+	return "logstash." + StackNamespace(logfile) + ":5044"
+}
+
+// ZookeeperServers 返回zookeeper集群各节点的地址，格式为ZOO_SERVERS环境变量要求的 host:2888:3888::id
+func ZookeeperServers(name, namespace string, replicas int) string {
+	servers := make([]string, 0, replicas)
+	for i := 0; i < replicas; i++ {
+		servers = append(servers, fmt.Sprintf("%s-%d.%s-headless.%s.svc.cluster.local:2888:3888::%d", name, i, name, namespace, i+1))
+	}
+	return strings.Join(servers, " ")
+}
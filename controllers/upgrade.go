@@ -0,0 +1,210 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ElasticsearchVersion 返回elasticsearch期望的版本
+func ElasticsearchVersion(logfile *apiv1.LogFile) string {
+	if logfile.Spec.Version != "" {
+		return logfile.Spec.Version
+	}
+	return apiv1.DefaultVersion
+}
+
+// StackVersion 返回kibana、logstash、filebeat使用的版本
+// elasticsearch升级完成前继续使用elasticsearch当前运行的版本，保证按elasticsearch、kibana、logstash和beats的顺序升级
+func StackVersion(logfile *apiv1.LogFile) string {
+	if logfile.Status.Version != "" {
+		return logfile.Status.Version
+	}
+	return ElasticsearchVersion(logfile)
+}
+
+// VersionAtLeast 判断版本是否不低于min，无法解析时按默认版本处理
+func VersionAtLeast(v, min string) bool {
+	parsed, err := version.ParseGeneric(v)
+	if err != nil {
+		parsed = version.MustParseGeneric(apiv1.DefaultVersion)
+	}
+	return parsed.AtLeast(version.MustParseGeneric(min))
+}
+
+// ElasticsearchRollingUpgrade 逐个重启elasticsearch集群中模板已过期的pod
+// statefulset使用OnDelete更新策略，由operator在集群健康状态为green时删除旧pod，先重启非master节点组，
+// 每个节点组从序号最大的pod开始，删除前暂停副本分片的分配并flush，新pod启动后恢复分配，全部完成后返回true
+func (r *LogFileReconciler) ElasticsearchRollingUpgrade(ctx context.Context, logfile *apiv1.LogFile, name types.NamespacedName, nodesets []apiv1.NodeSet, caSecret string, password string) (bool, error) {
+	customizelog := logger.WithValues("func", "ElasticsearchRollingUpgrade")
+
+	pods, err := r.ComponentPods(ctx, logfile, name.Name)
+	if err != nil {
+		return false, err
+	}
+	ordered := append([]apiv1.NodeSet{}, nodesets...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return !ordered[i].MasterEligible() && ordered[j].MasterEligible()
+	})
+	outdated := []corev1.Pod{}
+	settled := true
+	for _, nodeset := range ordered {
+		statefulset := &appsv1.StatefulSet{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: name.Namespace, Name: NodeSetStatefulSetName(name.Name, nodeset.Name)}, statefulset); err != nil {
+			return false, err
+		}
+		// 等待statefulset控制器计算出新的revision
+		if statefulset.Status.ObservedGeneration < statefulset.Generation || statefulset.Status.UpdateRevision == "" {
+			return false, nil
+		}
+		owned := StatefulSetPods(pods, statefulset)
+		if int32(len(owned)) != pointer.Int32Deref(statefulset.Spec.Replicas, 1) {
+			settled = false
+		}
+		sort.Slice(owned, func(i, j int) bool {
+			return podOrdinal(owned[i].Name) > podOrdinal(owned[j].Name)
+		})
+		for _, pod := range owned {
+			if pod.Labels[appsv1.ControllerRevisionHashLabelKey] != statefulset.Status.UpdateRevision {
+				outdated = append(outdated, pod)
+			}
+		}
+	}
+
+	esclient, err := r.NewElasticsearchClient(ctx, name, caSecret, "elastic", password)
+	if err != nil {
+		return false, err
+	}
+	// 没有节点等待重启时总是恢复分片分配，接口是幂等的
+	// 记录重启节点的status写回失败时，也不会一直只分配主分片导致副本无法恢复
+	if len(outdated) == 0 && logfile.Status.Upgrade == nil {
+		if err := esclient.SetAllocation(ctx, ""); err != nil {
+			customizelog.Info("enable shard allocation failed", "error", err.Error())
+		}
+		logfile.Status.Version = ElasticsearchVersion(logfile)
+		return true, nil
+	}
+	// 重启的pod已经以新模板运行，恢复分片分配，否则新节点上的副本无法恢复，集群无法变为green
+	if upgrade := logfile.Status.Upgrade; upgrade != nil && upgrade.Pod != "" {
+		restarted := &corev1.Pod{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: name.Namespace, Name: upgrade.Pod}, restarted); client.IgnoreNotFound(err) != nil {
+			return false, err
+		} else if err != nil || restarted.DeletionTimestamp != nil || restarted.Status.Phase != corev1.PodRunning {
+			customizelog.Info("等待pod重新创建", "pod", upgrade.Pod)
+			return false, nil
+		}
+		upgrade.Pod = ""
+	}
+	if err := esclient.SetAllocation(ctx, ""); err != nil {
+		customizelog.Info("enable shard allocation failed", "error", err.Error())
+		return false, nil
+	}
+
+	// 所有pod就绪并且集群为green后才重启下一个
+	if !settled {
+		return false, nil
+	}
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || !podReady(&pod) {
+			customizelog.Info("等待pod就绪", "pod", pod.Name)
+			return false, nil
+		}
+	}
+	health, err := esclient.ClusterHealth(ctx)
+	if err != nil {
+		customizelog.Info("elasticsearch cluster health unavailable", "error", err.Error())
+		return false, nil
+	}
+	if health.Status != "green" {
+		customizelog.Info("等待集群状态变为green", "status", health.Status, "unassigned", health.UnassignedShards)
+		return false, nil
+	}
+
+	if len(outdated) == 0 {
+		logfile.Status.Upgrade = nil
+		logfile.Status.Version = ElasticsearchVersion(logfile)
+		r.Recorder.Eventf(logfile, corev1.EventTypeNormal, "UpgradeCompleted", "Elasticsearch %s is running version %s", name.Name, logfile.Status.Version)
+		return true, nil
+	}
+
+	pod := &outdated[0]
+	// 只分配主分片，避免重启期间在其他节点上重建副本
+	if err := esclient.SetAllocation(ctx, "primaries"); err != nil {
+		return false, err
+	}
+	if err := esclient.Do(ctx, http.MethodPost, "/_flush", nil, nil); err != nil {
+		customizelog.Info("flush failed", "error", err.Error())
+	}
+	if err := r.Delete(ctx, pod, client.Preconditions{UID: &pod.UID}); client.IgnoreNotFound(err) != nil {
+		return false, err
+	}
+	logfile.Status.Upgrade = &apiv1.UpgradeStatus{
+		Pod:       pod.Name,
+		StartTime: metav1.Now(),
+	}
+	customizelog.Info("restart pod", "pod", pod.Name, "remaining", len(outdated))
+	r.Recorder.Eventf(logfile, corev1.EventTypeNormal, "RestartingPod", "Restarting %s to apply the updated template, %d pods remaining", pod.Name, len(outdated))
+	return false, nil
+}
+
+// SetAllocation 设置集群的分片分配策略，为空时恢复默认
+func (c *ElasticsearchClient) SetAllocation(ctx context.Context, enable string) error {
+	var value interface{}
+	if enable != "" {
+		value = enable
+	}
+	return c.Do(ctx, http.MethodPut, "/_cluster/settings", map[string]interface{}{
+		"persistent": map[string]interface{}{
+			"cluster.routing.allocation.enable": value,
+		},
+	}, nil)
+}
+
+// StatefulSetPods 返回属于statefulset的pod
+func StatefulSetPods(pods []corev1.Pod, statefulset *appsv1.StatefulSet) []corev1.Pod {
+	owned := []corev1.Pod{}
+	for _, pod := range pods {
+		if owner := metav1.GetControllerOf(&pod); owner != nil && owner.UID == statefulset.UID {
+			owned = append(owned, pod)
+		}
+	}
+	return owned
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podOrdinal 返回statefulset中pod的序号
+func podOrdinal(name string) int {
+	ordinal, err := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+	if err != nil {
+		return -1
+	}
+	return ordinal
+}
+
+// UpgradeMessage 滚动重启过程中组件condition的说明
+func UpgradeMessage(logfile *apiv1.LogFile, name string) string {
+	if logfile.Status.Upgrade != nil && logfile.Status.Upgrade.Pod != "" {
+		return fmt.Sprintf("rolling restart of %s to version %s, restarting %s", name, ElasticsearchVersion(logfile), logfile.Status.Upgrade.Pod)
+	}
+	return fmt.Sprintf("rolling restart of %s to version %s, waiting for cluster health green", name, ElasticsearchVersion(logfile))
+}
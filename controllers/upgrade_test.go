@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	apiv1 "github.com/huisebug/logfile-operator/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// fakeElasticsearch 记录分片分配的设置，并返回指定的集群健康状态
+type fakeElasticsearch struct {
+	sync.Mutex
+	health     string
+	allocation []interface{}
+	flushed    int
+}
+
+func (f *fakeElasticsearch) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	switch req.URL.Path {
+	case "/_cluster/settings":
+		settings := map[string]map[string]interface{}{}
+		json.NewDecoder(req.Body).Decode(&settings)
+		f.allocation = append(f.allocation, settings["persistent"]["cluster.routing.allocation.enable"])
+	case "/_cluster/health":
+		json.NewEncoder(w).Encode(ElasticsearchClusterHealth{Status: f.health})
+		return
+	case "/_flush":
+		f.flushed++
+	}
+	w.Write([]byte("{}"))
+}
+
+// lastAllocation 返回最后一次设置的分片分配策略，恢复默认时为nil
+func (f *fakeElasticsearch) lastAllocation() interface{} {
+	f.Lock()
+	defer f.Unlock()
+	if len(f.allocation) == 0 {
+		return "unset"
+	}
+	return f.allocation[len(f.allocation)-1]
+}
+
+// serveElasticsearch 把operator访问elasticsearch服务的请求转发到本地的fake server
+func serveElasticsearch(t *testing.T, es *fakeElasticsearch) {
+	server := httptest.NewServer(es)
+	transport := http.DefaultTransport
+	http.DefaultTransport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		},
+	}
+	t.Cleanup(func() {
+		http.DefaultTransport = transport
+		server.Close()
+	})
+}
+
+func elasticsearchPod(statefulset *appsv1.StatefulSet, name, revision string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: statefulset.Namespace,
+			Labels: map[string]string{
+				"logfile-operator":                    "logfile",
+				"app":                                 "elasticsearch",
+				appsv1.ControllerRevisionHashLabelKey: revision,
+			},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(statefulset, appsv1.SchemeGroupVersion.WithKind("StatefulSet"))},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func TestElasticsearchRollingUpgrade(t *testing.T) {
+	ctx := context.Background()
+	es := &fakeElasticsearch{health: "green"}
+	serveElasticsearch(t, es)
+
+	replicas := int32(3)
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "elasticsearch", Namespace: "logging", UID: "sts-uid", Generation: 2},
+		Spec:       appsv1.StatefulSetSpec{Replicas: &replicas},
+		Status:     appsv1.StatefulSetStatus{ObservedGeneration: 2, Replicas: 3, UpdateRevision: "rev-2"},
+	}
+	r := newTestReconciler(t, statefulset,
+		elasticsearchPod(statefulset, "elasticsearch-0", "rev-1"),
+		elasticsearchPod(statefulset, "elasticsearch-1", "rev-1"),
+		elasticsearchPod(statefulset, "elasticsearch-2", "rev-1"),
+	)
+	logfile := &apiv1.LogFile{
+		ObjectMeta: metav1.ObjectMeta{Name: "logfile", Namespace: "logging"},
+		Spec:       apiv1.LogFileSpec{Version: "8.6.0"},
+		Status:     apiv1.LogFileStatus{Version: "8.5.0"},
+	}
+	name := types.NamespacedName{Namespace: "logging", Name: "elasticsearch"}
+	nodesets := []apiv1.NodeSet{{}}
+
+	upgrade := func() bool {
+		t.Helper()
+		done, err := r.ElasticsearchRollingUpgrade(ctx, logfile, name, nodesets, "", "changeme")
+		if err != nil {
+			t.Fatalf("ElasticsearchRollingUpgrade() error = %v", err)
+		}
+		return done
+	}
+	exists := func(pod string) bool {
+		t.Helper()
+		err := r.Get(ctx, types.NamespacedName{Namespace: "logging", Name: pod}, &corev1.Pod{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+	recreate := func(pod string) {
+		t.Helper()
+		if exists(pod) {
+			if err := r.Delete(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "logging", Name: pod}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.Create(ctx, elasticsearchPod(statefulset, pod, "rev-2")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 从序号最大的pod开始重启，删除前只分配主分片并flush
+	if upgrade() {
+		t.Fatal("upgrade completed with outdated pods")
+	}
+	if exists("elasticsearch-2") || !exists("elasticsearch-1") || !exists("elasticsearch-0") {
+		t.Fatal("expected only elasticsearch-2 to be deleted")
+	}
+	if logfile.Status.Upgrade == nil || logfile.Status.Upgrade.Pod != "elasticsearch-2" {
+		t.Fatalf("Status.Upgrade = %+v, want elasticsearch-2", logfile.Status.Upgrade)
+	}
+	if es.lastAllocation() != "primaries" || es.flushed != 1 {
+		t.Fatalf("allocation = %v, flushed = %d, want primaries and one flush", es.lastAllocation(), es.flushed)
+	}
+
+	// pod重新创建前不做任何操作
+	if upgrade() || logfile.Status.Upgrade.Pod != "elasticsearch-2" || es.lastAllocation() != "primaries" {
+		t.Fatal("expected to wait for elasticsearch-2 to be recreated")
+	}
+
+	// 新pod启动后恢复分片分配，集群恢复green之前不重启下一个
+	recreate("elasticsearch-2")
+	es.health = "yellow"
+	if upgrade() {
+		t.Fatal("upgrade completed while cluster is yellow")
+	}
+	if es.lastAllocation() != nil || logfile.Status.Upgrade.Pod != "" || !exists("elasticsearch-1") {
+		t.Fatalf("allocation = %v, Status.Upgrade = %+v, want allocation restored and no restart", es.lastAllocation(), logfile.Status.Upgrade)
+	}
+
+	es.health = "green"
+	if upgrade() || exists("elasticsearch-1") || logfile.Status.Upgrade.Pod != "elasticsearch-1" {
+		t.Fatal("expected elasticsearch-1 to be restarted once the cluster is green")
+	}
+	if logfile.Status.Version != "8.5.0" || StackVersion(logfile) != "8.5.0" {
+		t.Fatalf("stack version = %s, want 8.5.0 until elasticsearch is upgraded", StackVersion(logfile))
+	}
+
+	// 所有pod都使用新模板后完成升级，kibana等组件才切换到新版本
+	recreate("elasticsearch-1")
+	recreate("elasticsearch-0")
+	if !upgrade() {
+		t.Fatal("upgrade not completed with all pods updated")
+	}
+	if logfile.Status.Upgrade != nil || logfile.Status.Version != "8.6.0" || StackVersion(logfile) != "8.6.0" {
+		t.Fatalf("Status.Upgrade = %+v, Status.Version = %s, want completed at 8.6.0", logfile.Status.Upgrade, logfile.Status.Version)
+	}
+}
+
+func TestElasticsearchRollingUpgradeWaitsForRevision(t *testing.T) {
+	statefulset := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "elasticsearch", Namespace: "logging", UID: "sts-uid", Generation: 3},
+		Status:     appsv1.StatefulSetStatus{ObservedGeneration: 2, Replicas: 3, UpdateRevision: "rev-2"},
+	}
+	r := newTestReconciler(t, statefulset, elasticsearchPod(statefulset, "elasticsearch-0", "rev-1"))
+	logfile := &apiv1.LogFile{ObjectMeta: metav1.ObjectMeta{Name: "logfile", Namespace: "logging"}, Spec: apiv1.LogFileSpec{Version: "8.6.0"}}
+
+	// statefulset控制器还没有计算出新的revision时，不访问elasticsearch也不删除pod
+	done, err := r.ElasticsearchRollingUpgrade(context.Background(), logfile, types.NamespacedName{Namespace: "logging", Name: "elasticsearch"}, []apiv1.NodeSet{{}}, "", "changeme")
+	if done || err != nil {
+		t.Fatalf("ElasticsearchRollingUpgrade() = %v, %v, want false, nil", done, err)
+	}
+	if err := r.Get(context.Background(), types.NamespacedName{Namespace: "logging", Name: "elasticsearch-0"}, &corev1.Pod{}); err != nil {
+		t.Fatalf("pod deleted before the revision was observed: %v", err)
+	}
+}
+
+func TestVersionedImages(t *testing.T) {
+	logfile := &apiv1.LogFile{Spec: apiv1.LogFileSpec{Version: "8.6.0"}, Status: apiv1.LogFileStatus{Version: "8.5.0"}}
+	options := ImageOptions{}
+
+	// elasticsearch先升级，其他组件在elasticsearch升级完成前保持原版本
+	if image := options.Image(logfile, apiv1.ComponentElasticsearch); image != DefaultImageRegistry+"/logfile-operator:elasticsearch-8.6.0" {
+		t.Errorf("elasticsearch image = %s", image)
+	}
+	if image := options.Image(logfile, apiv1.ComponentKibana); image != DefaultImageRegistry+"/logfile-operator:kibana-8.5.0" {
+		t.Errorf("kibana image = %s", image)
+	}
+	if !VersionAtLeast("8.6.0", "8.5.0") || VersionAtLeast("7.17.3", "8.0.0") || !VersionAtLeast("invalid", apiv1.DefaultVersion) {
+		t.Error("VersionAtLeast() returned an unexpected result")
+	}
+}
@@ -6,7 +6,7 @@ kubectl get MutatingWebhookConfiguration logfile-operator-mutating-webhook-confi
 
 #只替换mhuisebugpod.kb.io webhook相关配置
 sedfile(){
-sed -i "60,100 s/namespaceSelector: {}/namespaceSelector: \\n    matchLabels:\\n      pod-admission-webhook-injection: enabled/g" MutatingWebhookConfiguration.yaml
+sed -i "60,100 s/namespaceSelector: {}/namespaceSelector: \\n    matchExpressions:\\n    - key: pod-admission-webhook-injection\\n      operator: In\\n      values: [enabled, force]\\n    - key: kubernetes.io\\/metadata.name\\n      operator: NotIn\\n      values: [kube-system, kube-public, kube-node-lease, logfile-operator-system]/g" MutatingWebhookConfiguration.yaml
 sed -i "60,100 s/scope: '\*'/scope: Namespaced/g" MutatingWebhookConfiguration.yaml
 }
 run(){
@@ -17,4 +17,3 @@ rm -rf MutatingWebhookConfiguration.yaml
 genfile
 sedfile
 run
-
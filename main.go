@@ -24,10 +24,13 @@ import (
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -53,11 +56,32 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var imageRegistry, imageTagSuffix, imagePullSecrets string
+	var injectionAllowNamespaces, injectionDenyNamespaces, injectionDefaultPaths, sidecarMode string
+	var rejectInvalidAnnotations bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	// 镜像仓库配置，启动参数优先，未设置时读取环境变量
+	flag.StringVar(&imageRegistry, "image-registry", os.Getenv("LOGFILE_IMAGE_REGISTRY"),
+		"Registry prefix that replaces "+controllers.DefaultImageRegistry+" in default images, e.g. harbor.example.com/logging.")
+	flag.StringVar(&imageTagSuffix, "image-tag-suffix", os.Getenv("LOGFILE_IMAGE_TAG_SUFFIX"),
+		"Suffix appended to the tags of default images.")
+	flag.StringVar(&imagePullSecrets, "image-pull-secrets", os.Getenv("LOGFILE_IMAGE_PULL_SECRETS"),
+		"Comma separated imagePullSecrets added to components and injected sidecars.")
+	// sidecar注入策略，系统namespace始终不注入
+	flag.StringVar(&injectionAllowNamespaces, "injection-allow-namespaces", os.Getenv("LOGFILE_INJECTION_ALLOW_NAMESPACES"),
+		"Comma separated namespaces where sidecars may be injected, all namespaces when empty. Namespaces still need the injection label.")
+	flag.StringVar(&injectionDenyNamespaces, "injection-deny-namespaces", os.Getenv("LOGFILE_INJECTION_DENY_NAMESPACES"),
+		"Comma separated namespaces where sidecars are never injected, takes precedence over the allow list.")
+	flag.StringVar(&injectionDefaultPaths, "injection-default-paths", os.Getenv("LOGFILE_INJECTION_DEFAULT_PATHS"),
+		"Comma separated log paths collected from pods without log annotations in namespaces with injection enabled or forced.")
+	flag.StringVar(&sidecarMode, "sidecar-mode", controllers.SidecarModeAuto,
+		"Default filebeat sidecar mode: auto (native for Job pods), native (restartable init container, falls back to a container that exits with the workload) or container.")
+	flag.BoolVar(&rejectInvalidAnnotations, "reject-invalid-annotations", os.Getenv("LOGFILE_REJECT_INVALID_ANNOTATIONS") == "true",
+		"Reject pods with invalid log annotations instead of skipping the invalid routes with a warning.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -66,13 +90,38 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	images := controllers.ImageOptions{
+		Registry:    imageRegistry,
+		TagSuffix:   imageTagSuffix,
+		PullSecrets: controllers.ParseImagePullSecrets(imagePullSecrets),
+	}
+	defaultPaths, err := controllers.ParseLogPaths(injectionDefaultPaths)
+	if err != nil {
+		setupLog.Error(err, "invalid default log paths", "injection-default-paths", injectionDefaultPaths)
+		os.Exit(1)
+	}
+	policy := controllers.InjectionPolicy{
+		AllowNamespaces:          controllers.ParseNamespaces(injectionAllowNamespaces),
+		DenyNamespaces:           controllers.ParseNamespaces(injectionDenyNamespaces),
+		DefaultPaths:             defaultPaths,
+		DefaultSidecarMode:       sidecarMode,
+		RejectInvalidAnnotations: rejectInvalidAnnotations,
+	}
+	if !controllers.ValidSidecarMode(sidecarMode) {
+		setupLog.Error(nil, "unsupported sidecar mode", "sidecar-mode", sidecarMode, "supported", controllers.SidecarModes)
+		os.Exit(1)
+	}
+
+	config := ctrl.GetConfigOrDie()
+	mgr, err := ctrl.NewManager(config, ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "b4b1c060.huisebug.org",
+		// configmap和secret不经过缓存读取，控制器只监听它们的元数据，避免缓存集群中所有secret的内容
+		ClientDisableCacheFor: []client.Object{&corev1.ConfigMap{}, &corev1.Secret{}},
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -91,8 +140,10 @@ func main() {
 	}
 
 	if err = (&controllers.LogFileReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("logfile-controller"),
+		Images:   images,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "LogFile")
 		os.Exit(1)
@@ -101,9 +152,20 @@ func main() {
 		setupLog.Error(err, "unable to create webhook", "webhook", "LogFile")
 		os.Exit(1)
 	}
+	if err = (&apiv1.LogCollector{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "LogCollector")
+		os.Exit(1)
+	}
 
-	// sidecar注入
-	mgr.GetWebhookServer().Register("/mutate-huisebug-core-v1-pod", &webhook.Admission{Handler: controllers.NewPodSideCarMutate(mgr.GetClient())})
+	// sidecar注入，集群是否支持原生sidecar只在启动时检测一次
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		setupLog.Error(err, "unable to create discovery client")
+		os.Exit(1)
+	}
+	nativeSidecarSupported := controllers.SupportsNativeSidecar(discoveryClient)
+	setupLog.Info("detected native sidecar support", "supported", nativeSidecarSupported)
+	mgr.GetWebhookServer().Register("/mutate-huisebug-core-v1-pod", &webhook.Admission{Handler: controllers.NewPodSideCarMutate(mgr.GetClient(), images, policy, nativeSidecarSupported)})
 
 	//+kubebuilder:scaffold:builder
 